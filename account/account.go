@@ -3,55 +3,208 @@
 package account
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
+	"github.com/0xSherlokMo/banking-system-challenge/money"
 	"github.com/google/uuid"
 )
 
 const (
 	AccountIdPrefix = "account-"
+
+	MaxNameLength      = 100
+	MaxReferenceLength = 64
+	MaxChannelLength   = 32
+	MaxMemoLength      = 140
 )
 
 var (
 	ErrInvalidAmount     = errors.New("invalid amount")
 	ErrInsufficientFunds = errors.New("insufficient funds")
+	ErrSameAccount       = errors.New("cannot transfer money to the same account")
+	ErrAccountClosed     = errors.New("account is closed")
+	ErrNonZeroBalance    = errors.New("account balance is not zero")
+	ErrInvalidName       = errors.New("invalid name")
+	ErrInvalidReference  = errors.New("invalid external reference")
+	ErrNegativeBalance   = errors.New("opening balance cannot be negative")
+	ErrDuplicateRef      = errors.New("external reference is already used by another account")
+	ErrInvalidChannel    = errors.New("invalid channel")
+	ErrInvalidMemo       = errors.New("invalid memo")
+	ErrCurrencyMismatch  = errors.New("accounts have different currencies, a conversion must be requested")
 )
 
 type Account struct {
-	ID      uuid.UUID `json:"id"`
-	Name    string    `json:"name"`
-	Balance float64   `json:"balance,string"`
+	ID       uuid.UUID      `json:"id"`
+	Name     string         `json:"name"`
+	Currency money.Currency `json:"currency,omitempty"`
+	Balance  money.Money    `json:"balance"`
+	ClosedAt *time.Time     `json:"closed_at,omitempty"`
+
+	// ExternalRef is the client's own id for the account, unique across accounts.
+	ExternalRef string `json:"external_ref,omitempty"`
 }
 
-func NewAccount(name string, balance float64) *Account {
+func NewAccount(name string, currency money.Currency, balance money.Money) *Account {
 	return &Account{
-		ID:      uuid.New(),
-		Name:    name,
-		Balance: balance,
+		ID:       uuid.New(),
+		Name:     name,
+		Currency: currency,
+		Balance:  balance,
+	}
+}
+
+// Denomination returns the currency of the account, DefaultCurrency for the accounts stored before currencies were added.
+func (a *Account) Denomination() money.Currency {
+	if a.Currency == "" {
+		return money.DefaultCurrency
+	}
+	return a.Currency
+}
+
+// UnmarshalJSON decodes the balance at the scale of the account currency, so a recovered account
+// keeps the minor units it was stored with, ex: 3 for KWD and 0 for JPY.
+func (a *Account) UnmarshalJSON(data []byte) error {
+	type stored Account
+	if err := json.Unmarshal(data, (*stored)(a)); err != nil {
+		return err
+	}
+	// seeds can write codes in any case, the seed decoder validates them itself.
+	if currency, err := money.ParseCurrency(string(a.Denomination())); err != nil || currency != a.Denomination() {
+		return nil
 	}
+	balance, err := a.Denomination().Amount(a.Balance)
+	if err != nil {
+		return fmt.Errorf("balance of %s: %w", a.Denomination(), err)
+	}
+	a.Balance = balance
+	return nil
 }
 
 func (a *Account) GetID() string {
 	return fmt.Sprintf("%s-%s", AccountIdPrefix, a.ID.String())
 }
 
+// ValidateName accepts names of 1 to MaxNameLength characters made of letters, digits, spaces and . , ' & -
+// The name is expected to be trimmed already.
+func ValidateName(name string) error {
+	length := utf8.RuneCountInString(name)
+	if length == 0 || length > MaxNameLength {
+		return fmt.Errorf("%w: should be between 1 and %d characters", ErrInvalidName, MaxNameLength)
+	}
+	if name != strings.TrimSpace(name) {
+		return fmt.Errorf("%w: cannot start or end with spaces", ErrInvalidName)
+	}
+	for _, char := range name {
+		if unicode.IsLetter(char) || unicode.IsMark(char) || unicode.IsDigit(char) || strings.ContainsRune(" .,'&-", char) {
+			continue
+		}
+		return fmt.Errorf("%w: %q is not allowed", ErrInvalidName, char)
+	}
+	return nil
+}
+
+// ValidateReference accepts external references of 1 to MaxReferenceLength ASCII letters, digits and . _ : -
+func ValidateReference(ref string) error {
+	if len(ref) == 0 || len(ref) > MaxReferenceLength {
+		return fmt.Errorf("%w: should be between 1 and %d characters", ErrInvalidReference, MaxReferenceLength)
+	}
+	for _, char := range ref {
+		if char < unicode.MaxASCII && (unicode.IsLetter(char) || unicode.IsDigit(char) || strings.ContainsRune("._:-", char)) {
+			continue
+		}
+		return fmt.Errorf("%w: %q is not allowed", ErrInvalidReference, char)
+	}
+	return nil
+}
+
+// Closed reports whether the account was closed, closed accounts can't send or receive money.
+func (a *Account) Closed() bool {
+	return a.ClosedAt != nil
+}
+
 type TransferRequest struct {
-	Sender   string  `json:"sender"`
-	Reciever string  `json:"reciever"`
-	Amount   float64 `json:"amount"`
+	Sender   string      `json:"sender"`
+	Reciever string      `json:"reciever"`
+	Amount   money.Money `json:"amount"`
+
+	// Convert allows a transfer between accounts in different currencies, the amount is in the sender's currency.
+	Convert bool `json:"convert"`
+	// QuoteID converts the amount at the rate locked by the quote, it implies Convert.
+	QuoteID string `json:"quote_id"`
+
+	// SenderVersion is the version the sender account must still be at for the transfer to go through,
+	// 0 transfers whatever its version is.
+	SenderVersion uint64 `json:"-"`
+}
+
+// Converts reports whether the transfer asked for a currency conversion.
+func (t TransferRequest) Converts() bool {
+	return t.Convert || t.QuoteID != ""
 }
 
 // ValidateAmount validates the transfer request amount against the sender's balance.
 // Returns an error if the amount is invalid or insufficient.
 func (t TransferRequest) ValidateAmount(sender *Account) error {
-	if t.Amount <= 0 {
+	if t.Amount.Sign() <= 0 {
 		return ErrInvalidAmount
 	}
 
-	if t.Amount > sender.Balance {
+	if t.Amount.Cmp(sender.Balance) > 0 {
 		return ErrInsufficientFunds
 	}
 
 	return nil
 }
+
+// ExternalRequest moves money between an account and a counterparty outside the bank.
+type ExternalRequest struct {
+	Account string      `json:"-"`
+	Amount  money.Money `json:"amount"`
+
+	// Reference is the counterparty's id of the movement, like a bank reference or a receipt number.
+	Reference string `json:"reference"`
+	// Channel is how the money moved, like cash, card or wire.
+	Channel string `json:"channel"`
+	Memo    string `json:"memo"`
+
+	// Version is the version the account must still be at for the money to move, 0 moves it whatever its version is.
+	Version uint64 `json:"-"`
+}
+
+// Validate checks the counterparty details, the amount is validated against the account once it's locked.
+func (e ExternalRequest) Validate() error {
+	if err := ValidateReference(e.Reference); err != nil {
+		return err
+	}
+	if len(e.Channel) == 0 || len(e.Channel) > MaxChannelLength {
+		return fmt.Errorf("%w: should be between 1 and %d characters", ErrInvalidChannel, MaxChannelLength)
+	}
+	for _, char := range e.Channel {
+		if (char < 'a' || char > 'z') && (char < '0' || char > '9') && char != '_' {
+			return fmt.Errorf("%w: %q is not allowed, use lowercase letters, digits and _", ErrInvalidChannel, char)
+		}
+	}
+	if utf8.RuneCountInString(e.Memo) > MaxMemoLength {
+		return fmt.Errorf("%w: should be at most %d characters", ErrInvalidMemo, MaxMemoLength)
+	}
+	return nil
+}
+
+// ValidateDeposit validates the amount credited to the account.
+func (e ExternalRequest) ValidateDeposit() error {
+	if e.Amount.Sign() <= 0 {
+		return ErrInvalidAmount
+	}
+	return nil
+}
+
+// ValidateWithdrawal validates the amount debited from the account against its balance, like a transfer.
+func (e ExternalRequest) ValidateWithdrawal(owner *Account) error {
+	return TransferRequest{Amount: e.Amount}.ValidateAmount(owner)
+}
@@ -2,22 +2,30 @@ package calculator
 
 import (
 	"math"
-	"math/big"
-	"strconv"
-)
 
-const (
-	decimalPlaces = 1e6
+	"github.com/0xSherlokMo/banking-system-challenge/money"
 )
 
-func PreciseAdd(first float64, second float64) float64 {
-	result, _ := strconv.ParseFloat(
-		new(big.Float).
-			Add(
-				new(big.Float).SetFloat64(first),
-				new(big.Float).SetFloat64(second)).
-			String(),
-		64)
-	result = math.Round(result*decimalPlaces) / decimalPlaces
-	return result
+// PreciseAdd adds two amounts exactly, aligning them to the larger scale first.
+// Returns money.ErrOverflow if the result does not fit in the minor units range.
+func PreciseAdd(first money.Money, second money.Money) (money.Money, error) {
+	first, second, err := money.Align(first, second)
+	if err != nil {
+		return money.Money{}, err
+	}
+
+	a, b := first.Units(), second.Units()
+	if (b > 0 && a > math.MaxInt64-b) || (b < 0 && a < math.MinInt64-b) {
+		return money.Money{}, money.ErrOverflow
+	}
+
+	return money.New(a+b, first.Scale()), nil
+}
+
+// PreciseSub subtracts second from first exactly.
+func PreciseSub(first money.Money, second money.Money) (money.Money, error) {
+	if second.Units() == math.MinInt64 {
+		return money.Money{}, money.ErrOverflow
+	}
+	return PreciseAdd(first, second.Neg())
 }
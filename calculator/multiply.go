@@ -0,0 +1,49 @@
+package calculator
+
+import (
+	"math/big"
+
+	"github.com/0xSherlokMo/banking-system-challenge/money"
+)
+
+// PreciseMul multiplies the amount by an exact decimal factor, like an exchange rate, and rounds the product
+// half to even to the given scale. The product is computed exactly, so it's only rounded once.
+// Returns money.ErrOverflow if the result does not fit in the minor units range.
+func PreciseMul(amount money.Money, factor *big.Rat, scale uint8) (money.Money, error) {
+	product := new(big.Rat).SetFrac(big.NewInt(amount.Units()), pow10(amount.Scale()))
+	product.Mul(product, factor)
+
+	units := RoundUnits(product, scale)
+	if !units.IsInt64() {
+		return money.Money{}, money.ErrOverflow
+	}
+	return money.New(units.Int64(), scale), nil
+}
+
+// Round rounds the value half to even to the given number of decimal places.
+func Round(value *big.Rat, scale uint8) *big.Rat {
+	return new(big.Rat).SetFrac(RoundUnits(value, scale), pow10(scale))
+}
+
+// RoundUnits returns the value in units of 10^-scale, rounded half to even.
+func RoundUnits(value *big.Rat, scale uint8) *big.Int {
+	scaled := new(big.Rat).Mul(value, new(big.Rat).SetInt(pow10(scale)))
+
+	quotient, remainder := new(big.Int).QuoRem(scaled.Num(), scaled.Denom(), new(big.Int))
+	// compare twice the remainder with the denominator to find which side of the half the value is on.
+	twice := new(big.Int).Abs(remainder)
+	twice.Lsh(twice, 1)
+	switch twice.Cmp(scaled.Denom()) {
+	case 1:
+		quotient.Add(quotient, big.NewInt(int64(scaled.Sign())))
+	case 0:
+		if quotient.Bit(0) == 1 {
+			quotient.Add(quotient, big.NewInt(int64(scaled.Sign())))
+		}
+	}
+	return quotient
+}
+
+func pow10(n uint8) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
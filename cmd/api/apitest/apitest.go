@@ -0,0 +1,38 @@
+// Package apitest sets up the context and the accounts the HTTP and gRPC tests of the API run against.
+package apitest
+
+import (
+	"fmt"
+
+	"github.com/0xSherlokMo/banking-system-challenge/account"
+	"github.com/0xSherlokMo/banking-system-challenge/cmd/api/router"
+	"github.com/0xSherlokMo/banking-system-challenge/ctx"
+	"github.com/0xSherlokMo/banking-system-challenge/ledger"
+	"github.com/0xSherlokMo/banking-system-challenge/money"
+	"github.com/gin-gonic/gin"
+)
+
+// NewContext returns an in-memory context with the ledger and the transactions, tests add what else they need.
+func NewContext() *ctx.DefaultContext {
+	return ctx.NewDefaultContext().WithMemoryDB().WithLedger().WithTransactions()
+}
+
+// SeedAccounts opens count accounts in the default currency with the given balance, recorded in the ledger.
+func SeedAccounts(app *ctx.DefaultContext, balance string, count int) []*account.Account {
+	var accounts []*account.Account
+	for i := 0; i < count; i++ {
+		seeded := account.NewAccount(fmt.Sprintf("account-%d", i), money.DefaultCurrency, money.MustParse(balance))
+		app.MemoryDB().Setnx(seeded.GetID(), seeded)
+		app.Ledger().Record(ledger.NewOpeningEntry(seeded.GetID(), seeded.Denomination(), seeded.Balance))
+		accounts = append(accounts, seeded)
+	}
+	return accounts
+}
+
+// NewAccountEngine returns a gin engine in test mode serving the account router, other routers can be installed on it.
+func NewAccountEngine(app *ctx.DefaultContext) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	router.InstallAccountRouter(engine, app)
+	return engine
+}
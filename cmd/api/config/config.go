@@ -0,0 +1,388 @@
+// Package config loads the settings of the API binary. Every setting has a default, which is overridden
+// in order by the config file, the environment variables and the command line flags.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/0xSherlokMo/banking-system-challenge/ctx"
+	"github.com/0xSherlokMo/banking-system-challenge/fx"
+	"github.com/0xSherlokMo/banking-system-challenge/idempotency"
+	"github.com/0xSherlokMo/banking-system-challenge/memorydb"
+	"github.com/0xSherlokMo/banking-system-challenge/seed"
+	"github.com/pelletier/go-toml/v2"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	StorageMemory = "memory"
+	StorageWAL    = "wal"
+
+	LogJSON    = "json"
+	LogConsole = "console"
+
+	// redacted replaces the secrets when the config is printed.
+	redacted = "[redacted]"
+)
+
+type Config struct {
+	Server  Server  `yaml:"server" toml:"server"`
+	Log     Log     `yaml:"log" toml:"log"`
+	Storage Storage `yaml:"storage" toml:"storage"`
+	Seed    Seed    `yaml:"seed" toml:"seed"`
+	Limits  Limits  `yaml:"limits" toml:"limits"`
+	Auth    Auth    `yaml:"auth" toml:"auth"`
+	FX      FX      `yaml:"fx" toml:"fx"`
+}
+
+type Server struct {
+	Port              int      `yaml:"port" toml:"port"`
+	GRPCPort          int      `yaml:"grpc_port" toml:"grpc_port"`
+	Mode              string   `yaml:"mode" toml:"mode"`
+	ReadTimeout       Duration `yaml:"read_timeout" toml:"read_timeout"`
+	ReadHeaderTimeout Duration `yaml:"read_header_timeout" toml:"read_header_timeout"`
+	// WriteTimeout doesn't apply to the event streams and WebSockets, they stay open as long as the client is there.
+	WriteTimeout Duration `yaml:"write_timeout" toml:"write_timeout"`
+	IdleTimeout  Duration `yaml:"idle_timeout" toml:"idle_timeout"`
+	// DrainDelay keeps the listeners open while readiness fails, so the load balancers stop sending traffic before they close.
+	// New transfers are refused meanwhile, only reads are served.
+	DrainDelay Duration `yaml:"drain_delay" toml:"drain_delay"`
+	// ShutdownTimeout is how long the in-flight transfers have to finish once the listeners are closed.
+	ShutdownTimeout Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+}
+
+type Log struct {
+	Level  string `yaml:"level" toml:"level"`
+	Format string `yaml:"format" toml:"format"`
+}
+
+type Storage struct {
+	// Backend is memory or wal, wal keeps the records in write-ahead logs under DataDir.
+	// It's wal when only DataDir is set.
+	Backend          string              `yaml:"backend" toml:"backend"`
+	DataDir          string              `yaml:"data_dir" toml:"data_dir"`
+	WALSync          memorydb.SyncPolicy `yaml:"wal_sync" toml:"wal_sync"`
+	WALSyncInterval  Duration            `yaml:"wal_sync_interval" toml:"wal_sync_interval"`
+	SnapshotInterval Duration            `yaml:"snapshot_interval" toml:"snapshot_interval"`
+}
+
+type Seed struct {
+	// Source is parsed by seed.Parse.
+	Source string `yaml:"source" toml:"source"`
+}
+
+type Limits struct {
+	LockTimeout          Duration `yaml:"lock_timeout" toml:"lock_timeout"`
+	LeaseTTL             Duration `yaml:"lease_ttl" toml:"lease_ttl"`
+	IdempotencyRetention Duration `yaml:"idempotency_retention" toml:"idempotency_retention"`
+	SocketInFlight       int      `yaml:"socket_in_flight" toml:"socket_in_flight"`
+}
+
+type Auth struct {
+	AdminToken string `yaml:"admin_token" toml:"admin_token"`
+}
+
+type FX struct {
+	// RatesFile is a JSON array of rates loaded on startup, the rates can also be set with the admin API.
+	RatesFile string `yaml:"rates_file" toml:"rates_file"`
+	// SpreadBPS is taken off the mid rates that don't set their own spread, in basis points.
+	SpreadBPS int      `yaml:"spread_bps" toml:"spread_bps"`
+	QuoteTTL  Duration `yaml:"quote_ttl" toml:"quote_ttl"`
+}
+
+// Default returns the settings used when nothing overrides them.
+func Default() Config {
+	return Config{
+		Server: Server{
+			Port:              8080,
+			GRPCPort:          9090,
+			Mode:              "release",
+			ReadTimeout:       Duration(30 * time.Second),
+			ReadHeaderTimeout: Duration(5 * time.Second),
+			WriteTimeout:      Duration(30 * time.Second),
+			IdleTimeout:       Duration(2 * time.Minute),
+			ShutdownTimeout:   Duration(15 * time.Second),
+		},
+		Log: Log{
+			Level:  "info",
+			Format: LogJSON,
+		},
+		Storage: Storage{
+			WALSync:         memorydb.SyncInterval,
+			WALSyncInterval: Duration(memorydb.DefaultSyncInterval),
+		},
+		Seed: Seed{
+			Source: seed.ChallengeURL,
+		},
+		Limits: Limits{
+			LockTimeout:          Duration(ctx.DefaultLockTimeout),
+			LeaseTTL:             Duration(memorydb.DefaultLeaseTTL),
+			IdempotencyRetention: Duration(idempotency.DefaultRetention),
+			SocketInFlight:       ctx.DefaultSocketInFlight,
+		},
+		FX: FX{
+			QuoteTTL: Duration(fx.DefaultQuoteTTL),
+		},
+	}
+}
+
+// Load reads the config of the command line arguments (without the program name) and the environment.
+// The file is given by --config or CONFIG_FILE, as YAML (.yaml, .yml) or TOML (.toml).
+// printConfig is set by --print-config.
+func Load(args []string, getenv func(string) string) (cfg Config, printConfig bool, err error) {
+	cfg = Default()
+
+	path := getenv("CONFIG_FILE")
+	if fromArgs, found := configArg(args); found {
+		path = fromArgs
+	}
+	if path != "" {
+		if err := cfg.readFile(path); err != nil {
+			return cfg, false, err
+		}
+	}
+
+	flags := flag.NewFlagSet("api", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	flags.String("config", path, "config file, YAML or TOML")
+	flags.BoolVar(&printConfig, "print-config", false, "print the effective config and exit")
+	for _, b := range cfg.bindings() {
+		flags.Var(b.value, b.flag, b.usage)
+		if value := getenv(b.env); value != "" {
+			if err := flags.Set(b.flag, value); err != nil {
+				return cfg, false, fmt.Errorf("invalid %s: %w", b.env, err)
+			}
+		}
+	}
+	if err := flags.Parse(args); err != nil {
+		return cfg, false, err
+	}
+
+	// a data directory alone turns durability on, like before the backend could be chosen.
+	if cfg.Storage.Backend == "" {
+		cfg.Storage.Backend = StorageMemory
+		if cfg.Storage.DataDir != "" {
+			cfg.Storage.Backend = StorageWAL
+		}
+	}
+
+	return cfg, printConfig, cfg.Validate()
+}
+
+// Usage describes every flag with its environment variable.
+func Usage(w io.Writer) {
+	cfg := Default()
+	fmt.Fprintln(w, "  --config string\n\tconfig file, YAML or TOML (env CONFIG_FILE)")
+	fmt.Fprintln(w, "  --print-config\n\tprint the effective config and exit")
+	for _, b := range cfg.bindings() {
+		fmt.Fprintf(w, "  --%s\n\t%s (env %s, default %q)\n", b.flag, b.usage, b.env, b.value.String())
+	}
+}
+
+type binding struct {
+	flag  string
+	env   string
+	usage string
+	value flag.Value
+}
+
+// bindings are the flags and environment variables of every setting, the env vars predate the config file.
+func (c *Config) bindings() []binding {
+	return []binding{
+		{"port", "PORT", "HTTP port", (*intValue)(&c.Server.Port)},
+		{"grpc-port", "GRPC_PORT", "gRPC port", (*intValue)(&c.Server.GRPCPort)},
+		{"server-mode", "GIN_MODE", "gin mode: release, debug or test", (*stringValue)(&c.Server.Mode)},
+		{"read-timeout", "READ_TIMEOUT", "how long reading a request can take", &c.Server.ReadTimeout},
+		{"read-header-timeout", "READ_HEADER_TIMEOUT", "how long reading the request headers can take", &c.Server.ReadHeaderTimeout},
+		{"write-timeout", "WRITE_TIMEOUT", "how long writing a response can take", &c.Server.WriteTimeout},
+		{"idle-timeout", "IDLE_TIMEOUT", "how long a keep-alive connection waits for the next request", &c.Server.IdleTimeout},
+		{"drain-delay", "DRAIN_DELAY", "how long to keep serving with a failing readiness before shutting down", &c.Server.DrainDelay},
+		{"shutdown-timeout", "SHUTDOWN_TIMEOUT", "how long the in-flight transfers have to finish on shutdown", &c.Server.ShutdownTimeout},
+		{"log-level", "LOG_LEVEL", "log level: debug, info, warn or error", (*stringValue)(&c.Log.Level)},
+		{"log-format", "LOG_FORMAT", "log format: json or console", (*stringValue)(&c.Log.Format)},
+		{"storage-backend", "STORAGE_BACKEND", "storage backend: memory or wal", (*stringValue)(&c.Storage.Backend)},
+		{"data-dir", "DATA_DIR", "directory of the write-ahead logs", (*stringValue)(&c.Storage.DataDir)},
+		{"wal-sync", "WAL_SYNC", "when the write-ahead log is fsynced: always, interval or never", (*stringValue)(&c.Storage.WALSync)},
+		{"wal-sync-interval", "WAL_SYNC_INTERVAL", "how often the write-ahead log is fsynced with the interval policy", &c.Storage.WALSyncInterval},
+		{"snapshot-interval", "SNAPSHOT_INTERVAL", "how often the databases are snapshotted, 0 never does", &c.Storage.SnapshotInterval},
+		{"seed", "SEED_SOURCE", "accounts seed: none, stdin[:format], a file or a URL", (*stringValue)(&c.Seed.Source)},
+		{"lock-timeout", "TRANSFER_LOCK_TIMEOUT", "how long a transfer waits for its accounts to be unlocked", &c.Limits.LockTimeout},
+		{"lease-ttl", "LOCK_LEASE_TTL", "how long an account lock is held before it's released on its own", &c.Limits.LeaseTTL},
+		{"idempotency-retention", "IDEMPOTENCY_RETENTION", "how long an idempotency key is remembered", &c.Limits.IdempotencyRetention},
+		{"socket-in-flight", "WS_MAX_IN_FLIGHT", "how many transfers a WebSocket connection can have running", (*intValue)(&c.Limits.SocketInFlight)},
+		{"fx-rates-file", "FX_RATES_FILE", "JSON file of the exchange rates loaded on startup", (*stringValue)(&c.FX.RatesFile)},
+		{"fx-spread-bps", "FX_SPREAD_BPS", "spread taken off the exchange rates, in basis points", (*intValue)(&c.FX.SpreadBPS)},
+		{"fx-quote-ttl", "FX_QUOTE_TTL", "how long a quote locks its exchange rate", &c.FX.QuoteTTL},
+		{"admin-token", "ADMIN_TOKEN", "bearer token of the admin endpoints, they're disabled without one", (*stringValue)(&c.Auth.AdminToken)},
+	}
+}
+
+func (c *Config) readFile(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("cannot read config file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(strings.NewReader(string(content)))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("cannot decode %s: %w", path, err)
+		}
+	case ".toml":
+		decoder := toml.NewDecoder(strings.NewReader(string(content)))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(c); err != nil {
+			return fmt.Errorf("cannot decode %s: %w", path, err)
+		}
+	default:
+		return fmt.Errorf("config file %s should be .yaml, .yml or .toml", path)
+	}
+	return nil
+}
+
+// Validate reports every invalid setting.
+func (c Config) Validate() error {
+	var errs []error
+	invalid := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	ports := []struct {
+		name string
+		port int
+	}{
+		{"server.port", c.Server.Port},
+		{"server.grpc_port", c.Server.GRPCPort},
+	}
+	for _, p := range ports {
+		if p.port <= 0 || p.port > 65535 {
+			invalid("%s should be between 1 and 65535, got %d", p.name, p.port)
+		}
+	}
+	if c.Server.Port == c.Server.GRPCPort {
+		invalid("server.port and server.grpc_port should be different, both are %d", c.Server.Port)
+	}
+	switch c.Server.Mode {
+	case "release", "debug", "test":
+	default:
+		invalid("server.mode should be release, debug or test, got %q", c.Server.Mode)
+	}
+
+	durations := []struct {
+		name     string
+		duration Duration
+	}{
+		{"server.read_timeout", c.Server.ReadTimeout},
+		{"server.read_header_timeout", c.Server.ReadHeaderTimeout},
+		{"server.write_timeout", c.Server.WriteTimeout},
+		{"server.idle_timeout", c.Server.IdleTimeout},
+		{"server.drain_delay", c.Server.DrainDelay},
+		{"server.shutdown_timeout", c.Server.ShutdownTimeout},
+		{"storage.wal_sync_interval", c.Storage.WALSyncInterval},
+		{"storage.snapshot_interval", c.Storage.SnapshotInterval},
+		{"limits.lock_timeout", c.Limits.LockTimeout},
+		{"limits.lease_ttl", c.Limits.LeaseTTL},
+		{"limits.idempotency_retention", c.Limits.IdempotencyRetention},
+		{"fx.quote_ttl", c.FX.QuoteTTL},
+	}
+	for _, d := range durations {
+		if d.duration < 0 {
+			invalid("%s should not be negative, got %s", d.name, d.duration)
+		}
+	}
+
+	if _, err := zapcore.ParseLevel(c.Log.Level); err != nil {
+		invalid("log.level should be debug, info, warn or error, got %q", c.Log.Level)
+	}
+	if c.Log.Format != LogJSON && c.Log.Format != LogConsole {
+		invalid("log.format should be json or console, got %q", c.Log.Format)
+	}
+
+	switch c.Storage.Backend {
+	case StorageMemory:
+	case StorageWAL:
+		if c.Storage.DataDir == "" {
+			invalid("storage.data_dir is required by the wal backend")
+		}
+	default:
+		invalid("storage.backend should be memory or wal, got %q", c.Storage.Backend)
+	}
+	switch c.Storage.WALSync {
+	case "", memorydb.SyncAlways, memorydb.SyncInterval, memorydb.SyncNever:
+	default:
+		invalid("storage.wal_sync should be always, interval or never, got %q", c.Storage.WALSync)
+	}
+
+	if _, err := seed.Parse(c.Seed.Source); err != nil {
+		invalid("seed.source: %v", err)
+	}
+	if c.Limits.SocketInFlight < 0 {
+		invalid("limits.socket_in_flight should not be negative, got %d", c.Limits.SocketInFlight)
+	}
+
+	if c.FX.SpreadBPS < 0 || c.FX.SpreadBPS > fx.MaxSpreadBPS {
+		invalid("fx.spread_bps should be between 0 and %d, got %d", fx.MaxSpreadBPS, c.FX.SpreadBPS)
+	}
+
+	return errors.Join(errs...)
+}
+
+// Logger builds the logger of the configured level and format.
+func (c Config) Logger() (*zap.SugaredLogger, error) {
+	level, err := zap.ParseAtomicLevel(c.Log.Level)
+	if err != nil {
+		return nil, err
+	}
+
+	zapConfig := zap.NewProductionConfig()
+	if c.Log.Format == LogConsole {
+		zapConfig = zap.NewDevelopmentConfig()
+	}
+	zapConfig.Level = level
+	logger, err := zapConfig.Build()
+	if err != nil {
+		return nil, err
+	}
+	return logger.Sugar(), nil
+}
+
+// Print writes the config as YAML, with its secrets redacted.
+func (c Config) Print(w io.Writer) error {
+	if c.Auth.AdminToken != "" {
+		c.Auth.AdminToken = redacted
+	}
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(c); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// configArg finds the config file flag before the other flags are parsed, as they override it.
+func configArg(args []string) (string, bool) {
+	for idx, arg := range args {
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") || name != "config" {
+			continue
+		}
+		if hasValue {
+			return value, true
+		}
+		if idx+1 < len(args) {
+			return args[idx+1], true
+		}
+	}
+	return "", false
+}
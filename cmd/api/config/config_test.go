@@ -0,0 +1,83 @@
+package config_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0xSherlokMo/banking-system-challenge/cmd/api/config"
+	"github.com/0xSherlokMo/banking-system-challenge/memorydb"
+)
+
+func env(vars map[string]string) func(string) string {
+	return func(name string) string {
+		return vars[name]
+	}
+}
+
+func TestLoadOverrides(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"api.yaml": "server:\n  port: 7000\n  write_timeout: 1m\nlog:\n  level: debug\nstorage:\n  data_dir: ./data\nauth:\n  admin_token: from-file\n",
+		"api.toml": "[server]\nport = 7000\nwrite_timeout = \"1m\"\n[log]\nlevel = \"debug\"\n[storage]\ndata_dir = \"./data\"\n[auth]\nadmin_token = \"from-file\"\n",
+	}
+
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, []byte(content), 0o644)
+
+		// the file overrides the defaults, the env vars override the file and the flags override both.
+		cfg, printConfig, err := config.Load(
+			[]string{"--config", path, "--log-level", "warn", "--print-config"},
+			env(map[string]string{"PORT": "7001", "LOG_LEVEL": "error", "TRANSFER_LOCK_TIMEOUT": "500ms"}),
+		)
+		if err != nil {
+			t.Fatalf("%s: cannot load config: %v", name, err)
+		}
+		if !printConfig {
+			t.Errorf("%s: expected --print-config to be set", name)
+		}
+		if cfg.Server.Port != 7001 || cfg.Log.Level != "warn" || cfg.Server.GRPCPort != 9090 {
+			t.Errorf("%s: expected the env vars and flags to override the file but got %+v", name, cfg.Server)
+		}
+		if time.Duration(cfg.Server.WriteTimeout) != time.Minute || time.Duration(cfg.Limits.LockTimeout) != 500*time.Millisecond {
+			t.Errorf("%s: expected the durations to be parsed but got %s and %s", name, cfg.Server.WriteTimeout, cfg.Limits.LockTimeout)
+		}
+		if cfg.Storage.Backend != config.StorageWAL || cfg.Storage.WALSync != memorydb.SyncInterval {
+			t.Errorf("%s: expected a data directory to turn the wal backend on but got %+v", name, cfg.Storage)
+		}
+
+		var printed bytes.Buffer
+		cfg.Print(&printed)
+		if strings.Contains(printed.String(), "from-file") || !strings.Contains(printed.String(), "admin_token: '[redacted]'") {
+			t.Errorf("%s: expected the admin token to be redacted but got\n%s", name, printed.String())
+		}
+	}
+}
+
+func TestLoadRejectsInvalidConfig(t *testing.T) {
+	_, _, err := config.Load(
+		[]string{"--grpc-port", "8080", "--storage-backend", "wal", "--seed", "stdin:xml"},
+		env(map[string]string{"LOG_FORMAT": "xml", "IDLE_TIMEOUT": "-1s", "FX_SPREAD_BPS": "10000"}),
+	)
+	if err == nil {
+		t.Fatal("expected the invalid settings to be rejected")
+	}
+	for _, problem := range []string{"should be different", "log.format", "server.idle_timeout", "storage.data_dir is required", "seed.source", "fx.spread_bps"} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("expected %q to be reported in %v", problem, err)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "api.yaml")
+	os.WriteFile(path, []byte("server:\n  prot: 7000\n"), 0o644)
+	if _, _, err := config.Load(nil, env(map[string]string{"CONFIG_FILE": path})); err == nil {
+		t.Error("expected an unknown setting in the file to be rejected")
+	}
+	if _, _, err := config.Load([]string{"--port", "eighty"}, env(nil)); err == nil {
+		t.Error("expected an invalid flag to be rejected")
+	}
+}
@@ -0,0 +1,56 @@
+package config
+
+import (
+	"strconv"
+	"time"
+)
+
+// Duration is a time.Duration written as a string in the config file, ex: "1m30s".
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d *Duration) Set(value string) error {
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	return d.Set(string(text))
+}
+
+type intValue int
+
+func (i *intValue) String() string {
+	return strconv.Itoa(int(*i))
+}
+
+func (i *intValue) Set(value string) error {
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	*i = intValue(parsed)
+	return nil
+}
+
+type stringValue string
+
+func (s *stringValue) String() string {
+	return string(*s)
+}
+
+func (s *stringValue) Set(value string) error {
+	*s = stringValue(value)
+	return nil
+}
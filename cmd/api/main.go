@@ -1,24 +1,178 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
+	"github.com/0xSherlokMo/banking-system-challenge/cmd/api/config"
 	"github.com/0xSherlokMo/banking-system-challenge/cmd/api/router"
+	"github.com/0xSherlokMo/banking-system-challenge/cmd/api/rpc"
 	"github.com/0xSherlokMo/banking-system-challenge/ctx"
+	"github.com/0xSherlokMo/banking-system-challenge/fx"
+	"github.com/0xSherlokMo/banking-system-challenge/memorydb"
+	"github.com/0xSherlokMo/banking-system-challenge/seed"
 	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
 )
 
 func main() {
-	app := ctx.NewDefaultContext().WithMemoryDB().LoadAccounts()
+	cfg, printConfig, err := config.Load(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		config.Usage(os.Stderr)
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid config:\n%v\n", err)
+		os.Exit(2)
+	}
+	if printConfig {
+		if err := cfg.Print(os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "cannot print config: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	logger, err := cfg.Logger()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot setup logger: %v\n", err)
+		os.Exit(1)
+	}
+	gin.SetMode(cfg.Server.Mode)
+
+	if err := run(cfg, logger); err != nil {
+		logger.Errorw("cannot run the server", "error", err)
+		logger.Sync()
+		os.Exit(1)
+	}
+}
+
+// run serves until the server stops or a shutdown signal comes in. It returns instead of exiting,
+// so the deferred Exit always flushes the storage before the process exits.
+func run(cfg config.Config, logger *zap.SugaredLogger) error {
+	app := ctx.NewDefaultContext().WithLogger(logger)
+	if cfg.Storage.Backend == config.StorageWAL {
+		app.WithDurability(cfg.Storage.DataDir, memorydb.WALOptions{
+			Sync:         cfg.Storage.WALSync,
+			SyncInterval: time.Duration(cfg.Storage.WALSyncInterval),
+		}).WithSnapshots(time.Duration(cfg.Storage.SnapshotInterval))
+	}
+	app.
+		WithMemoryDB().
+		WithLedger().
+		WithTransactions().
+		WithIdempotency(time.Duration(cfg.Limits.IdempotencyRetention)).
+		WithLockTimeout(time.Duration(cfg.Limits.LockTimeout)).
+		WithLeaseTTL(time.Duration(cfg.Limits.LeaseTTL)).
+		WithSocketInFlight(cfg.Limits.SocketInFlight).
+		WithAdminToken(cfg.Auth.AdminToken).
+		WithFX(fx.New(fx.Options{SpreadBPS: cfg.FX.SpreadBPS, QuoteTTL: time.Duration(cfg.FX.QuoteTTL)}))
 	defer app.Exit()
 
+	if cfg.FX.RatesFile != "" {
+		if err := app.FX().LoadFile(cfg.FX.RatesFile); err != nil {
+			return fmt.Errorf("cannot load exchange rates from %s: %w", cfg.FX.RatesFile, err)
+		}
+	}
+
+	loader, err := seed.Parse(cfg.Seed.Source)
+	if err != nil {
+		return fmt.Errorf("invalid seed source: %w", err)
+	}
+	if err := app.LoadAccounts(context.Background(), loader); err != nil {
+		return fmt.Errorf("cannot seed accounts: %w", err)
+	}
+
 	engine := gin.Default()
-	router.InstallHealthRouter(engine)
+	router.InstallHealthRouter(engine, app)
 	router.InstallAccountRouter(engine, app)
-	app.Logger().Infow("System ready for transactions")
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	router.InstallTransactionRouter(engine, app)
+	router.InstallLedgerRouter(engine, app)
+	router.InstallFXRouter(engine, app)
+	grpcServer := grpc.NewServer()
+	rpc.InstallAccountService(grpcServer, app)
+	go serveGRPC(app, grpcServer, cfg.Server.GRPCPort)
+
+	server := &http.Server{
+		Addr:              ":" + strconv.Itoa(cfg.Server.Port),
+		Handler:           engine,
+		ReadTimeout:       time.Duration(cfg.Server.ReadTimeout),
+		ReadHeaderTimeout: time.Duration(cfg.Server.ReadHeaderTimeout),
+		WriteTimeout:      time.Duration(cfg.Server.WriteTimeout),
+		IdleTimeout:       time.Duration(cfg.Server.IdleTimeout),
+	}
+	signals, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- server.ListenAndServe()
+	}()
+	app.Logger().Infow("System ready for transactions", "port", cfg.Server.Port, "grpc_port", cfg.Server.GRPCPort)
+
+	var serveErr error
+	select {
+	case serveErr = <-stopped:
+		serveErr = fmt.Errorf("HTTP server stopped: %w", serveErr)
+	case <-signals.Done():
+		stop()
+	}
+	shutdown(app, server, grpcServer, cfg.Server)
+	return serveErr
+}
+
+// shutdown drains the servers: readiness fails right away, the listeners close after the drain delay
+// and the in-flight transfers get the shutdown timeout to commit or roll back before the storage is flushed by Exit.
+func shutdown(app *ctx.DefaultContext, server *http.Server, grpcServer *grpc.Server, cfg config.Server) {
+	app.Logger().Infow("Draining", "drain_delay", cfg.DrainDelay, "shutdown_timeout", cfg.ShutdownTimeout)
+	app.Drain()
+	time.Sleep(time.Duration(cfg.DrainDelay))
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ShutdownTimeout))
+	defer cancel()
+
+	grpcStopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(grpcStopped)
+	}()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		app.Logger().Warnw("HTTP requests still running at the shutdown timeout", "error", err)
+		server.Close()
+	}
+	select {
+	case <-grpcStopped:
+	case <-shutdownCtx.Done():
+		app.Logger().Warnw("gRPC calls still running at the shutdown timeout")
+		grpcServer.Stop()
+	}
+
+	// the WebSockets aren't waited for by the HTTP server, their transfers are tracked by the context.
+	if err := app.Drained(shutdownCtx); err != nil {
+		// Exit leaves the storage open under them, closing it could lose or tear their writes.
+		app.Logger().Warnw("transfers still running at the shutdown timeout", "running", app.Running(), "error", err)
+		return
+	}
+	app.Logger().Infow("Drained")
+}
+
+// serveGRPC serves the gRPC services on their own port.
+func serveGRPC(app *ctx.DefaultContext, server *grpc.Server, port int) {
+	listener, err := net.Listen("tcp", ":"+strconv.Itoa(port))
+	if err != nil {
+		app.Logger().Errorw("cannot listen for gRPC", "port", port, "error", err)
+		return
+	}
+	if err := server.Serve(listener); err != nil {
+		app.Logger().Errorw("gRPC server stopped", "error", err)
 	}
-	engine.Run(":" + port)
 }
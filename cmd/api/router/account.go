@@ -1,26 +1,35 @@
 package router
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/0xSherlokMo/banking-system-challenge/account"
 	"github.com/0xSherlokMo/banking-system-challenge/ctx"
+	"github.com/0xSherlokMo/banking-system-challenge/fx"
 	"github.com/0xSherlokMo/banking-system-challenge/memorydb"
+	"github.com/0xSherlokMo/banking-system-challenge/money"
 	"github.com/0xSherlokMo/banking-system-challenge/repository"
+	"github.com/0xSherlokMo/banking-system-challenge/transaction"
 	"github.com/gin-gonic/gin"
 )
 
 type AccountRouter struct {
-	ctx               *ctx.DefaultContext
-	AccountRepository *repository.AccountRepository
+	ctx                   *ctx.DefaultContext
+	AccountRepository     *repository.AccountRepository
+	TransactionRepository *repository.TransactionRepository
 }
 
 func InstallAccountRouter(engine *gin.Engine, ctx *ctx.DefaultContext) AccountRouter {
 	accountRouter := AccountRouter{
-		ctx:               ctx,
-		AccountRepository: repository.NewAccountRepository(ctx),
+		ctx:                   ctx,
+		AccountRepository:     repository.NewAccountRepository(ctx),
+		TransactionRepository: repository.NewTransactionRepository(ctx),
 	}
 
 	accountRouter.install(
@@ -32,17 +41,20 @@ func InstallAccountRouter(engine *gin.Engine, ctx *ctx.DefaultContext) AccountRo
 
 func (a *AccountRouter) install(router *gin.RouterGroup) {
 	router.GET("/", a.getAll)
+	router.POST("/", Idempotent(a.ctx.Idempotency()), a.create)
+	router.GET("/events", AdminOnly(a.ctx.AdminToken()), a.allEvents)
+	router.GET("/ws", a.socket)
 	router.GET("/:id", a.getId)
-	router.POST("/:from/transfer/:to", a.transfer)
+	router.GET("/:id/events", a.accountEvents)
+	router.GET("/:id/transactions", a.transactions)
+	router.POST("/:from/transfer/:to", Idempotent(a.ctx.Idempotency()), a.transfer)
+	router.POST("/:from/deposits", Idempotent(a.ctx.Idempotency()), a.deposit)
+	router.POST("/:from/withdrawals", Idempotent(a.ctx.Idempotency()), a.withdraw)
+	router.DELETE("/:id", Idempotent(a.ctx.Idempotency()), a.close)
 }
 
 func (a *AccountRouter) getAll(c *gin.Context) {
-	var safe bool
-	if c.Query("safe") == "true" {
-		safe = memorydb.ConcurrentSafe
-	}
-
-	accounts := a.AccountRepository.All(safe)
+	accounts := a.AccountRepository.All()
 
 	c.JSON(http.StatusOK, gin.H{
 		"accounts": accounts,
@@ -52,7 +64,7 @@ func (a *AccountRouter) getAll(c *gin.Context) {
 func (a *AccountRouter) getId(c *gin.Context) {
 	key := fmt.Sprintf("%s-%s", account.AccountIdPrefix, c.Param("id"))
 
-	account, err := a.AccountRepository.GetByKey(key, memorydb.ConcurrentSafe)
+	account, version, err := a.AccountRepository.GetWithVersion(key)
 	if errors.Is(err, memorydb.ErrRecordNotFound) {
 		c.JSON(http.StatusNotFound, gin.H{
 			"message": "account does not exist",
@@ -60,44 +72,393 @@ func (a *AccountRouter) getId(c *gin.Context) {
 		return
 	}
 
+	c.Header("ETag", etag(version))
 	c.JSON(http.StatusOK, gin.H{
 		"account": account,
 	})
 }
 
+// createRequest is the body of an account creation, only admins can open an account with money in it.
+type createRequest struct {
+	Name        string      `json:"name"`
+	ExternalRef string      `json:"external_ref"`
+	Currency    string      `json:"currency"`
+	Balance     money.Money `json:"balance"`
+}
+
+func (a *AccountRouter) create(c *gin.Context) {
+	// amounts are decoded at the largest exponent, then validated against the currency.
+	request := createRequest{Balance: money.Zero(money.MaxCurrencyScale)}
+	err := c.BindJSON(&request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, invalidTransfer(err))
+		return
+	}
+
+	name := strings.TrimSpace(request.Name)
+	if err := account.ValidateName(name); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	if request.ExternalRef != "" {
+		if err := account.ValidateReference(request.ExternalRef); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+	}
+	currency := money.DefaultCurrency
+	if request.Currency != "" {
+		if currency, err = money.ParseCurrency(request.Currency); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+	}
+	balance, err := currency.Amount(request.Balance)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	if balance.Sign() < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"message": account.ErrNegativeBalance.Error()})
+		return
+	}
+	if balance.Sign() > 0 && !isAdmin(c, a.ctx.AdminToken()) {
+		c.JSON(http.StatusForbidden, gin.H{"message": "only admins can open an account with a balance"})
+		return
+	}
+
+	opened := account.NewAccount(name, currency, balance)
+	opened.ExternalRef = request.ExternalRef
+	err = a.AccountRepository.Create(opened)
+	if errors.Is(err, account.ErrDuplicateRef) {
+		c.JSON(http.StatusConflict, gin.H{"message": err.Error()})
+		return
+	}
+	if errors.Is(err, ctx.ErrDraining) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		return
+	}
+	if err != nil {
+		a.ctx.Logger().Errorw("cannot create account", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Something went wrong."})
+		return
+	}
+
+	a.setETag(c, opened)
+	c.Header("Location", "/accounts/"+opened.ID.String())
+	c.JSON(http.StatusCreated, gin.H{
+		"account": opened,
+	})
+}
+
 func (a *AccountRouter) transfer(c *gin.Context) {
-	var request account.TransferRequest
+	request := account.TransferRequest{Amount: money.Zero(money.MaxCurrencyScale)}
 	err := c.BindJSON(&request)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid request"})
+		c.JSON(http.StatusBadRequest, invalidTransfer(err))
 		return
 	}
 	request.Sender = fmt.Sprintf("%s-%s", account.AccountIdPrefix, c.Param("from"))
 	request.Reciever = fmt.Sprintf("%s-%s", account.AccountIdPrefix, c.Param("to"))
+	version, ok := matchVersion(c.GetHeader("If-Match"))
+	if !ok {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"message": "sender account was changed, fetch it again"})
+		return
+	}
+	request.SenderVersion = version
+
+	status, body, sender := a.submitTransfer(c.Request.Context(), request)
+	if sender != nil {
+		a.setETag(c, sender)
+	}
+	c.JSON(status, body)
+}
 
-	err = a.AccountRepository.PrepareAccounts(request.Sender, request.Reciever)
+// submitTransfer moves the money of a transfer request, it's shared by every transport
+// so they all answer with the same status and body. The sender is returned as it was written when it went through.
+func (a *AccountRouter) submitTransfer(requestCtx context.Context, request account.TransferRequest) (int, gin.H, *account.Account) {
+	lockCtx, cancel := context.WithTimeout(requestCtx, a.ctx.LockTimeout())
+	defer cancel()
+	senderAccount, record, err := a.AccountRepository.TransferMoney(lockCtx, request)
 	if err != nil {
-		if errors.Is(err, memorydb.ErrRowLocked) {
-			c.JSON(http.StatusLocked, gin.H{"message": "Something could've gone wrong. Congrats, you're a survivor"})
-			return
-		}
-		c.JSON(http.StatusBadRequest, gin.H{"message": "Sender account does not exist"})
+		status, body := transferFailure(err, record)
+		return status, body, nil
+	}
+
+	body := gin.H{
+		"Balance":        senderAccount.Balance,
+		"transaction_id": record.ID,
+	}
+	if record.Conversion != nil {
+		body["conversion"] = record.Conversion
+	}
+	return http.StatusOK, body, senderAccount
+}
+
+// transferFailure is the status and body answering a failed transfer, deposit or withdrawal.
+func transferFailure(err error, record *transaction.Transaction) (int, gin.H) {
+	if errors.Is(err, ctx.ErrDraining) {
+		return http.StatusServiceUnavailable, gin.H{"message": err.Error()}
+	}
+	if errors.Is(err, memorydb.ErrRowLocked) {
+		return http.StatusLocked, gin.H{"message": "account is busy with other transfers, try again later"}
+	}
+	if errors.Is(err, memorydb.ErrRecordNotFound) {
+		return http.StatusBadRequest, gin.H{"message": "account does not exist"}
+	}
+	if errors.Is(err, memorydb.ErrVersionMismatch) {
+		return http.StatusPreconditionFailed, gin.H{"message": "sender account was changed, fetch it again"}
+	}
+	if !isValidationError(err) {
+		return http.StatusInternalServerError, gin.H{"message": "Something went wrong."}
+	}
+
+	response := gin.H{"message": err.Error()}
+	if record != nil {
+		response["transaction_id"] = record.ID
+	}
+	return http.StatusBadRequest, response
+}
+
+func (a *AccountRouter) deposit(c *gin.Context) {
+	a.moveExternal(c, a.AccountRepository.Deposit)
+}
+
+func (a *AccountRouter) withdraw(c *gin.Context) {
+	a.moveExternal(c, a.AccountRepository.Withdraw)
+}
+
+// moveExternal submits a deposit or withdrawal, it answers like a transfer but with the updated account.
+func (a *AccountRouter) moveExternal(
+	c *gin.Context,
+	move func(context.Context, account.ExternalRequest) (*account.Account, *transaction.Transaction, error),
+) {
+	request := account.ExternalRequest{Amount: money.Zero(money.MaxCurrencyScale)}
+	err := c.BindJSON(&request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, invalidTransfer(err))
+		return
+	}
+	if err := request.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
 		return
 	}
-	defer a.AccountRepository.Commit(request.Sender, request.Reciever)
+	request.Account = fmt.Sprintf("%s-%s", account.AccountIdPrefix, c.Param("from"))
+	version, ok := matchVersion(c.GetHeader("If-Match"))
+	if !ok {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"message": "account was changed, fetch it again"})
+		return
+	}
+	request.Version = version
 
-	senderAccount, err := a.AccountRepository.TransferMoney(request)
+	lockCtx, cancel := context.WithTimeout(c.Request.Context(), a.ctx.LockTimeout())
+	defer cancel()
+	moved, record, err := move(lockCtx, request)
+	if errors.Is(err, memorydb.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"message": "account does not exist"})
+		return
+	}
+	if errors.Is(err, memorydb.ErrVersionMismatch) {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"message": "account was changed, fetch it again"})
+		return
+	}
+	if err != nil {
+		c.JSON(transferFailure(err, record))
+		return
+	}
+
+	a.setETag(c, moved)
+	c.JSON(http.StatusOK, gin.H{
+		"account":        moved,
+		"transaction_id": record.ID,
+	})
+}
+
+// invalidTransfer is the body answering a transfer request that couldn't be decoded.
+func invalidTransfer(err error) gin.H {
+	if errors.Is(err, money.ErrTooPrecise) || errors.Is(err, money.ErrInvalidFormat) {
+		return gin.H{"message": err.Error()}
+	}
+	return gin.H{"message": "invalid request"}
+}
+
+// close closes the account, a remaining balance is swept to the account in the sweep_to query param.
+func (a *AccountRouter) close(c *gin.Context) {
+	key := fmt.Sprintf("%s-%s", account.AccountIdPrefix, c.Param("id"))
+	var sweepTo string
+	if beneficiary := c.Query("sweep_to"); beneficiary != "" {
+		sweepTo = fmt.Sprintf("%s-%s", account.AccountIdPrefix, beneficiary)
+	}
+	version, ok := matchVersion(c.GetHeader("If-Match"))
+	if !ok {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"message": "account was changed, fetch it again"})
+		return
+	}
+
+	lockCtx, cancel := context.WithTimeout(c.Request.Context(), a.ctx.LockTimeout())
+	defer cancel()
+	closed, record, err := a.AccountRepository.CloseAccount(lockCtx, key, sweepTo, version)
 	if err != nil {
-		if errors.Is(err, memorydb.ErrRecordExists) {
+		response := gin.H{"message": err.Error()}
+		if record != nil {
+			response["transaction_id"] = record.ID
+		}
+
+		switch {
+		case errors.Is(err, ctx.ErrDraining):
+			c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		case errors.Is(err, memorydb.ErrRowLocked):
+			c.JSON(http.StatusLocked, gin.H{"message": "account is busy with other transfers, try again later"})
+		case errors.Is(err, memorydb.ErrVersionMismatch):
+			c.JSON(http.StatusPreconditionFailed, gin.H{"message": "account was changed, fetch it again"})
+		case errors.Is(err, memorydb.ErrRecordNotFound):
+			// either account can be missing, the closed one is looked up again to tell which.
+			if _, err := a.AccountRepository.GetByKey(key, memorydb.ConcurrentNotSafe); errors.Is(err, memorydb.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"message": "account does not exist"})
+				return
+			}
+			c.JSON(http.StatusBadRequest, gin.H{"message": "sweep account does not exist"})
+		case errors.Is(err, account.ErrNonZeroBalance):
+			response["message"] = "account balance is not zero, sweep it to another account with ?sweep_to=<account id>"
+			c.JSON(http.StatusConflict, response)
+		case errors.Is(err, account.ErrAccountClosed):
+			c.JSON(http.StatusConflict, response)
+		case isValidationError(err):
+			c.JSON(http.StatusBadRequest, response)
+		default:
 			c.JSON(http.StatusInternalServerError, gin.H{"message": "Something went wrong."})
-			return
 		}
+		return
+	}
+
+	response := gin.H{"account": closed}
+	if record != nil {
+		response["transaction_id"] = record.ID
+	}
+	a.setETag(c, closed)
+	c.JSON(http.StatusOK, response)
+}
+
+// etag formats a record version as a strong entity tag.
+func etag(version uint64) string {
+	return strconv.Quote(strconv.FormatUint(version, 10))
+}
 
+// setETag sets the ETag of the account the request just wrote. It's left out when the account was written again
+// since, the version would be the one of a write the client didn't see.
+func (a *AccountRouter) setETag(c *gin.Context, written *account.Account) {
+	stored, version, err := a.AccountRepository.GetWithVersion(written.GetID())
+	if err == nil && stored == written {
+		c.Header("ETag", etag(version))
+	}
+}
+
+// matchVersion reads the version an account must still be at from an If-Match header, 0 when any version matches.
+// It isn't ok when the tag can never match.
+func matchVersion(match string) (uint64, bool) {
+	if match == "" || match == "*" {
+		return 0, true
+	}
+	return parseETag(match)
+}
+
+// parseETag reads the version of an entity tag sent back in an If-Match header,
+// weak tags never match as If-Match uses the strong comparison.
+func parseETag(tag string) (uint64, bool) {
+	unquoted, err := strconv.Unquote(strings.TrimSpace(tag))
+	if err != nil {
+		return 0, false
+	}
+	version, err := strconv.ParseUint(unquoted, 10, 64)
+	if err != nil || version == 0 {
+		return 0, false
+	}
+	return version, true
+}
+
+// isValidationError reports whether a transfer was rejected because of the request itself.
+func isValidationError(err error) bool {
+	return errors.Is(err, account.ErrInvalidAmount) ||
+		errors.Is(err, account.ErrCurrencyMismatch) ||
+		errors.Is(err, fx.ErrNoRate) ||
+		errors.Is(err, fx.ErrTooSmall) ||
+		errors.Is(err, fx.ErrQuoteNotFound) ||
+		errors.Is(err, fx.ErrQuoteExpired) ||
+		errors.Is(err, fx.ErrQuoteMismatch) ||
+		errors.Is(err, account.ErrInsufficientFunds) ||
+		errors.Is(err, account.ErrSameAccount) ||
+		errors.Is(err, account.ErrAccountClosed) ||
+		errors.Is(err, money.ErrOverflow) ||
+		errors.Is(err, money.ErrTooPrecise)
+}
+
+func (a *AccountRouter) transactions(c *gin.Context) {
+	key := fmt.Sprintf("%s-%s", account.AccountIdPrefix, c.Param("id"))
+
+	owner, err := a.AccountRepository.GetByKey(key, memorydb.ConcurrentNotSafe)
+	if errors.Is(err, memorydb.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "account does not exist",
+		})
+		return
+	}
+
+	query, err := transactionQuery(c)
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"Balance": senderAccount.Balance,
-	})
+	page, err := a.TransactionRepository.ListByAccount(owner.ID, query)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// transactionQuery reads the pagination and filter query params:
+// ?cursor=...&limit=20&from=2023-10-01T00:00:00Z&to=2023-11-01T00:00:00Z&direction=incoming
+func transactionQuery(c *gin.Context) (transaction.Query, error) {
+	query := transaction.Query{
+		Cursor:    c.Query("cursor"),
+		Direction: transaction.Direction(c.Query("direction")),
+	}
+
+	switch query.Direction {
+	case transaction.DirectionAll, transaction.DirectionIncoming, transaction.DirectionOutgoing:
+	default:
+		return query, errors.New("direction should be incoming or outgoing")
+	}
+
+	if limit := c.Query("limit"); limit != "" {
+		parsed, err := strconv.Atoi(limit)
+		if err != nil || parsed <= 0 {
+			return query, errors.New("limit should be a positive number")
+		}
+		query.Limit = parsed
+	}
+
+	// in a fixed order, so the same invalid query always reports the same param.
+	dates := []struct {
+		param  string
+		target *time.Time
+	}{
+		{"from", &query.From},
+		{"to", &query.To},
+	}
+	for _, date := range dates {
+		value := c.Query(date.param)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return query, fmt.Errorf("%s should be an RFC3339 date", date.param)
+		}
+		*date.target = parsed
+	}
+
+	return query, nil
 }
@@ -0,0 +1,913 @@
+package router_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/0xSherlokMo/banking-system-challenge/account"
+	"github.com/0xSherlokMo/banking-system-challenge/calculator"
+	"github.com/0xSherlokMo/banking-system-challenge/cmd/api/apitest"
+	"github.com/0xSherlokMo/banking-system-challenge/cmd/api/router"
+	"github.com/0xSherlokMo/banking-system-challenge/fx"
+	"github.com/0xSherlokMo/banking-system-challenge/ledger"
+	"github.com/0xSherlokMo/banking-system-challenge/memorydb"
+	"github.com/0xSherlokMo/banking-system-challenge/money"
+	"github.com/0xSherlokMo/banking-system-challenge/repository"
+	"github.com/0xSherlokMo/banking-system-challenge/transaction"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+func TestConcurrentBidirectionalTransfers(t *testing.T) {
+	app := apitest.NewContext()
+	accounts := apitest.SeedAccounts(app, "10000", 4)
+	engine := apitest.NewAccountEngine(app)
+
+	const transfers = 4000
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	statuses := make(map[int]int)
+	for i := 0; i < transfers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// every pair of accounts gets transfers in both directions.
+			from := accounts[i%len(accounts)]
+			to := accounts[(i/len(accounts)+i+1)%len(accounts)]
+			if from == to {
+				to = accounts[(i+1)%len(accounts)]
+			}
+
+			request := httptest.NewRequest(
+				http.MethodPost,
+				fmt.Sprintf("/accounts/%s/transfer/%s", from.ID, to.ID),
+				strings.NewReader(`{"amount": "1.25"}`),
+			)
+			response := httptest.NewRecorder()
+			engine.ServeHTTP(response, request)
+
+			mu.Lock()
+			statuses[response.Code]++
+			mu.Unlock()
+		}(i)
+	}
+
+	// every listing is a snapshot at a single commit, the balances in it always add up.
+	done := make(chan struct{})
+	listed := make(chan int)
+	go func() {
+		count := 0
+		defer func() { listed <- count }()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			response := httptest.NewRecorder()
+			engine.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/accounts/", nil))
+			var body struct {
+				Accounts []*account.Account `json:"accounts"`
+			}
+			json.Unmarshal(response.Body.Bytes(), &body)
+			total := money.Zero(money.DefaultScale)
+			for _, account := range body.Accounts {
+				total, _ = calculator.PreciseAdd(total, account.Balance)
+			}
+			if total.Cmp(money.MustParse("40000")) != 0 {
+				t.Errorf("expected every listing to add up to 40000 but got %s", total)
+				return
+			}
+			count++
+		}
+	}()
+	wg.Wait()
+	close(done)
+	if <-listed == 0 {
+		t.Errorf("expected accounts to be listed while transferring")
+	}
+
+	if statuses[http.StatusOK] != transfers {
+		t.Errorf("expected every transfer to succeed but got statuses %v", statuses)
+	}
+
+	accountRepository := repository.NewAccountRepository(app)
+	total := money.Zero(money.DefaultScale)
+	for _, account := range accountRepository.All() {
+		total, _ = calculator.PreciseAdd(total, account.Balance)
+	}
+	if total.Cmp(money.MustParse("40000")) != 0 {
+		t.Errorf("expected money to be conserved but the total is %s", total)
+	}
+
+	mismatches, _ := accountRepository.Reconcile()
+	if len(mismatches) != 0 {
+		t.Errorf("expected ledger to match balances but got %+v", mismatches)
+	}
+}
+
+func TestTransferToSameAccount(t *testing.T) {
+	app := apitest.NewContext()
+	accounts := apitest.SeedAccounts(app, "100", 1)
+	engine := apitest.NewAccountEngine(app)
+
+	request := httptest.NewRequest(
+		http.MethodPost,
+		fmt.Sprintf("/accounts/%s/transfer/%s", accounts[0].ID, accounts[0].ID),
+		strings.NewReader(`{"amount": 10}`),
+	)
+	response := httptest.NewRecorder()
+	engine.ServeHTTP(response, request)
+
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 but got %d %s", response.Code, response.Body.String())
+	}
+}
+
+func TestConditionalTransfer(t *testing.T) {
+	app := apitest.NewContext()
+	accounts := apitest.SeedAccounts(app, "100", 2)
+	engine := apitest.NewAccountEngine(app)
+
+	response := httptest.NewRecorder()
+	engine.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/accounts/"+accounts[0].ID.String(), nil))
+	tag := response.Header().Get("ETag")
+	if response.Code != http.StatusOK || tag == "" {
+		t.Fatalf("expected the account with an ETag but got %d %q", response.Code, tag)
+	}
+
+	transfer := func(match string, key ...string) int {
+		request := httptest.NewRequest(
+			http.MethodPost,
+			fmt.Sprintf("/accounts/%s/transfer/%s", accounts[0].ID, accounts[1].ID),
+			strings.NewReader(`{"amount": 10}`),
+		)
+		request.Header.Set("If-Match", match)
+		if len(key) > 0 {
+			request.Header.Set(router.IdempotencyKeyHeader, key[0])
+		}
+		response := httptest.NewRecorder()
+		engine.ServeHTTP(response, request)
+		return response.Code
+	}
+
+	if code := transfer(tag); code != http.StatusOK {
+		t.Errorf("expected a transfer from the current version to succeed but got %d", code)
+	}
+	// the first transfer changed the sender version.
+	if code := transfer(tag); code != http.StatusPreconditionFailed {
+		t.Errorf("expected a transfer from a stale version to fail with 412 but got %d", code)
+	}
+	if code := transfer(`W/` + tag); code != http.StatusPreconditionFailed {
+		t.Errorf("expected a weak ETag not to match but got %d", code)
+	}
+	if code := transfer("*"); code != http.StatusOK {
+		t.Errorf("expected a wildcard to match any version but got %d", code)
+	}
+
+	// the precondition is part of the request a key was sent with.
+	if code := transfer("*", "conditional-1"); code != http.StatusOK {
+		t.Errorf("expected the keyed transfer to succeed but got %d", code)
+	}
+	if code := transfer(tag, "conditional-1"); code != http.StatusUnprocessableEntity {
+		t.Errorf("expected the key to be rejected with another precondition but got %d", code)
+	}
+
+	sender, _ := repository.NewAccountRepository(app).GetByKey(accounts[0].GetID(), false)
+	if sender.Balance.Cmp(money.MustParse("70")) != 0 {
+		t.Errorf("expected only the matching transfers to go through but the balance is %s", sender.Balance)
+	}
+}
+
+// every write that changes an account checks its If-Match header, and answers with the ETag it left the account at.
+func TestConditionalWrites(t *testing.T) {
+	app := apitest.NewContext()
+	accounts := apitest.SeedAccounts(app, "100", 2)
+	engine := apitest.NewAccountEngine(app)
+	owner, beneficiary := accounts[0].ID.String(), accounts[1].ID.String()
+
+	serve := func(method string, target string, body string, match string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, target, strings.NewReader(body))
+		if match != "" {
+			request.Header.Set("If-Match", match)
+		}
+		response := httptest.NewRecorder()
+		engine.ServeHTTP(response, request)
+		return response
+	}
+
+	tag := serve(http.MethodGet, "/accounts/"+owner, "", "").Header().Get("ETag")
+	writes := []struct {
+		name   string
+		method string
+		target string
+		body   string
+	}{
+		{"transfer", http.MethodPost, "/accounts/" + owner + "/transfer/" + beneficiary, `{"amount": 10}`},
+		{"deposit", http.MethodPost, "/accounts/" + owner + "/deposits", `{"amount": "5", "reference": "atm-1", "channel": "cash"}`},
+		{"withdrawal", http.MethodPost, "/accounts/" + owner + "/withdrawals", `{"amount": "5", "reference": "wire-1", "channel": "wire"}`},
+		{"close", http.MethodDelete, "/accounts/" + owner + "?sweep_to=" + beneficiary, ""},
+	}
+	stale := tag
+	for _, write := range writes {
+		if response := serve(write.method, write.target, write.body, `"999"`); response.Code != http.StatusPreconditionFailed {
+			t.Errorf("%s: expected an unknown version to fail with 412 but got %d %s", write.name, response.Code, response.Body)
+		}
+		if write.name != "transfer" {
+			if response := serve(write.method, write.target, write.body, stale); response.Code != http.StatusPreconditionFailed {
+				t.Errorf("%s: expected a stale version to fail with 412 but got %d %s", write.name, response.Code, response.Body)
+			}
+		}
+
+		// the ETag of the response is enough to send the next conditional write, without fetching the account again.
+		response := serve(write.method, write.target, write.body, tag)
+		if response.Code != http.StatusOK || response.Header().Get("ETag") == "" || response.Header().Get("ETag") == tag {
+			t.Fatalf("%s: expected the write to go through with a new ETag but got %d %q %s", write.name, response.Code, response.Header().Get("ETag"), response.Body)
+		}
+		stale, tag = tag, response.Header().Get("ETag")
+	}
+
+	if current := serve(http.MethodGet, "/accounts/"+owner, "", "").Header().Get("ETag"); current != tag {
+		t.Errorf("expected the last ETag %s to be the one of the account but got %s", tag, current)
+	}
+}
+
+func TestCloseAccount(t *testing.T) {
+	app := apitest.NewContext()
+	accounts := apitest.SeedAccounts(app, "100", 2)
+	engine := apitest.NewAccountEngine(app)
+
+	serve := func(method string, target string, body string) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		engine.ServeHTTP(response, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return response
+	}
+	closing, beneficiary := accounts[0].ID.String(), accounts[1].ID.String()
+
+	missing := uuid.NewString()
+	for _, tc := range []struct {
+		target  string
+		code    int
+		message string
+	}{
+		{"/accounts/" + missing + "?sweep_to=" + beneficiary, http.StatusNotFound, "account does not exist"},
+		{"/accounts/" + closing + "?sweep_to=" + missing, http.StatusBadRequest, "sweep account does not exist"},
+	} {
+		response := serve(http.MethodDelete, tc.target, "")
+		if response.Code != tc.code || !strings.Contains(response.Body.String(), `"`+tc.message+`"`) {
+			t.Errorf("%s: expected %d %q but got %d %s", tc.target, tc.code, tc.message, response.Code, response.Body.String())
+		}
+	}
+	if response := serve(http.MethodDelete, "/accounts/"+closing, ""); response.Code != http.StatusConflict {
+		t.Errorf("expected an account with money left not to be closed but got %d", response.Code)
+	}
+	if response := serve(http.MethodDelete, "/accounts/"+closing+"?sweep_to="+closing, ""); response.Code != http.StatusBadRequest {
+		t.Errorf("expected an account not to be swept to itself but got %d", response.Code)
+	}
+	if response := serve(http.MethodDelete, "/accounts/"+closing+"?sweep_to="+beneficiary, ""); response.Code != http.StatusOK {
+		t.Fatalf("expected the account to be closed but got %d %s", response.Code, response.Body.String())
+	}
+	if response := serve(http.MethodDelete, "/accounts/"+closing, ""); response.Code != http.StatusConflict {
+		t.Errorf("expected a closed account not to be closed again but got %d", response.Code)
+	}
+
+	response := serve(http.MethodGet, "/accounts/"+closing, "")
+	var body struct {
+		Account *account.Account `json:"account"`
+	}
+	json.Unmarshal(response.Body.Bytes(), &body)
+	if response.Code != http.StatusOK || !body.Account.Closed() || !body.Account.Balance.IsZero() {
+		t.Errorf("expected the closed account to stay readable with no balance but got %d %s", response.Code, response.Body.String())
+	}
+
+	for _, target := range []string{
+		fmt.Sprintf("/accounts/%s/transfer/%s", beneficiary, closing),
+		fmt.Sprintf("/accounts/%s/transfer/%s", closing, beneficiary),
+	} {
+		if response := serve(http.MethodPost, target, `{"amount": 1}`); response.Code != http.StatusBadRequest {
+			t.Errorf("expected transfers with a closed account to be rejected but got %d", response.Code)
+		}
+	}
+
+	accountRepository := repository.NewAccountRepository(app)
+	swept, _ := accountRepository.GetByKey(accounts[1].GetID(), false)
+	if swept.Balance.Cmp(money.MustParse("200")) != 0 {
+		t.Errorf("expected the balance to be swept but got %s", swept.Balance)
+	}
+	if mismatches, _ := accountRepository.Reconcile(); len(mismatches) != 0 {
+		t.Errorf("expected ledger to match balances but got %+v", mismatches)
+	}
+}
+
+func TestAccountEvents(t *testing.T) {
+	app := apitest.NewContext().WithAdminToken("secret")
+	accounts := apitest.SeedAccounts(app, "100", 3)
+	engine := apitest.NewAccountEngine(app)
+	server := httptest.NewServer(engine)
+	defer server.Close()
+
+	type event struct{ id, name, data string }
+	subscribe := func(path string, header http.Header) (*bufio.Reader, func()) {
+		request, _ := http.NewRequest(http.MethodGet, server.URL+path, nil)
+		for name, values := range header {
+			request.Header[name] = values
+		}
+		response, err := http.DefaultClient.Do(request)
+		if err != nil || response.StatusCode != http.StatusOK {
+			t.Fatalf("cannot subscribe to %s: %v %v", path, response.StatusCode, err)
+		}
+		return bufio.NewReader(response.Body), func() { response.Body.Close() }
+	}
+	next := func(stream *bufio.Reader) event {
+		var e event
+		for {
+			line, err := stream.ReadString('\n')
+			if err != nil {
+				t.Fatalf("stream ended: %v", err)
+			}
+			line = strings.TrimRight(line, "\n")
+			switch {
+			case line == "" && e.name != "":
+				return e
+			case strings.HasPrefix(line, "id:"):
+				e.id = line[len("id:"):]
+			case strings.HasPrefix(line, "event:"):
+				e.name = line[len("event:"):]
+			case strings.HasPrefix(line, "data:"):
+				e.data = line[len("data:"):]
+			}
+		}
+	}
+	transfer := func(from, to *account.Account) {
+		response := httptest.NewRecorder()
+		engine.ServeHTTP(response, httptest.NewRequest(
+			http.MethodPost,
+			fmt.Sprintf("/accounts/%s/transfer/%s", from.ID, to.ID),
+			strings.NewReader(`{"amount": 10}`),
+		))
+	}
+
+	stream, stop := subscribe("/accounts/"+accounts[0].ID.String()+"/events", nil)
+	transfer(accounts[1], accounts[2])
+	transfer(accounts[0], accounts[1])
+	transfer(accounts[1], accounts[0])
+
+	// the transfer between the other accounts isn't streamed.
+	var received []event
+	for len(received) < 4 {
+		received = append(received, next(stream))
+	}
+	stop()
+	names := map[string]int{}
+	for _, e := range received {
+		names[e.name]++
+		if !strings.Contains(e.data, accounts[0].ID.String()) {
+			t.Errorf("expected only events of the account but got %+v", e)
+		}
+	}
+	if names["balance"] != 2 || names["transfer"] != 2 {
+		t.Errorf("expected 2 balance and 2 transfer events but got %v", names)
+	}
+
+	// a client reconnecting with its last event id gets the events it missed.
+	resumed, stop := subscribe("/accounts/"+accounts[0].ID.String()+"/events", http.Header{"Last-Event-Id": {received[1].id}})
+	defer stop()
+	// the balance and transfer feeds are merged as they're read, so the missed events can come in any order.
+	missed := map[string]bool{}
+	for _, e := range received[2:] {
+		missed[e.name+e.data] = true
+	}
+	for range received[2:] {
+		if e := next(resumed); !missed[e.name+e.data] {
+			t.Errorf("expected one of the missed events %+v but got %+v", received[2:], e)
+		}
+	}
+
+	request, _ := http.NewRequest(http.MethodGet, server.URL+"/accounts/events", nil)
+	response, _ := http.DefaultClient.Do(request)
+	response.Body.Close()
+	if response.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected the all accounts stream to require the admin token but got %d", response.StatusCode)
+	}
+	all, stop := subscribe("/accounts/events", http.Header{"Authorization": {"Bearer secret"}})
+	defer stop()
+	transfer(accounts[1], accounts[2])
+	if e := next(all); e.name != "balance" && e.name != "transfer" {
+		t.Errorf("expected the admin stream to get every account events but got %+v", e)
+	}
+}
+
+func TestTransferSocket(t *testing.T) {
+	app := apitest.NewContext().WithSocketInFlight(2).WithLockTimeout(5 * time.Second)
+	accounts := apitest.SeedAccounts(app, "100", 3)
+	engine := apitest.NewAccountEngine(app)
+	server := httptest.NewServer(engine)
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/accounts/ws", nil)
+	if err != nil {
+		t.Fatalf("cannot connect: %v", err)
+	}
+	defer conn.Close()
+
+	type message struct {
+		ID     string          `json:"id"`
+		Type   string          `json:"type"`
+		Status int             `json:"status"`
+		Body   json.RawMessage `json:"body"`
+	}
+	receive := func() message {
+		var m message
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if err := conn.ReadJSON(&m); err != nil {
+			t.Fatalf("cannot read message: %v", err)
+		}
+		return m
+	}
+	transfer := func(id string, from, to *account.Account, amount string) {
+		conn.WriteJSON(map[string]string{"id": id, "type": "transfer", "from": from.ID.String(), "to": to.ID.String(), "amount": amount})
+	}
+
+	conn.WriteJSON(map[string]string{"id": "sub", "type": "subscribe", "account": accounts[1].ID.String()})
+	if m := receive(); m.ID != "sub" || m.Status != http.StatusOK {
+		t.Fatalf("expected the subscription to be acknowledged but got %+v", m)
+	}
+
+	transfer("ok", accounts[0], accounts[1], "10")
+	transfer("funds", accounts[0], accounts[1], "1000")
+	transfer("amount", accounts[0], accounts[1], "1.0001")
+	results := map[string]int{}
+	var balances []string
+	for len(results) < 3 || len(balances) < 1 {
+		m := receive()
+		switch m.Type {
+		case "result":
+			results[m.ID] = m.Status
+		case "balance":
+			balances = append(balances, string(m.Body))
+		}
+	}
+	expected := map[string]int{"ok": http.StatusOK, "funds": http.StatusBadRequest, "amount": http.StatusBadRequest}
+	for id, status := range expected {
+		if results[id] != status {
+			t.Errorf("expected transfer %s to answer %d but got %d", id, status, results[id])
+		}
+	}
+	if !strings.Contains(balances[0], `"balance":"110.00"`) {
+		t.Errorf("expected the receiver balance to be pushed but got %s", balances[0])
+	}
+
+	// transfers over the in flight cap are rejected while the others wait for the locked account.
+	lease, _ := app.MemoryDB().Lock(accounts[2].GetID())
+	transfer("first", accounts[2], accounts[0], "1")
+	transfer("second", accounts[2], accounts[0], "1")
+	transfer("third", accounts[2], accounts[0], "1")
+	if m := receive(); m.ID != "third" || m.Status != http.StatusTooManyRequests {
+		t.Errorf("expected the transfer over the cap to be rejected but got %+v", m)
+	}
+	app.MemoryDB().Unlock(accounts[2].GetID(), lease)
+	for _, id := range []string{"first", "second"} {
+		results[id] = 0
+	}
+	for results["first"] == 0 || results["second"] == 0 {
+		if m := receive(); m.Type == "result" {
+			results[m.ID] = m.Status
+		}
+	}
+	if results["first"] != http.StatusOK || results["second"] != http.StatusOK {
+		t.Errorf("expected the waiting transfers to go through but got %v", results)
+	}
+}
+
+func TestGracefulDrain(t *testing.T) {
+	app := apitest.NewContext().WithSocketInFlight(1).WithLockTimeout(5 * time.Second)
+	accounts := apitest.SeedAccounts(app, "100", 2)
+	engine := apitest.NewAccountEngine(app)
+	router.InstallHealthRouter(engine, app)
+	server := httptest.NewServer(engine)
+	defer server.Close()
+
+	ready := func() int {
+		response := httptest.NewRecorder()
+		engine.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/health/ready", nil))
+		return response.Code
+	}
+	if status := ready(); status != http.StatusOK {
+		t.Fatalf("expected to be ready but got %d", status)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/accounts/ws", nil)
+	if err != nil {
+		t.Fatalf("cannot connect: %v", err)
+	}
+	defer conn.Close()
+	type message struct {
+		ID     string `json:"id"`
+		Status int    `json:"status"`
+	}
+	transfer := func(id string) message {
+		conn.WriteJSON(map[string]string{"id": id, "type": "transfer", "from": accounts[0].ID.String(), "to": accounts[1].ID.String(), "amount": "10"})
+		if id == "held" {
+			return message{}
+		}
+		var m message
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		conn.ReadJSON(&m)
+		return m
+	}
+
+	// the held transfer waits for the locked sender, the probe rejected over the cap shows it's in flight.
+	lease, _ := app.MemoryDB().Lock(accounts[0].GetID())
+	transfer("held")
+	if m := transfer("probe"); m.ID != "probe" || m.Status != http.StatusTooManyRequests {
+		t.Fatalf("expected the probe to be rejected but got %+v", m)
+	}
+
+	app.Drain()
+	if status := ready(); status != http.StatusServiceUnavailable {
+		t.Errorf("expected readiness to fail once draining but got %d", status)
+	}
+	late := httptest.NewRecorder()
+	engine.ServeHTTP(late, httptest.NewRequest(http.MethodPost, fmt.Sprintf("/accounts/%s/transfer/%s", accounts[1].ID, accounts[0].ID), strings.NewReader(`{"amount": "1"}`)))
+	if late.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected a transfer sent while draining to be refused but got %d %s", late.Code, late.Body)
+	}
+	waitCtx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := app.Drained(waitCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the held transfer to be waited for but got %v", err)
+	}
+
+	// the transfer still commits and its result is sent before the socket is closed.
+	app.MemoryDB().Unlock(accounts[0].GetID(), lease)
+	var m message
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := conn.ReadJSON(&m); err != nil || m.ID != "held" || m.Status != http.StatusOK {
+		t.Errorf("expected the held transfer to go through but got %+v %v", m, err)
+	}
+	if err := conn.ReadJSON(&m); !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("expected the socket to be closed as going away but got %v", err)
+	}
+
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelDrain()
+	if err := app.Drained(drainCtx); err != nil {
+		t.Errorf("expected to be drained but got %v", err)
+	}
+}
+
+func TestCreateAccount(t *testing.T) {
+	app := apitest.NewContext().WithAdminToken("secret")
+	engine := apitest.NewAccountEngine(app)
+
+	create := func(body string, headers map[string]string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, "/accounts/", strings.NewReader(body))
+		for name, value := range headers {
+			request.Header.Set(name, value)
+		}
+		response := httptest.NewRecorder()
+		engine.ServeHTTP(response, request)
+		return response
+	}
+
+	response := create(`{"name": "Zoë O'Brien-Smith"}`, nil)
+	var created struct {
+		Account account.Account `json:"account"`
+	}
+	json.Unmarshal(response.Body.Bytes(), &created)
+	if response.Code != http.StatusCreated || !created.Account.Balance.IsZero() || created.Account.Name != "Zoë O'Brien-Smith" {
+		t.Fatalf("expected an empty account to be created but got %d %s", response.Code, response.Body)
+	}
+	location := response.Header().Get("Location")
+	fetched := httptest.NewRecorder()
+	engine.ServeHTTP(fetched, httptest.NewRequest(http.MethodGet, location, nil))
+	if location != "/accounts/"+created.Account.ID.String() || fetched.Code != http.StatusOK {
+		t.Errorf("expected the account at its location but got %q answering %d", location, fetched.Code)
+	}
+
+	cases := map[string]struct {
+		body    string
+		headers map[string]string
+		status  int
+	}{
+		"empty name":       {`{"name": "  "}`, nil, http.StatusBadRequest},
+		"long name":        {fmt.Sprintf(`{"name": %q}`, strings.Repeat("a", account.MaxNameLength+1)), nil, http.StatusBadRequest},
+		"invalid name":     {`{"name": "<script>"}`, nil, http.StatusBadRequest},
+		"invalid ref":      {`{"name": "Yambee", "external_ref": "a b"}`, nil, http.StatusBadRequest},
+		"negative balance": {`{"name": "Yambee", "balance": "-1"}`, map[string]string{"Authorization": "Bearer secret"}, http.StatusBadRequest},
+		"funded by client": {`{"name": "Yambee", "balance": "10"}`, nil, http.StatusForbidden},
+		"wrong token":      {`{"name": "Yambee", "balance": "10"}`, map[string]string{"Authorization": "Bearer wrong"}, http.StatusForbidden},
+		"funded by admin":  {`{"name": "Yambee", "balance": "10"}`, map[string]string{"Authorization": "Bearer secret"}, http.StatusCreated},
+	}
+	for name, c := range cases {
+		if response := create(c.body, c.headers); response.Code != c.status {
+			t.Errorf("%s: expected %d but got %d %s", name, c.status, response.Code, response.Body)
+		}
+	}
+
+	// a reference is only given to one of the accounts created with it at once.
+	var wg sync.WaitGroup
+	statuses := make(chan int, 10)
+	for i := 0; i < cap(statuses); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses <- create(`{"name": "Trudoo", "external_ref": "crm:42"}`, nil).Code
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+	counts := map[int]int{}
+	for status := range statuses {
+		counts[status]++
+	}
+	if counts[http.StatusCreated] != 1 || counts[http.StatusConflict] != cap(statuses)-1 {
+		t.Errorf("expected a single account with the reference but got %v", counts)
+	}
+
+	// a replayed creation answers with the same headers.
+	headers := map[string]string{router.IdempotencyKeyHeader: "create-1"}
+	first, replayed := create(`{"name": "Quinu"}`, headers), create(`{"name": "Quinu"}`, headers)
+	if replayed.Header().Get("Location") != first.Header().Get("Location") || replayed.Header().Get("ETag") == "" ||
+		replayed.Header().Get("ETag") != first.Header().Get("ETag") || replayed.Body.String() != first.Body.String() {
+		t.Errorf("expected the creation to be replayed but got %s", replayed.Body)
+	}
+	if accounts := len(app.MemoryDB().Keys()); accounts != 4 {
+		t.Errorf("expected 4 accounts but got %d", accounts)
+	}
+}
+
+func TestIdempotencyKeysAreScopedByClient(t *testing.T) {
+	app := apitest.NewContext()
+	accounts := apitest.SeedAccounts(app, "100", 2)
+	engine := apitest.NewAccountEngine(app)
+
+	transfer := func(client string, amount string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(
+			http.MethodPost,
+			fmt.Sprintf("/accounts/%s/transfer/%s", accounts[0].ID, accounts[1].ID),
+			strings.NewReader(fmt.Sprintf(`{"amount": %s}`, amount)),
+		)
+		request.Header.Set(router.IdempotencyKeyHeader, "shared")
+		request.Header.Set(router.ClientIdHeader, client)
+		response := httptest.NewRecorder()
+		engine.ServeHTTP(response, request)
+		return response
+	}
+
+	first := transfer("alice", "10")
+	// the same key from another client is its own request, whatever its payload.
+	for _, amount := range []string{"10", "20"} {
+		other := transfer("bob-"+amount, amount)
+		if other.Code != http.StatusOK || other.Header().Get(router.IdempotencyReplayedHeader) != "" || other.Body.String() == first.Body.String() {
+			t.Errorf("expected the transfer of %s from another client to go through but got %d %s", amount, other.Code, other.Body)
+		}
+	}
+	if replayed := transfer("alice", "10"); replayed.Header().Get(router.IdempotencyReplayedHeader) != "true" || replayed.Body.String() != first.Body.String() {
+		t.Errorf("expected the first client to get its own response replayed but got %d %s", replayed.Code, replayed.Body)
+	}
+
+	sender, _ := repository.NewAccountRepository(app).GetByKey(accounts[0].GetID(), memorydb.ConcurrentNotSafe)
+	if sender.Balance.Cmp(money.MustParse("60")) != 0 {
+		t.Errorf("expected the three transfers to go through once but the balance is %s", sender.Balance)
+	}
+}
+
+func TestDepositsAndWithdrawals(t *testing.T) {
+	app := apitest.NewContext()
+	accounts := apitest.SeedAccounts(app, "100", 1)
+	owner := accounts[0]
+	engine := apitest.NewAccountEngine(app)
+
+	move := func(kind string, id string, body string, key string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/accounts/%s/%s", id, kind), strings.NewReader(body))
+		if key != "" {
+			request.Header.Set(router.IdempotencyKeyHeader, key)
+		}
+		response := httptest.NewRecorder()
+		engine.ServeHTTP(response, request)
+		return response
+	}
+
+	deposit := `{"amount": "50", "reference": "atm-1234", "channel": "cash", "memo": "branch deposit"}`
+	cases := []struct {
+		name   string
+		kind   string
+		id     string
+		body   string
+		status int
+	}{
+		{"deposit", "deposits", owner.ID.String(), deposit, http.StatusOK},
+		{"overdraft", "withdrawals", owner.ID.String(), `{"amount": "200", "reference": "wire-1", "channel": "wire"}`, http.StatusBadRequest},
+		{"withdrawal", "withdrawals", owner.ID.String(), `{"amount": "30", "reference": "wire-2", "channel": "wire"}`, http.StatusOK},
+		{"negative", "deposits", owner.ID.String(), `{"amount": "-1", "reference": "atm-2", "channel": "cash"}`, http.StatusBadRequest},
+		{"no reference", "deposits", owner.ID.String(), `{"amount": "1", "channel": "cash"}`, http.StatusBadRequest},
+		{"invalid channel", "deposits", owner.ID.String(), `{"amount": "1", "reference": "atm-3", "channel": "Cash Desk"}`, http.StatusBadRequest},
+		{"unknown account", "deposits", "c2f0e0b4-8f4e-4a43-9b49-2c5d5a5f3d11", `{"amount": "1", "reference": "atm-4", "channel": "cash"}`, http.StatusNotFound},
+	}
+	for _, c := range cases {
+		if response := move(c.kind, c.id, c.body, ""); response.Code != c.status {
+			t.Errorf("%s: expected %d but got %d %s", c.name, c.status, response.Code, response.Body)
+		}
+	}
+
+	// a retried deposit is only credited once.
+	first, retried := move("deposits", owner.ID.String(), deposit, "deposit-1"), move("deposits", owner.ID.String(), deposit, "deposit-1")
+	if first.Code != http.StatusOK || retried.Body.String() != first.Body.String() {
+		t.Errorf("expected the deposit to be replayed but got %d %s", retried.Code, retried.Body)
+	}
+
+	repo := repository.NewAccountRepository(app)
+	stored, _ := repo.GetByKey(owner.GetID(), memorydb.ConcurrentNotSafe)
+	if stored.Balance.Cmp(money.MustParse("170.00")) != 0 {
+		t.Errorf("expected a balance of 170.00 but got %s", stored.Balance)
+	}
+	external, _ := app.Ledger().Balance(ledger.ExternalFundsAccount)
+	if external.Cmp(money.MustParse("-70.00")) != 0 {
+		t.Errorf("expected 70.00 to have come in from outside the bank but got %s", external)
+	}
+	if mismatches, err := repo.Reconcile(); err != nil || len(mismatches) != 0 {
+		t.Errorf("expected the balances to match the ledger but got %v %v", mismatches, err)
+	}
+
+	page, _ := app.Transactions().List(owner.ID, transaction.Query{})
+	kinds := map[transaction.Kind]int{}
+	for _, recorded := range page.Transactions {
+		kinds[recorded.Kind]++
+		if recorded.External == nil || recorded.External.Reference == "" {
+			t.Errorf("expected the counterparty to be recorded on %+v", recorded)
+		}
+	}
+	// the rejected negative deposit and overdraft are recorded as failed, like rejected transfers.
+	if kinds[transaction.KindDeposit] != 3 || kinds[transaction.KindWithdrawal] != 2 || len(page.Transactions) != 5 {
+		t.Errorf("expected 3 deposits and 2 withdrawals but got %v", kinds)
+	}
+}
+
+func TestCurrencyTransfers(t *testing.T) {
+	app := apitest.NewContext().WithAdminToken("secret")
+	repo := repository.NewAccountRepository(app)
+	open := func(currency money.Currency, balance string) *account.Account {
+		amount, _ := money.Parse(balance, currency.Scale())
+		opened := account.NewAccount("Yambee", currency, amount)
+		repo.Create(opened)
+		return opened
+	}
+	dollars, otherDollars, pounds := open("USD", "100"), open("USD", "0"), open("EGP", "0")
+	yen, otherYen := open("JPY", "1000"), open("JPY", "0")
+	engine := apitest.NewAccountEngine(app)
+
+	cases := []struct {
+		name    string
+		from    *account.Account
+		to      *account.Account
+		body    string
+		status  int
+		message string
+	}{
+		{"same currency", dollars, otherDollars, `{"amount": "10.50"}`, http.StatusOK, ""},
+		{"across currencies", dollars, pounds, `{"amount": "10"}`, http.StatusBadRequest, account.ErrCurrencyMismatch.Error()},
+		{"conversion", dollars, pounds, `{"amount": "10", "convert": true}`, http.StatusBadRequest, fx.ErrNoRate.Error()},
+		{"yen fraction", yen, otherYen, `{"amount": "10.5"}`, http.StatusBadRequest, money.ErrTooPrecise.Error()},
+		{"yen", yen, otherYen, `{"amount": 10}`, http.StatusOK, ""},
+	}
+	for _, c := range cases {
+		request := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/accounts/%s/transfer/%s", c.from.ID, c.to.ID), strings.NewReader(c.body))
+		response := httptest.NewRecorder()
+		engine.ServeHTTP(response, request)
+		if response.Code != c.status || !strings.Contains(response.Body.String(), c.message) {
+			t.Errorf("%s: expected %d %q but got %d %s", c.name, c.status, c.message, response.Code, response.Body)
+		}
+	}
+	if stored, _ := repo.GetByKey(yen.GetID(), memorydb.ConcurrentNotSafe); stored.Balance.String() != "990" {
+		t.Errorf("expected the yen balance without minor units but got %s", stored.Balance)
+	}
+
+	for body, status := range map[string]int{
+		`{"name": "Trudoo", "currency": "kwd", "balance": "1.234"}`: http.StatusCreated,
+		`{"name": "Trudoo", "currency": "JPY", "balance": "1.5"}`:   http.StatusBadRequest,
+		`{"name": "Trudoo", "currency": "XYZ"}`:                     http.StatusBadRequest,
+	} {
+		request := httptest.NewRequest(http.MethodPost, "/accounts/", strings.NewReader(body))
+		request.Header.Set("Authorization", "Bearer secret")
+		response := httptest.NewRecorder()
+		engine.ServeHTTP(response, request)
+		if response.Code != status {
+			t.Errorf("%s: expected %d but got %d %s", body, status, response.Code, response.Body)
+		}
+	}
+
+	if mismatches, err := repo.Reconcile(); err != nil || len(mismatches) != 0 {
+		t.Errorf("expected the balances to match the ledger but got %v %v", mismatches, err)
+	}
+	if opening, _ := app.Ledger().Balance(ledger.SystemAccount(ledger.OpeningBalanceAccount, "JPY")); opening.String() != "-1000" {
+		t.Errorf("expected the yen to be funded by their own opening account but got %s", opening)
+	}
+}
+
+func TestFXTransfers(t *testing.T) {
+	app := apitest.NewContext().WithAdminToken("secret")
+	repo := repository.NewAccountRepository(app)
+	dollars := account.NewAccount("Yambee", "USD", money.New(10000, 2))
+	pounds := account.NewAccount("Trudoo", "EGP", money.New(0, 2))
+	repo.Create(dollars)
+	repo.Create(pounds)
+	engine := apitest.NewAccountEngine(app)
+	router.InstallFXRouter(engine, app)
+	serve := func(method, path, body, token string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		response := httptest.NewRecorder()
+		engine.ServeHTTP(response, request)
+		return response
+	}
+
+	rates := `[{"from": "usd", "to": "EGP", "rate": "48.95", "spread_bps": 100}]`
+	if response := serve(http.MethodPut, "/fx/rates", rates, ""); response.Code != http.StatusUnauthorized {
+		t.Fatalf("expected the rates to be admin only but got %d %s", response.Code, response.Body)
+	}
+	if response := serve(http.MethodPut, "/fx/rates", `[{"from": "USD", "to": "USD", "rate": "1"}]`, "secret"); response.Code != http.StatusBadRequest {
+		t.Fatalf("expected a rate to itself to be rejected but got %d %s", response.Code, response.Body)
+	}
+	if response := serve(http.MethodPut, "/fx/rates", rates, "secret"); response.Code != http.StatusOK {
+		t.Fatalf("expected the rates to be set but got %d %s", response.Code, response.Body)
+	}
+
+	response := serve(http.MethodPost, "/fx/quotes", `{"from": "USD", "to": "EGP", "amount": "10.01"}`, "")
+	var quoted struct {
+		Quote      fx.Quote      `json:"quote"`
+		Conversion fx.Conversion `json:"conversion"`
+	}
+	if err := json.Unmarshal(response.Body.Bytes(), &quoted); err != nil || response.Code != http.StatusCreated {
+		t.Fatalf("expected a quote but got %d %s", response.Code, response.Body)
+	}
+	// 10.01 * 48.95 with 1% off is 485.089605 EGP.
+	if quoted.Quote.Rate.String() != "48.4605" || quoted.Conversion.Target.Amount.String() != "485.09" {
+		t.Errorf("expected 485.09 EGP at 48.4605 but got %s at %s", quoted.Conversion.Target.Amount, quoted.Quote.Rate)
+	}
+
+	// the quoted rate holds even if the table changes.
+	serve(http.MethodPut, "/fx/rates", `[{"from": "USD", "to": "EGP", "rate": "50"}]`, "secret")
+	path := fmt.Sprintf("/accounts/%s/transfer/%s", dollars.ID, pounds.ID)
+	body := fmt.Sprintf(`{"amount": "10.01", "quote_id": %q}`, quoted.Quote.ID)
+	response = serve(http.MethodPost, path, body, "")
+	var transferred struct {
+		TransactionID string `json:"transaction_id"`
+	}
+	json.Unmarshal(response.Body.Bytes(), &transferred)
+	if response.Code != http.StatusOK {
+		t.Fatalf("expected the quoted transfer to go through but got %d %s", response.Code, response.Body)
+	}
+	record, _ := app.Transactions().Get(transferred.TransactionID)
+	if record.Conversion == nil || record.Conversion.QuoteID == nil || record.Conversion.Rate.String() != "48.4605" ||
+		record.Conversion.Source.Amount.String() != "10.01" || record.Conversion.Target.Amount.String() != "485.09" {
+		t.Errorf("expected both legs and the quoted rate on the record but got %+v", record.Conversion)
+	}
+
+	other, _ := app.FX().Quote("USD", "EGP")
+	cases := []struct {
+		name    string
+		from    *account.Account
+		to      *account.Account
+		body    string
+		status  int
+		message string
+	}{
+		{"live rate", pounds, dollars, `{"amount": "100", "convert": true}`, http.StatusOK, ""},
+		{"reused quote", dollars, pounds, body, http.StatusBadRequest, fx.ErrQuoteNotFound.Error()},
+		{"quote of the other direction", pounds, dollars, fmt.Sprintf(`{"amount": "1", "quote_id": %q}`, other.ID), http.StatusBadRequest, fx.ErrQuoteMismatch.Error()},
+		{"unknown quote", dollars, pounds, `{"amount": "1", "quote_id": "nope"}`, http.StatusBadRequest, fx.ErrQuoteNotFound.Error()},
+		{"too small", pounds, dollars, `{"amount": "0.01", "convert": true}`, http.StatusBadRequest, fx.ErrTooSmall.Error()},
+	}
+	for _, c := range cases {
+		response := serve(http.MethodPost, fmt.Sprintf("/accounts/%s/transfer/%s", c.from.ID, c.to.ID), c.body, "")
+		if response.Code != c.status || !strings.Contains(response.Body.String(), c.message) {
+			t.Errorf("%s: expected %d %q but got %d %s", c.name, c.status, c.message, response.Code, response.Body)
+		}
+	}
+
+	// 100 EGP at 1/50 with no spread is 2 USD.
+	for key, expected := range map[string]string{dollars.GetID(): "91.99", pounds.GetID(): "385.09"} {
+		if stored, _ := repo.GetByKey(key, memorydb.ConcurrentNotSafe); stored.Balance.String() != expected {
+			t.Errorf("expected %s to have %s but got %s", key, expected, stored.Balance)
+		}
+	}
+	if mismatches, err := repo.Reconcile(); err != nil || len(mismatches) != 0 {
+		t.Errorf("expected the balances to match the ledger but got %v %v", mismatches, err)
+	}
+	if position, _ := app.Ledger().Balance(ledger.SystemAccount(ledger.FXAccount, "EGP")); position.String() != "-385.09" {
+		t.Errorf("expected the EGP position to be what was paid out net but got %s", position)
+	}
+}
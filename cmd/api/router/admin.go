@@ -0,0 +1,33 @@
+package router
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminOnly lets through the requests carrying the admin token as a bearer token.
+// Admin endpoints are disabled when no token is configured.
+func AdminOnly(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token == "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "admin endpoints are disabled"})
+			return
+		}
+
+		if !isAdmin(c, token) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "admin token is missing or invalid"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// isAdmin reports whether the request carries the admin token, it's always false when no token is configured.
+func isAdmin(c *gin.Context, token string) bool {
+	sent, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	return token != "" && found && subtle.ConstantTimeCompare([]byte(sent), []byte(token)) == 1
+}
@@ -0,0 +1,172 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0xSherlokMo/banking-system-challenge/account"
+	"github.com/0xSherlokMo/banking-system-challenge/memorydb"
+	"github.com/0xSherlokMo/banking-system-challenge/money"
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// eventsKeepAlive is how often a comment is sent on an idle stream, so proxies don't close it.
+	eventsKeepAlive = 15 * time.Second
+)
+
+// eventCursor is the position of a stream in both the accounts and the transactions feeds.
+// It's the id of every event, so a client reconnecting with Last-Event-ID resumes right after it.
+type eventCursor struct {
+	accounts     uint64
+	transactions uint64
+}
+
+func (e eventCursor) String() string {
+	return fmt.Sprintf("%d-%d", e.accounts, e.transactions)
+}
+
+func parseEventCursor(id string) (eventCursor, bool) {
+	accounts, transactions, found := strings.Cut(id, "-")
+	if !found {
+		return eventCursor{}, false
+	}
+	var cursor eventCursor
+	var err error
+	if cursor.accounts, err = strconv.ParseUint(accounts, 10, 64); err != nil {
+		return eventCursor{}, false
+	}
+	if cursor.transactions, err = strconv.ParseUint(transactions, 10, 64); err != nil {
+		return eventCursor{}, false
+	}
+	return cursor, true
+}
+
+type balanceEvent struct {
+	Account         *account.Account `json:"account"`
+	PreviousBalance *money.Money     `json:"previous_balance,omitempty"`
+	Version         uint64           `json:"version"`
+}
+
+func (a *AccountRouter) accountEvents(c *gin.Context) {
+	key := fmt.Sprintf("%s-%s", account.AccountIdPrefix, c.Param("id"))
+
+	owner, err := a.AccountRepository.GetByKey(key, memorydb.ConcurrentNotSafe)
+	if errors.Is(err, memorydb.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "account does not exist",
+		})
+		return
+	}
+
+	a.streamEvents(c, key, &owner.ID)
+}
+
+func (a *AccountRouter) allEvents(c *gin.Context) {
+	a.streamEvents(c, "", nil)
+}
+
+// streamEvents streams the balance changes of the account with the given key (every account if it's empty)
+// and the transfers it's part of (every transfer if owner is nil) as server-sent events.
+// If the events after Last-Event-ID aren't retained anymore, a reset event tells the client to fetch the accounts again.
+func (a *AccountRouter) streamEvents(c *gin.Context, key string, owner *uuid.UUID) {
+	cursor, resumed := parseEventCursor(c.GetHeader("Last-Event-ID"))
+	if !resumed {
+		cursor = eventCursor{accounts: a.AccountRepository.Version(), transactions: a.TransactionRepository.Version()}
+	}
+
+	watchCtx := c.Request.Context()
+	var reset bool
+	balances, err := a.AccountRepository.Watch(watchCtx, key, cursor.accounts)
+	if errors.Is(err, memorydb.ErrVersionCompacted) {
+		reset = true
+		cursor.accounts = a.AccountRepository.Version()
+		balances, err = a.AccountRepository.Watch(watchCtx, key, cursor.accounts)
+	}
+	if err != nil {
+		a.ctx.Logger().Errorw("cannot watch accounts", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Something went wrong."})
+		return
+	}
+	defer balances.Close()
+
+	transfers, err := a.TransactionRepository.Watch(watchCtx, cursor.transactions)
+	if errors.Is(err, memorydb.ErrVersionCompacted) {
+		reset = true
+		cursor.transactions = a.TransactionRepository.Version()
+		transfers, err = a.TransactionRepository.Watch(watchCtx, cursor.transactions)
+	}
+	if err != nil {
+		a.ctx.Logger().Errorw("cannot watch transactions", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Something went wrong."})
+		return
+	}
+	defer transfers.Close()
+
+	// headers are flushed right away, so the client knows the stream is open before the first event.
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+	// the stream stays open as long as the client is there, the server write timeout doesn't apply to it.
+	http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+
+	if reset {
+		c.Render(-1, sse.Event{
+			Id:    cursor.String(),
+			Event: "reset",
+			Data:  gin.H{"message": "events since your last event are not retained anymore, fetch the accounts again"},
+		})
+	}
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case change, open := <-balances.Events():
+			if !open {
+				// the client fell behind, it resumes from its last event when it reconnects.
+				return false
+			}
+			cursor.accounts = change.Version
+			if change.New == nil {
+				return true
+			}
+
+			event := balanceEvent{Account: change.New, Version: change.Version}
+			if change.Old != nil {
+				previous := change.Old.Balance
+				event.PreviousBalance = &previous
+			}
+			c.Render(-1, sse.Event{Id: cursor.String(), Event: "balance", Data: event})
+		case change, open := <-transfers.Events():
+			if !open {
+				return false
+			}
+			cursor.transactions = change.Version
+			if change.New == nil {
+				return true
+			}
+			if owner != nil && change.New.Sender != *owner && change.New.Receiver != *owner {
+				return true
+			}
+			c.Render(-1, sse.Event{Id: cursor.String(), Event: "transfer", Data: change.New})
+		case <-keepAlive.C:
+			io.WriteString(w, ": keep-alive\n\n")
+		case <-watchCtx.Done():
+			return false
+		case <-a.ctx.Draining():
+			// the client resumes from its last event on another server.
+			return false
+		}
+		return true
+	})
+}
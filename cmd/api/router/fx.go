@@ -0,0 +1,125 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/0xSherlokMo/banking-system-challenge/ctx"
+	"github.com/0xSherlokMo/banking-system-challenge/fx"
+	"github.com/0xSherlokMo/banking-system-challenge/money"
+	"github.com/gin-gonic/gin"
+)
+
+type FXRouter struct {
+	ctx *ctx.DefaultContext
+}
+
+func InstallFXRouter(engine *gin.Engine, ctx *ctx.DefaultContext) FXRouter {
+	fxRouter := FXRouter{
+		ctx: ctx,
+	}
+
+	fxRouter.install(
+		engine.Group("/fx"),
+	)
+
+	return fxRouter
+}
+
+func (f *FXRouter) install(router *gin.RouterGroup) {
+	router.GET("/rates", f.rates)
+	router.PUT("/rates", AdminOnly(f.ctx.AdminToken()), f.setRates)
+	router.POST("/quotes", f.quote)
+	router.GET("/quotes/:id", f.getQuote)
+}
+
+func (f *FXRouter) rates(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"rates": f.ctx.FX().Rates(),
+	})
+}
+
+// setRates replaces the whole rate table with the rates of the body, in the format of the rates file.
+func (f *FXRouter) setRates(c *gin.Context) {
+	var rates []fx.TableRate
+	if err := c.BindJSON(&rates); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "rates should be an array of {from, to, rate, spread_bps}", "error": err.Error()})
+		return
+	}
+	if err := f.ctx.FX().SetRates(rates); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid rates", "error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"rates": f.ctx.FX().Rates(),
+	})
+}
+
+type quoteRequest struct {
+	From   string          `json:"from"`
+	To     string          `json:"to"`
+	Amount json.RawMessage `json:"amount"`
+}
+
+// quote locks the rate of a pair for a single transfer, the amount is optional and previews what a transfer
+// of it would be credited.
+func (f *FXRouter) quote(c *gin.Context) {
+	var request quoteRequest
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid quote request", "error": err.Error()})
+		return
+	}
+	from, fromErr := money.ParseCurrency(request.From)
+	to, toErr := money.ParseCurrency(request.To)
+	if err := errors.Join(fromErr, toErr); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid quote request", "error": err.Error()})
+		return
+	}
+
+	quote, err := f.ctx.FX().Quote(from, to)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	body := gin.H{"quote": quote}
+
+	if len(request.Amount) != 0 {
+		amount := money.Zero(money.MaxCurrencyScale)
+		err := json.Unmarshal(request.Amount, &amount)
+		if err == nil {
+			amount, err = from.Amount(amount)
+		}
+		var conversion fx.Conversion
+		if err == nil {
+			conversion, err = quote.Convert(amount)
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "invalid amount", "error": err.Error()})
+			return
+		}
+		body["conversion"] = conversion
+	}
+
+	c.JSON(http.StatusCreated, body)
+}
+
+func (f *FXRouter) getQuote(c *gin.Context) {
+	quote, err := f.ctx.FX().GetQuote(c.Param("id"))
+	switch {
+	case errors.Is(err, fx.ErrQuoteNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"message": err.Error()})
+		return
+	case errors.Is(err, fx.ErrQuoteExpired):
+		c.JSON(http.StatusGone, gin.H{"message": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Something went wrong."})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"quote": quote,
+	})
+}
@@ -3,13 +3,18 @@ package router
 import (
 	"net/http"
 
+	"github.com/0xSherlokMo/banking-system-challenge/ctx"
 	"github.com/gin-gonic/gin"
 )
 
-type HealthRouter struct{}
+type HealthRouter struct {
+	ctx *ctx.DefaultContext
+}
 
-func InstallHealthRouter(engine *gin.Engine) HealthRouter {
-	healthRouter := HealthRouter{}
+func InstallHealthRouter(engine *gin.Engine, ctx *ctx.DefaultContext) HealthRouter {
+	healthRouter := HealthRouter{
+		ctx: ctx,
+	}
 
 	healthRouter.install(
 		engine.Group("/health"),
@@ -20,6 +25,7 @@ func InstallHealthRouter(engine *gin.Engine) HealthRouter {
 
 func (h *HealthRouter) install(router *gin.RouterGroup) {
 	router.GET("/", h.ping)
+	router.GET("/ready", h.ready)
 }
 
 func (h *HealthRouter) ping(c *gin.Context) {
@@ -27,3 +33,17 @@ func (h *HealthRouter) ping(c *gin.Context) {
 		"message": "pong",
 	})
 }
+
+// ready fails as soon as the server starts draining, so load balancers stop sending it requests.
+func (h *HealthRouter) ready(c *gin.Context) {
+	if h.ctx.IsDraining() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"message": "draining",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "ready",
+	})
+}
@@ -0,0 +1,120 @@
+package router
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/0xSherlokMo/banking-system-challenge/idempotency"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	IdempotencyKeyHeader      = "Idempotency-Key"
+	IdempotencyReplayedHeader = "Idempotent-Replayed"
+	ClientIdHeader            = "X-Client-Id"
+
+	maxIdempotencyKeyLength = 255
+)
+
+// responseRecorder keeps a copy of the response body while writing it to the client.
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
+
+func (r *responseRecorder) WriteString(data string) (int, error) {
+	r.body.WriteString(data)
+	return r.ResponseWriter.WriteString(data)
+}
+
+// Idempotent replays the first response sent for an Idempotency-Key header.
+// Requests without the header are processed as usual.
+func Idempotent(store *idempotency.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "idempotency key is too long"})
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "invalid request"})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		client := clientID(c)
+		response, err := store.Begin(client, key, fingerprint(c, body))
+		if err != nil {
+			if errors.Is(err, idempotency.ErrKeyReused) {
+				c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"message": "idempotency key was used with a different request"})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"message": "a request with the same idempotency key is still in progress"})
+			return
+		}
+
+		if response != nil {
+			// the headers of the first response, like its ETag and Location, are sent again with it.
+			for name, values := range response.Header {
+				c.Writer.Header()[name] = values
+			}
+			c.Header(IdempotencyReplayedHeader, "true")
+			c.Data(response.Status, response.Header.Get("Content-Type"), response.Body)
+			c.Abort()
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				store.Release(client, key)
+				panic(recovered)
+			}
+		}()
+
+		c.Next()
+
+		store.Complete(client, key, idempotency.Response{
+			Status: recorder.Status(),
+			Header: recorder.Header().Clone(),
+			Body:   recorder.body.Bytes(),
+		})
+	}
+}
+
+// clientID scopes idempotency keys, so two clients can't collide on the same key.
+func clientID(c *gin.Context) string {
+	if client := c.GetHeader(ClientIdHeader); client != "" {
+		return client
+	}
+	return c.ClientIP()
+}
+
+// fingerprint identifies the request a key was sent with, including its If-Match precondition,
+// so a retry with another precondition isn't answered with the result of the first one.
+func fingerprint(c *gin.Context, body []byte) string {
+	hash := sha256.New()
+	for _, part := range []string{c.Request.Method, c.Request.URL.Path, c.Request.URL.RawQuery, c.GetHeader("If-Match")} {
+		// the length prefix keeps the parts from running into each other.
+		fmt.Fprintf(hash, "%d:%s", len(part), part)
+	}
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
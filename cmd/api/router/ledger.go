@@ -0,0 +1,65 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/0xSherlokMo/banking-system-challenge/account"
+	"github.com/0xSherlokMo/banking-system-challenge/ctx"
+	"github.com/0xSherlokMo/banking-system-challenge/memorydb"
+	"github.com/0xSherlokMo/banking-system-challenge/repository"
+	"github.com/gin-gonic/gin"
+)
+
+type LedgerRouter struct {
+	ctx               *ctx.DefaultContext
+	AccountRepository *repository.AccountRepository
+}
+
+func InstallLedgerRouter(engine *gin.Engine, ctx *ctx.DefaultContext) LedgerRouter {
+	ledgerRouter := LedgerRouter{
+		ctx:               ctx,
+		AccountRepository: repository.NewAccountRepository(ctx),
+	}
+
+	ledgerRouter.install(
+		engine.Group("/ledger"),
+	)
+
+	return ledgerRouter
+}
+
+func (l *LedgerRouter) install(router *gin.RouterGroup) {
+	router.GET("/reconcile", l.reconcile)
+	router.GET("/accounts/:id/entries", l.entries)
+}
+
+func (l *LedgerRouter) reconcile(c *gin.Context) {
+	mismatches, err := l.AccountRepository.Reconcile()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Something went wrong."})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"balanced":   len(mismatches) == 0,
+		"mismatches": mismatches,
+	})
+}
+
+func (l *LedgerRouter) entries(c *gin.Context) {
+	key := fmt.Sprintf("%s-%s", account.AccountIdPrefix, c.Param("id"))
+
+	_, err := l.AccountRepository.GetByKey(key, memorydb.ConcurrentNotSafe)
+	if errors.Is(err, memorydb.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "account does not exist",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"entries": l.AccountRepository.Entries(key),
+	})
+}
@@ -0,0 +1,47 @@
+package router
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/0xSherlokMo/banking-system-challenge/ctx"
+	"github.com/0xSherlokMo/banking-system-challenge/memorydb"
+	"github.com/0xSherlokMo/banking-system-challenge/repository"
+	"github.com/gin-gonic/gin"
+)
+
+type TransactionRouter struct {
+	ctx                   *ctx.DefaultContext
+	TransactionRepository *repository.TransactionRepository
+}
+
+func InstallTransactionRouter(engine *gin.Engine, ctx *ctx.DefaultContext) TransactionRouter {
+	transactionRouter := TransactionRouter{
+		ctx:                   ctx,
+		TransactionRepository: repository.NewTransactionRepository(ctx),
+	}
+
+	transactionRouter.install(
+		engine.Group("/transactions"),
+	)
+
+	return transactionRouter
+}
+
+func (t *TransactionRouter) install(router *gin.RouterGroup) {
+	router.GET("/:id", t.getId)
+}
+
+func (t *TransactionRouter) getId(c *gin.Context) {
+	record, err := t.TransactionRepository.GetByID(c.Param("id"))
+	if errors.Is(err, memorydb.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "transaction does not exist",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"transaction": record,
+	})
+}
@@ -0,0 +1,370 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/0xSherlokMo/banking-system-challenge/account"
+	"github.com/0xSherlokMo/banking-system-challenge/memorydb"
+	"github.com/0xSherlokMo/banking-system-challenge/money"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// socketWriteWait is how long a message can take to be written to the client.
+	socketWriteWait = 10 * time.Second
+
+	// socketPongWait is how long the client has to answer a ping before the connection is dropped.
+	socketPongWait = 60 * time.Second
+
+	// socketPingPeriod is how often the client is pinged, it must be less than socketPongWait.
+	socketPingPeriod = socketPongWait * 9 / 10
+
+	// socketMaxMessage is the size limit of a message sent by the client.
+	socketMaxMessage = 4096
+
+	// socketMaxSubscriptions is how many accounts a connection can get the balance of.
+	socketMaxSubscriptions = 64
+)
+
+const (
+	socketTransfer    = "transfer"
+	socketSubscribe   = "subscribe"
+	socketUnsubscribe = "unsubscribe"
+	socketResult      = "result"
+	socketBalance     = "balance"
+	socketEnded       = "unsubscribed"
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// socketRequest is a message sent by the client, ID is sent back with its result.
+//
+//	{"id": "1", "type": "transfer", "from": "<account id>", "to": "<account id>", "amount": "10.50", "if_match": "\"12\""}
+//	{"id": "2", "type": "subscribe", "account": "<account id>"}
+//	{"id": "3", "type": "unsubscribe", "account": "<account id>"}
+type socketRequest struct {
+	ID      string          `json:"id"`
+	Type    string          `json:"type"`
+	From    string          `json:"from"`
+	To      string          `json:"to"`
+	Amount  json.RawMessage `json:"amount"`
+	IfMatch string          `json:"if_match"`
+	Convert bool            `json:"convert"`
+	QuoteID string          `json:"quote_id"`
+	Account string          `json:"account"`
+}
+
+// socketMessage is a message sent to the client. Results carry the ID of their request,
+// with the status and body the HTTP endpoint would have answered.
+type socketMessage struct {
+	ID     string `json:"id,omitempty"`
+	Type   string `json:"type"`
+	Status int    `json:"status,omitempty"`
+	Body   any    `json:"body"`
+}
+
+// socket is a WebSocket connection multiplexing transfers and balance subscriptions.
+// Only the writer goroutine writes to the connection, everything else queues messages to it.
+type socket struct {
+	router   *AccountRouter
+	conn     *websocket.Conn
+	ctx      context.Context
+	cancel   context.CancelFunc
+	outbound chan socketMessage
+	inFlight chan struct{}
+	// transfers are waited for before the connection is closed, so their results are sent,
+	// running are the other goroutines of the connection.
+	transfers sync.WaitGroup
+	running   sync.WaitGroup
+
+	mu            sync.Mutex
+	subscriptions map[string]memorydb.Subscription[*account.Account]
+}
+
+// socket upgrades the request to a WebSocket running transfers concurrently, their results are sent back
+// as soon as they're done so they can come in a different order than the requests.
+func (a *AccountRouter) socket(c *gin.Context) {
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// the upgrader already answered the request.
+		return
+	}
+
+	socketCtx, cancel := context.WithCancel(c.Request.Context())
+	s := &socket{
+		router:        a,
+		conn:          conn,
+		ctx:           socketCtx,
+		cancel:        cancel,
+		outbound:      make(chan socketMessage, a.ctx.SocketInFlight()),
+		inFlight:      make(chan struct{}, a.ctx.SocketInFlight()),
+		subscriptions: make(map[string]memorydb.Subscription[*account.Account]),
+	}
+
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		s.write()
+	}()
+	go s.drain()
+	s.read()
+
+	s.transfers.Wait()
+	cancel()
+	s.running.Wait()
+	<-written
+	conn.Close()
+}
+
+// drain stops reading new requests once the server starts draining,
+// the transfers already running still get their results before the connection is closed.
+func (s *socket) drain() {
+	select {
+	case <-s.router.ctx.Draining():
+		s.conn.SetReadDeadline(time.Now())
+	case <-s.ctx.Done():
+	}
+}
+
+func (s *socket) read() {
+	s.conn.SetReadLimit(socketMaxMessage)
+	s.conn.SetReadDeadline(time.Now().Add(socketPongWait))
+	s.conn.SetPongHandler(func(string) error {
+		return s.conn.SetReadDeadline(time.Now().Add(socketPongWait))
+	})
+
+	for {
+		_, data, err := s.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var request socketRequest
+		if err := json.Unmarshal(data, &request); err != nil {
+			s.reply(request.ID, http.StatusBadRequest, gin.H{"message": "invalid request"})
+			continue
+		}
+
+		switch request.Type {
+		case socketTransfer:
+			s.transfer(request)
+		case socketSubscribe:
+			s.subscribe(request)
+		case socketUnsubscribe:
+			s.unsubscribe(request)
+		default:
+			s.reply(request.ID, http.StatusBadRequest, gin.H{"message": "type should be transfer, subscribe or unsubscribe"})
+		}
+	}
+}
+
+func (s *socket) write() {
+	ping := time.NewTicker(socketPingPeriod)
+	defer ping.Stop()
+
+	for {
+		select {
+		case message := <-s.outbound:
+			s.conn.SetWriteDeadline(time.Now().Add(socketWriteWait))
+			if err := s.conn.WriteJSON(message); err != nil {
+				s.drop()
+				return
+			}
+		case <-ping.C:
+			if err := s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(socketWriteWait)); err != nil {
+				s.drop()
+				return
+			}
+		case <-s.ctx.Done():
+			s.flush()
+			code, reason := websocket.CloseNormalClosure, ""
+			if s.router.ctx.IsDraining() {
+				code, reason = websocket.CloseGoingAway, "server is shutting down"
+			}
+			s.conn.WriteControl(
+				websocket.CloseMessage,
+				websocket.FormatCloseMessage(code, reason),
+				time.Now().Add(socketWriteWait),
+			)
+			return
+		}
+	}
+}
+
+// flush writes the messages still queued when the connection is closed.
+func (s *socket) flush() {
+	for {
+		select {
+		case message := <-s.outbound:
+			s.conn.SetWriteDeadline(time.Now().Add(socketWriteWait))
+			if err := s.conn.WriteJSON(message); err != nil {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// drop stops the connection once it can't be written to, closing it unblocks the reader.
+func (s *socket) drop() {
+	s.cancel()
+	s.conn.Close()
+}
+
+// send queues a message for the writer, it blocks while the client is slow to read
+// so a client that doesn't read its results stops having its requests read too.
+func (s *socket) send(message socketMessage) bool {
+	select {
+	case s.outbound <- message:
+		return true
+	case <-s.ctx.Done():
+		return false
+	}
+}
+
+func (s *socket) reply(id string, status int, body gin.H) bool {
+	return s.send(socketMessage{ID: id, Type: socketResult, Status: status, Body: body})
+}
+
+// transfer runs the transfer in its own goroutine through the same path as the HTTP endpoint.
+// A transfer sent while the connection already has too many running is rejected right away.
+func (s *socket) transfer(message socketRequest) {
+	request := account.TransferRequest{
+		Sender:   fmt.Sprintf("%s-%s", account.AccountIdPrefix, message.From),
+		Reciever: fmt.Sprintf("%s-%s", account.AccountIdPrefix, message.To),
+		Amount:   money.Zero(money.MaxCurrencyScale),
+		Convert:  message.Convert,
+		QuoteID:  message.QuoteID,
+	}
+	if len(message.Amount) != 0 {
+		if err := json.Unmarshal(message.Amount, &request.Amount); err != nil {
+			s.reply(message.ID, http.StatusBadRequest, invalidTransfer(err))
+			return
+		}
+	}
+	version, ok := matchVersion(message.IfMatch)
+	if !ok {
+		s.reply(message.ID, http.StatusPreconditionFailed, gin.H{"message": "sender account was changed, fetch it again"})
+		return
+	}
+	request.SenderVersion = version
+
+	// the transfer is tracked before its goroutine starts, so a drain can't miss it.
+	transferCtx, done, err := s.router.ctx.Track(s.ctx)
+	if err != nil {
+		s.reply(message.ID, http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		return
+	}
+	select {
+	case s.inFlight <- struct{}{}:
+	default:
+		done()
+		s.reply(message.ID, http.StatusTooManyRequests, gin.H{"message": "too many transfers in flight, wait for their results"})
+		return
+	}
+
+	s.transfers.Add(1)
+	go func() {
+		defer s.transfers.Done()
+		defer done()
+		// the slot is freed once the result is queued, so a client that doesn't read can't pile up transfers.
+		defer func() { <-s.inFlight }()
+
+		status, body, _ := s.router.submitTransfer(transferCtx, request)
+		s.reply(message.ID, status, body)
+	}()
+}
+
+// subscribe pushes a balance message every time the balance of the account changes.
+func (s *socket) subscribe(message socketRequest) {
+	key := fmt.Sprintf("%s-%s", account.AccountIdPrefix, message.Account)
+	if _, err := s.router.AccountRepository.GetByKey(key, memorydb.ConcurrentNotSafe); errors.Is(err, memorydb.ErrRecordNotFound) {
+		s.reply(message.ID, http.StatusNotFound, gin.H{"message": "account does not exist"})
+		return
+	}
+
+	status, body, balances := s.watch(key)
+	s.reply(message.ID, status, body)
+	if balances != nil {
+		// the balances are pushed after the reply, so the client knows which account they're from.
+		s.running.Add(1)
+		go s.push(message.Account, key, balances)
+	}
+}
+
+func (s *socket) watch(key string) (int, gin.H, memorydb.Subscription[*account.Account]) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, subscribed := s.subscriptions[key]; subscribed {
+		return http.StatusOK, gin.H{"message": "subscribed"}, nil
+	}
+	if len(s.subscriptions) >= socketMaxSubscriptions {
+		return http.StatusTooManyRequests, gin.H{"message": "too many subscriptions, unsubscribe from another account first"}, nil
+	}
+
+	balances, err := s.router.AccountRepository.Watch(s.ctx, key, 0)
+	if err != nil {
+		s.router.ctx.Logger().Errorw("cannot watch account", "account", key, "error", err)
+		return http.StatusInternalServerError, gin.H{"message": "Something went wrong."}, nil
+	}
+	s.subscriptions[key] = balances
+	return http.StatusOK, gin.H{"message": "subscribed"}, balances
+}
+
+func (s *socket) unsubscribe(message socketRequest) {
+	key := fmt.Sprintf("%s-%s", account.AccountIdPrefix, message.Account)
+
+	s.mu.Lock()
+	balances, subscribed := s.subscriptions[key]
+	delete(s.subscriptions, key)
+	s.mu.Unlock()
+
+	if subscribed {
+		balances.Close()
+	}
+	s.reply(message.ID, http.StatusOK, gin.H{"message": "unsubscribed"})
+}
+
+// push forwards the balance changes of a subscription until it ends.
+// A subscription that fell behind ends with an unsubscribed message, the client subscribes again to get the new balances.
+func (s *socket) push(id string, key string, balances memorydb.Subscription[*account.Account]) {
+	defer s.running.Done()
+
+	for change := range balances.Events() {
+		if change.New == nil {
+			continue
+		}
+		event := balanceEvent{Account: change.New, Version: change.Version}
+		if change.Old != nil {
+			previous := change.Old.Balance
+			event.PreviousBalance = &previous
+		}
+		if !s.send(socketMessage{Type: socketBalance, Body: event}) {
+			return
+		}
+	}
+
+	if !errors.Is(balances.Err(), memorydb.ErrSubscriberLagged) {
+		return
+	}
+	s.mu.Lock()
+	if s.subscriptions[key] == balances {
+		delete(s.subscriptions, key)
+	}
+	s.mu.Unlock()
+	s.send(socketMessage{Type: socketEnded, Body: gin.H{
+		"account": id,
+		"message": "balance updates were dropped as they weren't read fast enough, subscribe again",
+	}})
+}
@@ -0,0 +1,241 @@
+package rpc
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/0xSherlokMo/banking-system-challenge/account"
+	"github.com/0xSherlokMo/banking-system-challenge/accountpb"
+	"github.com/0xSherlokMo/banking-system-challenge/ctx"
+	"github.com/0xSherlokMo/banking-system-challenge/fx"
+	"github.com/0xSherlokMo/banking-system-challenge/memorydb"
+	"github.com/0xSherlokMo/banking-system-challenge/money"
+	"github.com/0xSherlokMo/banking-system-challenge/repository"
+	"github.com/0xSherlokMo/banking-system-challenge/transaction"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// errorDomain is the domain of the error details attached to the failed transfers.
+const errorDomain = "banking-system-challenge"
+
+// AccountService serves the account endpoints over gRPC, through the same repository as the account router.
+type AccountService struct {
+	accountpb.UnimplementedAccountServiceServer
+
+	ctx               *ctx.DefaultContext
+	AccountRepository *repository.AccountRepository
+}
+
+func InstallAccountService(server *grpc.Server, ctx *ctx.DefaultContext) *AccountService {
+	accountService := &AccountService{
+		ctx:               ctx,
+		AccountRepository: repository.NewAccountRepository(ctx),
+	}
+
+	accountpb.RegisterAccountServiceServer(server, accountService)
+
+	return accountService
+}
+
+func (a *AccountService) GetAccount(_ context.Context, request *accountpb.GetAccountRequest) (*accountpb.GetAccountResponse, error) {
+	key := fmt.Sprintf("%s-%s", account.AccountIdPrefix, request.GetId())
+
+	owner, version, err := a.AccountRepository.GetWithVersion(key)
+	if errors.Is(err, memorydb.ErrRecordNotFound) {
+		return nil, status.Error(codes.NotFound, "account does not exist")
+	}
+	if err != nil {
+		a.ctx.Logger().Errorw("cannot get account", "account", key, "error", err)
+		return nil, status.Error(codes.Internal, "Something went wrong.")
+	}
+
+	return &accountpb.GetAccountResponse{
+		Account: toAccount(owner),
+		Version: version,
+	}, nil
+}
+
+// ListAccounts streams the accounts of a single snapshot, like GET /accounts.
+func (a *AccountService) ListAccounts(_ *accountpb.ListAccountsRequest, stream accountpb.AccountService_ListAccountsServer) error {
+	for _, listed := range a.AccountRepository.All() {
+		if err := stream.Send(&accountpb.ListAccountsResponse{Account: toAccount(listed)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *AccountService) Transfer(requestCtx context.Context, request *accountpb.TransferRequest) (*accountpb.TransferResponse, error) {
+	// the amount is validated against the sender's currency once it's locked.
+	amount, err := money.Parse(request.GetAmount(), money.MaxCurrencyScale)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	lockCtx, cancel := context.WithTimeout(requestCtx, a.ctx.LockTimeout())
+	defer cancel()
+	senderAccount, record, err := a.AccountRepository.TransferMoney(lockCtx, account.TransferRequest{
+		Sender:        fmt.Sprintf("%s-%s", account.AccountIdPrefix, request.GetFrom()),
+		Reciever:      fmt.Sprintf("%s-%s", account.AccountIdPrefix, request.GetTo()),
+		Amount:        amount,
+		SenderVersion: request.GetExpectedVersion(),
+		Convert:       request.GetConvert(),
+		QuoteID:       request.GetQuoteId(),
+	})
+	if err != nil {
+		return nil, a.transferError(err, record)
+	}
+
+	return &accountpb.TransferResponse{
+		Balance:       senderAccount.Balance.String(),
+		TransactionId: record.ID.String(),
+	}, nil
+}
+
+// WatchBalances streams the balance changes of an account, or of every account for the callers sending the admin token.
+// A stream that can't be resumed from the requested version anymore fails with OutOfRange, one that fell behind with ResourceExhausted
+// and one open when the server starts draining with Unavailable: they can resume from the version of the last change they received.
+func (a *AccountService) WatchBalances(request *accountpb.WatchBalancesRequest, stream accountpb.AccountService_WatchBalancesServer) error {
+	var key string
+	if request.GetId() == "" {
+		if err := a.authorize(stream.Context()); err != nil {
+			return err
+		}
+	} else {
+		key = fmt.Sprintf("%s-%s", account.AccountIdPrefix, request.GetId())
+		if _, err := a.AccountRepository.GetByKey(key, memorydb.ConcurrentNotSafe); errors.Is(err, memorydb.ErrRecordNotFound) {
+			return status.Error(codes.NotFound, "account does not exist")
+		}
+	}
+
+	balances, err := a.AccountRepository.Watch(stream.Context(), key, request.GetFromVersion())
+	if errors.Is(err, memorydb.ErrVersionCompacted) {
+		return status.Error(codes.OutOfRange, "changes after this version are not retained anymore, get the account again")
+	}
+	if err != nil {
+		a.ctx.Logger().Errorw("cannot watch accounts", "error", err)
+		return status.Error(codes.Internal, "Something went wrong.")
+	}
+	defer balances.Close()
+
+	for {
+		var change memorydb.ChangeEvent[*account.Account]
+		var open bool
+		select {
+		case change, open = <-balances.Events():
+		case <-a.ctx.Draining():
+			return status.Error(codes.Unavailable, "server is shutting down, resume from the last received version")
+		}
+		if !open {
+			break
+		}
+		if change.New == nil {
+			continue
+		}
+
+		event := &accountpb.WatchBalancesResponse{
+			Account: toAccount(change.New),
+			Version: change.Version,
+		}
+		if change.Old != nil {
+			event.PreviousBalance = change.Old.Balance.String()
+		}
+		if err := stream.Send(event); err != nil {
+			return err
+		}
+	}
+
+	if errors.Is(balances.Err(), memorydb.ErrSubscriberLagged) {
+		return status.Error(codes.ResourceExhausted, "changes were dropped as they weren't read fast enough, resume from the last received version")
+	}
+	return status.FromContextError(stream.Context().Err()).Err()
+}
+
+// authorize lets through the calls carrying the admin token as a bearer token in their authorization metadata.
+// Admin calls are disabled when no token is configured.
+func (a *AccountService) authorize(callCtx context.Context) error {
+	token := a.ctx.AdminToken()
+	if token == "" {
+		return status.Error(codes.PermissionDenied, "admin calls are disabled")
+	}
+
+	md, _ := metadata.FromIncomingContext(callCtx)
+	for _, value := range md.Get("authorization") {
+		sent, found := strings.CutPrefix(value, "Bearer ")
+		if found && subtle.ConstantTimeCompare([]byte(sent), []byte(token)) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "admin token is missing or invalid")
+}
+
+// transferError maps the error of a failed transfer to its status code.
+// The id of the failed transaction is attached as an ErrorInfo detail when one was recorded.
+func (a *AccountService) transferError(err error, record *transaction.Transaction) error {
+	var code codes.Code
+	message := err.Error()
+	switch {
+	case errors.Is(err, ctx.ErrDraining):
+		code = codes.Unavailable
+	case errors.Is(err, memorydb.ErrRowLocked):
+		code, message = codes.Unavailable, "account is busy with other transfers, try again later"
+	case errors.Is(err, memorydb.ErrRecordNotFound):
+		code, message = codes.NotFound, "account does not exist"
+	case errors.Is(err, memorydb.ErrVersionMismatch):
+		code, message = codes.Aborted, "sender account was changed, fetch it again"
+	case errors.Is(err, account.ErrInsufficientFunds),
+		errors.Is(err, account.ErrAccountClosed),
+		errors.Is(err, account.ErrCurrencyMismatch),
+		errors.Is(err, fx.ErrNoRate),
+		errors.Is(err, fx.ErrQuoteNotFound),
+		errors.Is(err, fx.ErrQuoteExpired),
+		errors.Is(err, fx.ErrQuoteMismatch):
+		code = codes.FailedPrecondition
+	case errors.Is(err, account.ErrInvalidAmount),
+		errors.Is(err, fx.ErrTooSmall),
+		errors.Is(err, account.ErrSameAccount),
+		errors.Is(err, money.ErrOverflow),
+		errors.Is(err, money.ErrTooPrecise):
+		code = codes.InvalidArgument
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	default:
+		a.ctx.Logger().Errorw("transfer failed", "error", err)
+		return status.Error(codes.Internal, "Something went wrong.")
+	}
+
+	failure := status.New(code, message)
+	if record == nil {
+		return failure.Err()
+	}
+	detailed, detailErr := failure.WithDetails(&errdetails.ErrorInfo{
+		Reason:   "TRANSFER_FAILED",
+		Domain:   errorDomain,
+		Metadata: map[string]string{"transaction_id": record.ID.String()},
+	})
+	if detailErr != nil {
+		return failure.Err()
+	}
+	return detailed.Err()
+}
+
+func toAccount(source *account.Account) *accountpb.Account {
+	converted := &accountpb.Account{
+		Id:       source.ID.String(),
+		Name:     source.Name,
+		Balance:  source.Balance.String(),
+		Currency: string(source.Denomination()),
+	}
+	if source.ClosedAt != nil {
+		converted.ClosedAt = timestamppb.New(*source.ClosedAt)
+	}
+	return converted
+}
@@ -0,0 +1,132 @@
+package rpc_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/0xSherlokMo/banking-system-challenge/accountpb"
+	"github.com/0xSherlokMo/banking-system-challenge/cmd/api/apitest"
+	"github.com/0xSherlokMo/banking-system-challenge/cmd/api/rpc"
+	"github.com/0xSherlokMo/banking-system-challenge/ctx"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestAccountService(t *testing.T) {
+	app := apitest.NewContext().WithLockTimeout(50 * time.Millisecond)
+	accounts := apitest.SeedAccounts(app, "100", 3)
+	client := serve(t, app)
+	callCtx := context.Background()
+
+	got, err := client.GetAccount(callCtx, &accountpb.GetAccountRequest{Id: accounts[0].ID.String()})
+	if err != nil || got.Account.Balance != "100.00" || got.Version == 0 {
+		t.Fatalf("expected the account with its version but got %+v %v", got, err)
+	}
+	if _, err := client.GetAccount(callCtx, &accountpb.GetAccountRequest{Id: "missing"}); status.Code(err) != codes.NotFound {
+		t.Errorf("expected a missing account to be NotFound but got %v", err)
+	}
+
+	listed, err := client.ListAccounts(callCtx, &accountpb.ListAccountsRequest{})
+	if err != nil {
+		t.Fatalf("cannot list accounts: %v", err)
+	}
+	count := 0
+	for {
+		if _, err := listed.Recv(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("cannot list accounts: %v", err)
+		}
+		count++
+	}
+	if count != len(accounts) {
+		t.Errorf("expected %d accounts to be listed but got %d", len(accounts), count)
+	}
+
+	watchCtx, stop := context.WithCancel(callCtx)
+	defer stop()
+	// the watch resumes from the version the receiver was read at, so it can't miss the transfer.
+	receiver, _ := client.GetAccount(callCtx, &accountpb.GetAccountRequest{Id: accounts[1].ID.String()})
+	balances, err := client.WatchBalances(watchCtx, &accountpb.WatchBalancesRequest{Id: accounts[1].ID.String(), FromVersion: receiver.Version})
+	if err != nil {
+		t.Fatalf("cannot watch balances: %v", err)
+	}
+
+	transferred, err := client.Transfer(callCtx, &accountpb.TransferRequest{From: accounts[0].ID.String(), To: accounts[1].ID.String(), Amount: "10"})
+	if err != nil || transferred.Balance != "90.00" || transferred.TransactionId == "" {
+		t.Fatalf("expected the transfer to go through but got %+v %v", transferred, err)
+	}
+	event, err := balances.Recv()
+	if err != nil || event.Account.Balance != "110.00" || event.PreviousBalance != "100.00" {
+		t.Errorf("expected the receiver balance to be streamed but got %+v %v", event, err)
+	}
+
+	cases := []struct {
+		name    string
+		request *accountpb.TransferRequest
+		code    codes.Code
+	}{
+		{"insufficient funds", &accountpb.TransferRequest{From: accounts[0].ID.String(), To: accounts[1].ID.String(), Amount: "1000"}, codes.FailedPrecondition},
+		{"same account", &accountpb.TransferRequest{From: accounts[0].ID.String(), To: accounts[0].ID.String(), Amount: "1"}, codes.InvalidArgument},
+		{"too precise", &accountpb.TransferRequest{From: accounts[0].ID.String(), To: accounts[1].ID.String(), Amount: "1.001"}, codes.InvalidArgument},
+		{"missing account", &accountpb.TransferRequest{From: accounts[0].ID.String(), To: "missing", Amount: "1"}, codes.NotFound},
+		{"stale version", &accountpb.TransferRequest{From: accounts[0].ID.String(), To: accounts[1].ID.String(), Amount: "1", ExpectedVersion: got.Version}, codes.Aborted},
+	}
+	for _, c := range cases {
+		if _, err := client.Transfer(callCtx, c.request); status.Code(err) != c.code {
+			t.Errorf("%s: expected %v but got %v", c.name, c.code, err)
+		}
+	}
+
+	_, err = client.Transfer(callCtx, &accountpb.TransferRequest{From: accounts[0].ID.String(), To: accounts[1].ID.String(), Amount: "1000"})
+	var transactionID string
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok {
+			transactionID = info.Metadata["transaction_id"]
+		}
+	}
+	if transactionID == "" {
+		t.Errorf("expected the failed transaction id to be attached to %v", err)
+	}
+
+	lease, _ := app.MemoryDB().Lock(accounts[2].GetID())
+	_, err = client.Transfer(callCtx, &accountpb.TransferRequest{From: accounts[2].ID.String(), To: accounts[1].ID.String(), Amount: "1"})
+	app.MemoryDB().Unlock(accounts[2].GetID(), lease)
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("expected a transfer from a locked account to be Unavailable but got %v", err)
+	}
+
+	all, err := client.WatchBalances(callCtx, &accountpb.WatchBalancesRequest{})
+	if err == nil {
+		_, err = all.Recv()
+	}
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected watching every account to need the admin token but got %v", err)
+	}
+}
+
+func serve(t *testing.T, app *ctx.DefaultContext) accountpb.AccountServiceClient {
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	rpc.InstallAccountService(server, app)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient(
+		"passthrough:///bufconn",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return listener.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("cannot dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return accountpb.NewAccountServiceClient(conn)
+}
@@ -1,45 +1,45 @@
 package ctx
 
 import (
-	"encoding/json"
-	"net/http"
+	"context"
+	"errors"
+	"fmt"
 
-	"github.com/0xSherlokMo/banking-system-challenge/account"
+	"github.com/0xSherlokMo/banking-system-challenge/ledger"
+	"github.com/0xSherlokMo/banking-system-challenge/memorydb"
+	"github.com/0xSherlokMo/banking-system-challenge/seed"
 )
 
-const (
-	jsonFileURL = "https://gist.githubusercontent.com/paytabs-engineering/c470210ebb19511a4e744aefc871974f/raw/6296df58428c89b8f852a6a83b0a5d0ac38289b6/accounts-mock.json"
-)
-
-func (d *DefaultContext) LoadAccounts() *DefaultContext {
-	d.Logger().Infow("Loading accounts", "url", jsonFileURL)
-	res, err := http.Get(jsonFileURL)
-	if err != nil {
-		d.Logger().Fatalw(
-			"cannot load accounts",
-			"error", err,
-		)
-	}
-	defer res.Body.Close()
-	if res.StatusCode != http.StatusOK {
-		d.Logger().Fatalw(
-			"unexpected http GET status",
-			"status", res.StatusCode,
-		)
+// LoadAccounts seeds the accounts from the given loader, with their opening balances.
+// It's skipped when accounts were already recovered from the write-ahead log.
+// An account that's already in the database is reported instead of being skipped.
+func (d *DefaultContext) LoadAccounts(loadCtx context.Context, loader seed.Loader) error {
+	if d.MemoryDB().Length() > 0 {
+		d.Logger().Infow("Skipping accounts seed, accounts recovered from storage", "accounts", d.MemoryDB().Length())
+		return nil
 	}
 
-	var accounts []account.Account
-	err = json.NewDecoder(res.Body).Decode(&accounts)
+	d.Logger().Infow("Loading accounts", "source", loader.String())
+	accounts, err := loader.Load(loadCtx)
 	if err != nil {
-		d.Logger().Fatalw(
-			"cannot decode accounts",
-			"error", err,
-		)
+		return fmt.Errorf("cannot load accounts from %s: %w", loader, err)
 	}
 
 	for idx, account := range accounts {
-		d.MemoryDB().Setnx(account.GetID(), &accounts[idx])
+		err := d.MemoryDB().Setnx(account.GetID(), &accounts[idx])
+		if errors.Is(err, memorydb.ErrRecordExists) {
+			return fmt.Errorf("cannot seed account %s: %w", account.ID, seed.ErrDuplicateID)
+		}
+		if err != nil {
+			return fmt.Errorf("cannot seed account %s: %w", account.ID, err)
+		}
+
+		err = d.Ledger().Record(ledger.NewOpeningEntry(account.GetID(), account.Denomination(), account.Balance))
+		if err != nil {
+			return fmt.Errorf("cannot record opening balance of %s: %w", account.ID, err)
+		}
 	}
 
-	return d
+	d.Logger().Infow("Loaded accounts", "accounts", len(accounts))
+	return nil
 }
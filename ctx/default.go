@@ -1,26 +1,70 @@
 package ctx
 
 import (
+	"context"
+	"io"
 	"log"
+	"time"
 
 	"github.com/0xSherlokMo/banking-system-challenge/account"
+	"github.com/0xSherlokMo/banking-system-challenge/fx"
+	"github.com/0xSherlokMo/banking-system-challenge/idempotency"
+	"github.com/0xSherlokMo/banking-system-challenge/ledger"
 	"github.com/0xSherlokMo/banking-system-challenge/memorydb"
+	"github.com/0xSherlokMo/banking-system-challenge/transaction"
 	"go.uber.org/zap"
 )
 
+const (
+	DefaultLockTimeout = 2 * time.Second
+
+	// DefaultSocketInFlight is how many transfers a WebSocket connection can have running at once.
+	DefaultSocketInFlight = 32
+)
+
 type Database[T memorydb.IdentifiedRecord] interface {
-	Set(key string, record T, opts memorydb.Opts)
+	Set(key string, record T, opts memorydb.Opts) error
 	Setnx(key string, record T) error
+	Delete(key string, opts memorydb.Opts) error
 	GetM(terms []string, opts memorydb.Opts) []T
 	Get(key string, opts memorydb.Opts) (T, error)
+	GetWithVersion(key string) (T, uint64, error)
+	CompareAndSet(key string, expected uint64, record T) (uint64, error)
 	Keys() []memorydb.Key
 	Length() int
-	Lock(key string) error
-	Unlock(key string) error
+	Lock(key string) (memorydb.Token, error)
+	LockContext(lockCtx context.Context, key string) (memorydb.Token, error)
+	Unlock(key string, lease memorydb.Token) error
+	Update(updateCtx context.Context, fn func(tx memorydb.Txn[T]) error) error
+	View(fn func(tx memorydb.ReadTxn[T]) error) error
+	Watch(watchCtx context.Context, prefix string, opts memorydb.WatchOptions) (memorydb.Subscription[T], error)
+	Version() uint64
+	Sync() error
+	Close() error
 }
+
 type DefaultContext struct {
-	db     Database[*account.Account]
-	logger *zap.SugaredLogger
+	db           Database[*account.Account]
+	ledger       *ledger.Ledger
+	transactions *transaction.History
+	idempotency  *idempotency.Store
+	fx           *fx.Exchange
+	lockTimeout  time.Duration
+	inFlight     int
+	adminToken   string
+	logger       *zap.SugaredLogger
+	closers      []io.Closer
+	lifecycle    *lifecycle
+	references   *references
+
+	snapshotters  []Snapshotter
+	stopSnapshots chan struct{}
+	snapshotsDone chan struct{}
+}
+
+// Snapshotter is implemented by databases that can dump their records to disk.
+type Snapshotter interface {
+	Snapshot() (memorydb.SnapshotInfo, error)
 }
 
 func NewDefaultContext() *DefaultContext {
@@ -30,7 +74,9 @@ func NewDefaultContext() *DefaultContext {
 	}
 	sugarLogger := logger.Sugar()
 	return &DefaultContext{
-		logger: sugarLogger,
+		logger:     sugarLogger,
+		lifecycle:  newLifecycle(),
+		references: &references{},
 	}
 }
 
@@ -49,10 +95,140 @@ func (d *DefaultContext) MemoryDB() Database[*account.Account] {
 	return d.db
 }
 
+func (d *DefaultContext) WithLedger() *DefaultContext {
+	if d.ledger != nil {
+		return d
+	}
+	d.ledger = ledger.New(memorydb.Default[*ledger.Entry]())
+	return d
+}
+
+func (d *DefaultContext) Ledger() *ledger.Ledger {
+	if d.ledger == nil {
+		d.WithLedger()
+	}
+	return d.ledger
+}
+
+func (d *DefaultContext) WithTransactions() *DefaultContext {
+	if d.transactions != nil {
+		return d
+	}
+	d.transactions = transaction.New(memorydb.Default[*transaction.Transaction]())
+	return d
+}
+
+func (d *DefaultContext) Transactions() *transaction.History {
+	if d.transactions == nil {
+		d.WithTransactions()
+	}
+	return d.transactions
+}
+
+// WithIdempotency sets up the idempotency key store, keys are forgotten after the retention window.
+func (d *DefaultContext) WithIdempotency(retention time.Duration) *DefaultContext {
+	if d.idempotency != nil {
+		return d
+	}
+	d.idempotency = idempotency.NewStore(retention)
+	return d
+}
+
+func (d *DefaultContext) Idempotency() *idempotency.Store {
+	if d.idempotency == nil {
+		d.WithIdempotency(idempotency.DefaultRetention)
+	}
+	return d.idempotency
+}
+
+// WithFX sets the exchange converting the transfers between accounts in different currencies.
+func (d *DefaultContext) WithFX(exchange *fx.Exchange) *DefaultContext {
+	d.fx = exchange
+	return d
+}
+
+// FX returns the exchange, it has no rates unless they're loaded from a file or set with the admin API.
+func (d *DefaultContext) FX() *fx.Exchange {
+	if d.fx == nil {
+		d.fx = fx.New(fx.Options{})
+	}
+	return d.fx
+}
+
+// WithLockTimeout sets how long a transfer waits for its accounts to be unlocked before giving up.
+func (d *DefaultContext) WithLockTimeout(timeout time.Duration) *DefaultContext {
+	d.lockTimeout = timeout
+	return d
+}
+
+// WithLeaseTTL sets how long an account lock is held before it's released on its own,
+// so a crashed handler can't keep an account locked forever.
+func (d *DefaultContext) WithLeaseTTL(ttl time.Duration) *DefaultContext {
+	if ttl <= 0 {
+		return d
+	}
+	if leased, ok := d.MemoryDB().(interface{ SetLeaseTTL(time.Duration) }); ok {
+		leased.SetLeaseTTL(ttl)
+	}
+	return d
+}
+
+func (d *DefaultContext) LockTimeout() time.Duration {
+	if d.lockTimeout <= 0 {
+		return DefaultLockTimeout
+	}
+	return d.lockTimeout
+}
+
+// WithSocketInFlight caps the transfers a WebSocket connection can have running at once,
+// the ones sent over the cap are rejected until a result is sent back.
+func (d *DefaultContext) WithSocketInFlight(transfers int) *DefaultContext {
+	d.inFlight = transfers
+	return d
+}
+
+func (d *DefaultContext) SocketInFlight() int {
+	if d.inFlight <= 0 {
+		return DefaultSocketInFlight
+	}
+	return d.inFlight
+}
+
+// WithAdminToken sets the bearer token of the admin endpoints, they're disabled without one.
+func (d *DefaultContext) WithAdminToken(token string) *DefaultContext {
+	d.adminToken = token
+	return d
+}
+
+func (d *DefaultContext) AdminToken() string {
+	return d.adminToken
+}
+
+// WithLogger replaces the development logger the context starts with.
+func (d *DefaultContext) WithLogger(logger *zap.SugaredLogger) *DefaultContext {
+	d.logger.Sync()
+	d.logger = logger
+	return d
+}
+
 func (d *DefaultContext) Logger() *zap.SugaredLogger {
 	return d.logger
 }
 
+// Exit flushes and closes the storage, and syncs the logger.
+// The work tracked by Track should be drained first, see Drain and Drained: while some of it still runs
+// the storage is left open, so it isn't closed under a transfer writing to it.
 func (d *DefaultContext) Exit() {
+	d.stopSnapshotting()
+	if running := d.Running(); running > 0 {
+		d.logger.Errorw("storage left open, tracked work is still running", "running", running)
+		d.logger.Sync()
+		return
+	}
+	for _, closer := range d.closers {
+		if err := closer.Close(); err != nil {
+			d.logger.Errorw("cannot close storage", "error", err)
+		}
+	}
 	d.logger.Sync()
 }
@@ -0,0 +1,118 @@
+package ctx
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrDraining is returned by Track once the context started draining, new work should be retried on another instance.
+var ErrDraining = errors.New("server is shutting down, try again")
+
+// trackedKey marks the contexts of tracked work, the work they start is already covered.
+type trackedKey struct{}
+
+// lifecycle counts the work running against the storage, so it's only closed once that work is done.
+type lifecycle struct {
+	mu       sync.Mutex
+	running  int
+	draining chan struct{}
+	drained  chan struct{}
+	idle     bool
+}
+
+func newLifecycle() *lifecycle {
+	return &lifecycle{
+		draining: make(chan struct{}),
+		drained:  make(chan struct{}),
+	}
+}
+
+// Track marks the start of work the context waits for before exiting, like a transfer.
+// The returned function marks its end, it must be called exactly once. Work is refused with ErrDraining
+// once draining started, so nothing can start after Drained returned.
+// The returned context carries the tracking: work tracked again with it, like the transfer of a tracked
+// WebSocket message, is accepted even while draining and its done function is a no-op.
+func (d *DefaultContext) Track(workCtx context.Context) (context.Context, func(), error) {
+	if workCtx.Value(trackedKey{}) == d.lifecycle {
+		return workCtx, func() {}, nil
+	}
+
+	d.lifecycle.mu.Lock()
+	select {
+	case <-d.lifecycle.draining:
+		d.lifecycle.mu.Unlock()
+		return workCtx, func() {}, ErrDraining
+	default:
+	}
+	d.lifecycle.running++
+	d.lifecycle.mu.Unlock()
+
+	var once sync.Once
+	return context.WithValue(workCtx, trackedKey{}, d.lifecycle), func() {
+		once.Do(func() {
+			d.lifecycle.mu.Lock()
+			defer d.lifecycle.mu.Unlock()
+			d.lifecycle.running--
+			d.lifecycle.settle()
+		})
+	}, nil
+}
+
+// Drain starts draining: Draining is closed, so readiness fails and the long-lived streams end.
+// The work already tracked keeps running, Drained waits for it, and new work is refused.
+func (d *DefaultContext) Drain() {
+	d.lifecycle.mu.Lock()
+	defer d.lifecycle.mu.Unlock()
+	select {
+	case <-d.lifecycle.draining:
+		return
+	default:
+	}
+	close(d.lifecycle.draining)
+	d.lifecycle.settle()
+}
+
+// Draining is closed once the context starts draining.
+func (d *DefaultContext) Draining() <-chan struct{} {
+	return d.lifecycle.draining
+}
+
+func (d *DefaultContext) IsDraining() bool {
+	select {
+	case <-d.lifecycle.draining:
+		return true
+	default:
+		return false
+	}
+}
+
+// Drained waits until the context is draining and no tracked work is running anymore, or until waitCtx is done.
+func (d *DefaultContext) Drained(waitCtx context.Context) error {
+	select {
+	case <-d.lifecycle.drained:
+		return nil
+	case <-waitCtx.Done():
+		return waitCtx.Err()
+	}
+}
+
+// Running returns how much tracked work is still running, like the transfers a drain timed out on.
+func (d *DefaultContext) Running() int {
+	d.lifecycle.mu.Lock()
+	defer d.lifecycle.mu.Unlock()
+	return d.lifecycle.running
+}
+
+// settle closes drained once nothing runs while draining, mu must be held.
+func (l *lifecycle) settle() {
+	if l.idle || l.running > 0 {
+		return
+	}
+	select {
+	case <-l.draining:
+		l.idle = true
+		close(l.drained)
+	default:
+	}
+}
@@ -0,0 +1,48 @@
+package ctx
+
+import (
+	"sync"
+
+	"github.com/0xSherlokMo/banking-system-challenge/account"
+	"github.com/0xSherlokMo/banking-system-challenge/memorydb"
+)
+
+// references indexes the accounts by their external reference. The references are stored on the accounts,
+// so the index is built from them on first use, including the ones recovered from the write-ahead log.
+type references struct {
+	build sync.Once
+	mu    sync.Mutex
+	keys  map[string]memorydb.Key
+}
+
+// ReserveReference claims the external reference for the account key, or fails with account.ErrDuplicateRef
+// if another account uses it. The reservation is given back with ReleaseReference if the account can't be stored.
+func (d *DefaultContext) ReserveReference(ref string, key memorydb.Key) error {
+	d.references.build.Do(func() {
+		d.references.keys = make(map[string]memorydb.Key)
+		d.MemoryDB().View(func(tx memorydb.ReadTxn[*account.Account]) error {
+			for _, owner := range tx.GetM(tx.Keys()) {
+				if owner.ExternalRef != "" {
+					d.references.keys[owner.ExternalRef] = owner.GetID()
+				}
+			}
+			return nil
+		})
+	})
+
+	d.references.mu.Lock()
+	defer d.references.mu.Unlock()
+	if _, used := d.references.keys[ref]; used {
+		return account.ErrDuplicateRef
+	}
+	d.references.keys[ref] = key
+	return nil
+}
+
+func (d *DefaultContext) ReleaseReference(ref string, key memorydb.Key) {
+	d.references.mu.Lock()
+	defer d.references.mu.Unlock()
+	if d.references.keys[ref] == key {
+		delete(d.references.keys, ref)
+	}
+}
@@ -0,0 +1,97 @@
+package ctx
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/0xSherlokMo/banking-system-challenge/account"
+	"github.com/0xSherlokMo/banking-system-challenge/ledger"
+	"github.com/0xSherlokMo/banking-system-challenge/memorydb"
+	"github.com/0xSherlokMo/banking-system-challenge/transaction"
+)
+
+// WithDurability backs the accounts, ledger and transactions databases with write-ahead logs
+// stored under dir, and recovers their records from them.
+// It should be called before WithMemoryDB, WithLedger and WithTransactions.
+func (d *DefaultContext) WithDurability(dir string, opts memorydb.WALOptions) *DefaultContext {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		d.Logger().Fatalw("cannot create data directory", "dir", dir, "error", err)
+	}
+
+	if d.db == nil {
+		d.db = openDurable[*account.Account](d, filepath.Join(dir, "accounts.wal"), opts)
+	}
+	if d.ledger == nil {
+		d.ledger = ledger.New(openDurable[*ledger.Entry](d, filepath.Join(dir, "ledger.wal"), opts))
+	}
+	if d.transactions == nil {
+		d.transactions = transaction.New(openDurable[*transaction.Transaction](d, filepath.Join(dir, "transactions.wal"), opts))
+	}
+	return d
+}
+
+func openDurable[T memorydb.IdentifiedRecord](d *DefaultContext, path string, opts memorydb.WALOptions) *memorydb.MemoryDB[T] {
+	opts.Path = path
+	db, info, err := memorydb.Open[T](opts)
+	if err != nil {
+		d.Logger().Fatalw("cannot open write-ahead log", "path", path, "error", err)
+	}
+
+	if info.DroppedBytes > 0 {
+		d.Logger().Warnw("dropped corrupt write-ahead log tail", "path", path, "bytes", info.DroppedBytes)
+	}
+	if info.SkippedSnapshots > 0 {
+		d.Logger().Warnw("skipped corrupt snapshots", "path", path, "snapshots", info.SkippedSnapshots)
+	}
+	d.Logger().Infow("recovered write-ahead log", "path", path, "snapshot_lsn", info.SnapshotLSN, "records", info.Replayed)
+
+	d.closers = append(d.closers, db)
+	d.snapshotters = append(d.snapshotters, db)
+	return db
+}
+
+// WithSnapshots snapshots every durable database on the given interval, compacting their write-ahead logs.
+// It's a no-op unless WithDurability was called first.
+func (d *DefaultContext) WithSnapshots(interval time.Duration) *DefaultContext {
+	if interval <= 0 || len(d.snapshotters) == 0 || d.stopSnapshots != nil {
+		return d
+	}
+
+	d.stopSnapshots = make(chan struct{})
+	d.snapshotsDone = make(chan struct{})
+	go func() {
+		defer close(d.snapshotsDone)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-d.stopSnapshots:
+				return
+			case <-ticker.C:
+				d.Snapshot()
+			}
+		}
+	}()
+	return d
+}
+
+// Snapshot takes a snapshot of every durable database.
+func (d *DefaultContext) Snapshot() {
+	for _, snapshotter := range d.snapshotters {
+		info, err := snapshotter.Snapshot()
+		if err != nil {
+			d.Logger().Errorw("cannot take snapshot", "error", err)
+			continue
+		}
+		d.Logger().Infow("took snapshot", "path", info.Path, "lsn", info.LSN, "records", info.Records)
+	}
+}
+
+func (d *DefaultContext) stopSnapshotting() {
+	if d.stopSnapshots == nil {
+		return
+	}
+	close(d.stopSnapshots)
+	<-d.snapshotsDone
+}
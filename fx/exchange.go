@@ -0,0 +1,356 @@
+package fx
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/0xSherlokMo/banking-system-challenge/calculator"
+	"github.com/0xSherlokMo/banking-system-challenge/money"
+	"github.com/google/uuid"
+)
+
+const (
+	basisPoints = 10000
+
+	// MaxSpreadBPS is the widest spread, a spread of the whole rate would convert everything to nothing.
+	MaxSpreadBPS = basisPoints - 1
+
+	// DefaultQuoteTTL is how long a quote locks its rate when no other TTL is set.
+	DefaultQuoteTTL = 30 * time.Second
+)
+
+var (
+	ErrNoRate        = errors.New("no exchange rate for these currencies")
+	ErrSameCurrency  = errors.New("cannot convert a currency to itself")
+	ErrInvalidSpread = errors.New("spread should be between 0 and 9999 basis points")
+	ErrDuplicatePair = errors.New("duplicate rate")
+	ErrTooSmall      = errors.New("amount is too small to be converted")
+	ErrQuoteNotFound = errors.New("quote does not exist")
+	ErrQuoteExpired  = errors.New("quote expired, request a new one")
+	ErrQuoteMismatch = errors.New("quote is for other currencies")
+)
+
+// Pair is the direction of a conversion.
+type Pair struct {
+	From money.Currency
+	To   money.Currency
+}
+
+// TableRate is a row of the rate table, as read from the rates file and the admin API.
+// Its spread overrides the exchange spread for the pair when it's set.
+type TableRate struct {
+	From      money.Currency `json:"from"`
+	To        money.Currency `json:"to"`
+	Rate      Rate           `json:"rate"`
+	SpreadBPS *int           `json:"spread_bps,omitempty"`
+}
+
+// Quote locks the rate of a pair until it expires, the transfer referencing it is converted at its rate.
+// A quote is used by a single transfer, so a favourable rate can't be reused for more or larger transfers.
+type Quote struct {
+	ID        uuid.UUID      `json:"id"`
+	From      money.Currency `json:"from"`
+	To        money.Currency `json:"to"`
+	Rate      Rate           `json:"rate"`
+	MidRate   Rate           `json:"mid_rate"`
+	SpreadBPS int            `json:"spread_bps"`
+	ExpiresAt time.Time      `json:"expires_at"`
+
+	// reserved is set while a transfer converted with the quote isn't committed yet.
+	reserved bool
+}
+
+// Leg is one side of a conversion.
+type Leg struct {
+	Currency money.Currency `json:"currency"`
+	Amount   money.Money    `json:"amount"`
+}
+
+// UnmarshalJSON decodes the amount at the scale of the leg currency.
+func (l *Leg) UnmarshalJSON(data []byte) error {
+	type stored Leg
+	if err := json.Unmarshal(data, (*stored)(l)); err != nil {
+		return err
+	}
+	amount, err := l.Currency.Amount(l.Amount)
+	if err != nil {
+		return fmt.Errorf("amount of %s: %w", l.Currency, err)
+	}
+	l.Amount = amount
+	return nil
+}
+
+// Conversion is an amount converted at a rate, with both of its legs.
+type Conversion struct {
+	QuoteID   *uuid.UUID `json:"quote_id,omitempty"`
+	Rate      Rate       `json:"rate"`
+	MidRate   Rate       `json:"mid_rate"`
+	SpreadBPS int        `json:"spread_bps"`
+	Source    Leg        `json:"source"`
+	Target    Leg        `json:"target"`
+}
+
+type Options struct {
+	// SpreadBPS is taken off the mid rate of the pairs that don't set their own, in basis points.
+	SpreadBPS int
+	QuoteTTL  time.Duration
+}
+
+// Exchange converts amounts with its rate table, it's safe for concurrent use.
+type Exchange struct {
+	mu       sync.RWMutex
+	rates    map[Pair]TableRate
+	spread   int
+	quoteTTL time.Duration
+	quotes   map[uuid.UUID]*Quote
+}
+
+// New returns an exchange without any rate, every conversion fails with ErrNoRate until rates are set.
+func New(opts Options) *Exchange {
+	if opts.QuoteTTL <= 0 {
+		opts.QuoteTTL = DefaultQuoteTTL
+	}
+	return &Exchange{
+		rates:    make(map[Pair]TableRate),
+		spread:   opts.SpreadBPS,
+		quoteTTL: opts.QuoteTTL,
+		quotes:   make(map[uuid.UUID]*Quote),
+	}
+}
+
+// LoadFile sets the rates from a JSON file holding an array of rates, ex:
+//
+//	[{"from": "USD", "to": "EGP", "rate": "48.95"}, {"from": "USD", "to": "JPY", "rate": "149.5", "spread_bps": 25}]
+func (e *Exchange) LoadFile(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var rates []TableRate
+	if err := json.Unmarshal(content, &rates); err != nil {
+		return fmt.Errorf("cannot decode rates: %w", err)
+	}
+	return e.SetRates(rates)
+}
+
+// SetRates replaces the rate table. The opposite direction of a pair is derived from its rate when it's not set.
+// Every invalid rate is reported, and the table is left as it was.
+func (e *Exchange) SetRates(rates []TableRate) error {
+	table := make(map[Pair]TableRate, len(rates))
+	var errs []error
+	for idx, rate := range rates {
+		from, fromErr := money.ParseCurrency(string(rate.From))
+		to, toErr := money.ParseCurrency(string(rate.To))
+		pair := Pair{From: from, To: to}
+		switch {
+		case fromErr != nil || toErr != nil:
+			errs = append(errs, fmt.Errorf("rate %d: %w", idx+1, errors.Join(fromErr, toErr)))
+			continue
+		case from == to:
+			errs = append(errs, fmt.Errorf("rate %d: %w", idx+1, ErrSameCurrency))
+			continue
+		case rate.Rate.IsZero():
+			errs = append(errs, fmt.Errorf("rate %d: %w: missing", idx+1, ErrInvalidRate))
+			continue
+		case rate.SpreadBPS != nil && (*rate.SpreadBPS < 0 || *rate.SpreadBPS > MaxSpreadBPS):
+			errs = append(errs, fmt.Errorf("rate %d: %w", idx+1, ErrInvalidSpread))
+			continue
+		}
+		if _, duplicate := table[pair]; duplicate {
+			errs = append(errs, fmt.Errorf("rate %d: %w %s to %s", idx+1, ErrDuplicatePair, from, to))
+			continue
+		}
+
+		rate.From, rate.To = from, to
+		table[pair] = rate
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.rates = table
+	return nil
+}
+
+// Rates returns the rate table, ordered by pair.
+func (e *Exchange) Rates() []TableRate {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	rates := make([]TableRate, 0, len(e.rates))
+	for _, rate := range e.rates {
+		rates = append(rates, rate)
+	}
+	sort.Slice(rates, func(i, j int) bool {
+		if rates[i].From != rates[j].From {
+			return rates[i].From < rates[j].From
+		}
+		return rates[i].To < rates[j].To
+	})
+	return rates
+}
+
+// rate returns the mid rate and the spread of the pair, mu must be held.
+func (e *Exchange) rate(pair Pair) (Rate, int, error) {
+	if pair.From == pair.To {
+		return Rate{}, 0, ErrSameCurrency
+	}
+
+	rate, direct := e.rates[pair]
+	mid := rate.Rate
+	if !direct {
+		var reverse bool
+		rate, reverse = e.rates[Pair{From: pair.To, To: pair.From}]
+		if !reverse {
+			return Rate{}, 0, fmt.Errorf("%w: %s to %s", ErrNoRate, pair.From, pair.To)
+		}
+		mid = rate.Rate.Inverse()
+	}
+
+	spread := e.spread
+	if rate.SpreadBPS != nil {
+		spread = *rate.SpreadBPS
+	}
+	return mid, spread, nil
+}
+
+// Quote locks the current rate of the pair for the quote TTL.
+func (e *Exchange) Quote(from, to money.Currency) (*Quote, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	mid, spread, err := e.rate(Pair{From: from, To: to})
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for id, quote := range e.quotes {
+		if now.After(quote.ExpiresAt) {
+			delete(e.quotes, id)
+		}
+	}
+	quote := &Quote{
+		ID:        uuid.New(),
+		From:      from,
+		To:        to,
+		Rate:      mid.WithSpread(spread),
+		MidRate:   mid,
+		SpreadBPS: spread,
+		ExpiresAt: now.Add(e.quoteTTL).UTC(),
+	}
+	e.quotes[quote.ID] = quote
+	return quote, nil
+}
+
+// GetQuote returns a quote that wasn't used nor expired yet.
+func (e *Exchange) GetQuote(id string) (*Quote, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.quote(id)
+}
+
+// quote returns a quote that wasn't used nor expired yet, mu must be held.
+func (e *Exchange) quote(id string) (*Quote, error) {
+	parsed, err := uuid.Parse(id)
+	if err != nil {
+		return nil, ErrQuoteNotFound
+	}
+	quote, found := e.quotes[parsed]
+	if !found {
+		return nil, ErrQuoteNotFound
+	}
+	if time.Now().After(quote.ExpiresAt) {
+		return nil, ErrQuoteExpired
+	}
+	return quote, nil
+}
+
+// Convert converts the amount at the rate of the quote if its id is set, or at the current rate.
+// A quote is reserved by the conversion until the transfer is settled with Consume or Release, converting
+// with it meanwhile or after it's consumed fails with ErrQuoteNotFound. A failed conversion leaves it as it was.
+func (e *Exchange) Convert(amount money.Money, from, to money.Currency, quoteID string) (Conversion, error) {
+	if quoteID == "" {
+		e.mu.RLock()
+		mid, spread, err := e.rate(Pair{From: from, To: to})
+		e.mu.RUnlock()
+		if err != nil {
+			return Conversion{}, err
+		}
+		return convert(amount, from, to, mid.WithSpread(spread), mid, spread)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	quote, err := e.quote(quoteID)
+	if err != nil {
+		return Conversion{}, err
+	}
+	if quote.From != from || quote.To != to {
+		return Conversion{}, fmt.Errorf("%w: %s to %s", ErrQuoteMismatch, quote.From, quote.To)
+	}
+	if quote.reserved {
+		return Conversion{}, fmt.Errorf("%w: it's used by another transfer", ErrQuoteNotFound)
+	}
+	conversion, err := quote.Convert(amount)
+	if err != nil {
+		return Conversion{}, err
+	}
+	quote.reserved = true
+	return conversion, nil
+}
+
+// Consume uses up a quote reserved by Convert once the transfer converted with it is committed.
+func (e *Exchange) Consume(quoteID string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if parsed, err := uuid.Parse(quoteID); err == nil {
+		delete(e.quotes, parsed)
+	}
+}
+
+// Release makes a quote reserved by Convert usable again when the transfer converted with it failed.
+func (e *Exchange) Release(quoteID string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if parsed, err := uuid.Parse(quoteID); err == nil {
+		if quote, found := e.quotes[parsed]; found {
+			quote.reserved = false
+		}
+	}
+}
+
+// Convert previews the conversion of the amount at the quoted rate, it doesn't use the quote up.
+func (q *Quote) Convert(amount money.Money) (Conversion, error) {
+	conversion, err := convert(amount, q.From, q.To, q.Rate, q.MidRate, q.SpreadBPS)
+	if err != nil {
+		return Conversion{}, err
+	}
+	conversion.QuoteID = &q.ID
+	return conversion, nil
+}
+
+// convert multiplies the amount by the rate, rounded half to even to the minor unit of the target currency.
+func convert(amount money.Money, from, to money.Currency, rate, mid Rate, spread int) (Conversion, error) {
+	converted, err := calculator.PreciseMul(amount, rate.Rat(), to.Scale())
+	if err != nil {
+		return Conversion{}, err
+	}
+	if converted.Sign() <= 0 {
+		return Conversion{}, ErrTooSmall
+	}
+	return Conversion{
+		Rate:      rate,
+		MidRate:   mid,
+		SpreadBPS: spread,
+		Source:    Leg{Currency: from, Amount: amount},
+		Target:    Leg{Currency: to, Amount: converted},
+	}, nil
+}
@@ -0,0 +1,126 @@
+package fx_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/0xSherlokMo/banking-system-challenge/fx"
+	"github.com/0xSherlokMo/banking-system-challenge/money"
+)
+
+func TestParseRate(t *testing.T) {
+	for input, expected := range map[string]string{
+		"48.95":        "48.95",
+		"1.50000":      "1.5",
+		"0.0000000001": "0.0000000001",
+		"149":          "149",
+	} {
+		rate, err := fx.ParseRate(input)
+		if err != nil || rate.String() != expected {
+			t.Errorf("parse %q: expected %s but got %s %v", input, expected, rate, err)
+		}
+	}
+	for _, input := range []string{"", "0", "-1.5", "1e3", "1.", "0.00000000001"} {
+		if _, err := fx.ParseRate(input); !errors.Is(err, fx.ErrInvalidRate) {
+			t.Errorf("parse %q: expected an invalid rate but got %v", input, err)
+		}
+	}
+
+	rate, _ := fx.ParseRate("48.95")
+	if inverse := rate.Inverse().String(); inverse != "0.0204290092" {
+		t.Errorf("expected the inverse to be rounded to the rate scale but got %s", inverse)
+	}
+	if spread := rate.WithSpread(25).String(); spread != "48.827625" {
+		t.Errorf("expected 0.25%% to be taken off but got %s", spread)
+	}
+}
+
+func TestConvert(t *testing.T) {
+	exchange := fx.New(fx.Options{SpreadBPS: 100, QuoteTTL: 50 * time.Millisecond})
+	path := filepath.Join(t.TempDir(), "rates.json")
+	os.WriteFile(path, []byte(`[{"from": "USD", "to": "EUR", "rate": "0.5", "spread_bps": 0}, {"from": "usd", "to": "JPY", "rate": 150}]`), 0o644)
+	if err := exchange.LoadFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		amount   string
+		from, to money.Currency
+		expected string
+		err      error
+	}{
+		// 0.025 and 0.035 are both halves, they're rounded to the even cent.
+		{amount: "0.05", from: "USD", to: "EUR", expected: "0.02"},
+		{amount: "0.07", from: "USD", to: "EUR", expected: "0.04"},
+		{amount: "1", from: "EUR", to: "USD", expected: "2.00"},
+		// the table spread is used when the pair has none.
+		{amount: "10", from: "USD", to: "JPY", expected: "1485"},
+		{amount: "0.01", from: "USD", to: "EUR", err: fx.ErrTooSmall},
+		{amount: "1", from: "USD", to: "EGP", err: fx.ErrNoRate},
+	}
+	for _, c := range cases {
+		amount, _ := money.Parse(c.amount, c.from.Scale())
+		conversion, err := exchange.Convert(amount, c.from, c.to, "")
+		if c.err != nil {
+			if !errors.Is(err, c.err) {
+				t.Errorf("%s %s to %s: expected %v but got %v", c.amount, c.from, c.to, c.err, err)
+			}
+			continue
+		}
+		if err != nil || conversion.Target.Amount.String() != c.expected {
+			t.Errorf("%s %s to %s: expected %s but got %s %v", c.amount, c.from, c.to, c.expected, conversion.Target.Amount, err)
+		}
+	}
+
+	quote, err := exchange.Quote("USD", "JPY")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exchange.SetRates([]fx.TableRate{{From: "USD", To: "JPY", Rate: mustRate(t, "100")}})
+	if _, err := exchange.Convert(money.New(1000, 0), "JPY", "USD", quote.ID.String()); !errors.Is(err, fx.ErrQuoteMismatch) {
+		t.Errorf("expected the quote to be bound to its pair but got %v", err)
+	}
+	conversion, err := exchange.Convert(money.New(1000, 2), "USD", "JPY", quote.ID.String())
+	if err != nil || conversion.Target.Amount.String() != "1485" {
+		t.Errorf("expected the quote to keep its rate but got %s %v", conversion.Target.Amount, err)
+	}
+	if _, err := exchange.Convert(money.New(1000, 2), "USD", "JPY", quote.ID.String()); !errors.Is(err, fx.ErrQuoteNotFound) {
+		t.Errorf("expected the quote to be reserved but got %v", err)
+	}
+	// a released quote can be used again, until it's consumed.
+	exchange.Release(quote.ID.String())
+	if _, err := exchange.Convert(money.New(1000, 2), "USD", "JPY", quote.ID.String()); err != nil {
+		t.Errorf("expected the released quote to be usable but got %v", err)
+	}
+	exchange.Consume(quote.ID.String())
+	exchange.Release(quote.ID.String())
+	if _, err := exchange.Convert(money.New(1000, 2), "USD", "JPY", quote.ID.String()); !errors.Is(err, fx.ErrQuoteNotFound) {
+		t.Errorf("expected the quote to be used up but got %v", err)
+	}
+
+	expiring, _ := exchange.Quote("USD", "JPY")
+	time.Sleep(60 * time.Millisecond)
+	if _, err := exchange.Convert(money.New(1000, 2), "USD", "JPY", expiring.ID.String()); !errors.Is(err, fx.ErrQuoteExpired) {
+		t.Errorf("expected the quote to expire but got %v", err)
+	}
+
+	err = exchange.SetRates([]fx.TableRate{
+		{From: "USD", To: "XYZ", Rate: mustRate(t, "1")},
+		{From: "USD", To: "EUR"},
+	})
+	if err == nil || len(exchange.Rates()) != 1 {
+		t.Errorf("expected the invalid rates to be rejected and the table kept but got %v %v", err, exchange.Rates())
+	}
+}
+
+func mustRate(t *testing.T, s string) fx.Rate {
+	t.Helper()
+	rate, err := fx.ParseRate(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rate
+}
@@ -0,0 +1,101 @@
+// Package fx converts amounts between currencies. The exchange holds a table of mid-market rates, loaded from a
+// local file or set through the admin API, applies a spread on top of them and hands out quotes that lock
+// a rate for a while, so a client knows the rate its transfer will get.
+package fx
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/0xSherlokMo/banking-system-challenge/calculator"
+)
+
+// RateScale is the number of decimal places rates are kept at, derived rates are rounded to it.
+const RateScale uint8 = 10
+
+var ErrInvalidRate = errors.New("invalid rate")
+
+// Rate is an exact decimal exchange rate: how many units of the target currency a unit of the source currency buys.
+type Rate struct {
+	value *big.Rat
+}
+
+// ParseRate parses a positive plain decimal, ex: "48.9512", with at most RateScale decimal places.
+func ParseRate(s string) (Rate, error) {
+	s = strings.TrimSpace(s)
+	whole, fraction, hasFraction := strings.Cut(s, ".")
+	if whole == "" || !isDigits(whole) || !isDigits(fraction) || (hasFraction && fraction == "") {
+		return Rate{}, fmt.Errorf("%w %q: should be a plain decimal", ErrInvalidRate, s)
+	}
+	if len(strings.TrimRight(fraction, "0")) > int(RateScale) {
+		return Rate{}, fmt.Errorf("%w %q: should have at most %d decimal places", ErrInvalidRate, s, RateScale)
+	}
+
+	value, ok := new(big.Rat).SetString(s)
+	if !ok || value.Sign() <= 0 {
+		return Rate{}, fmt.Errorf("%w %q: should be positive", ErrInvalidRate, s)
+	}
+	return Rate{value: value}, nil
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Rat returns a copy of the exact value of the rate.
+func (r Rate) Rat() *big.Rat {
+	if r.value == nil {
+		return new(big.Rat)
+	}
+	return new(big.Rat).Set(r.value)
+}
+
+func (r Rate) IsZero() bool {
+	return r.value == nil || r.value.Sign() == 0
+}
+
+// Inverse returns the rate of the opposite direction, rounded to RateScale.
+func (r Rate) Inverse() Rate {
+	return Rate{value: calculator.Round(new(big.Rat).Inv(r.Rat()), RateScale)}
+}
+
+// WithSpread returns the rate the customer gets once the spread, in basis points, is taken off the mid rate.
+func (r Rate) WithSpread(spreadBPS int) Rate {
+	kept := big.NewRat(int64(basisPoints-spreadBPS), basisPoints)
+	return Rate{value: calculator.Round(kept.Mul(kept, r.Rat()), RateScale)}
+}
+
+// String formats the rate without trailing zeros, ex: "48.9512".
+func (r Rate) String() string {
+	formatted := r.Rat().FloatString(int(RateScale))
+	formatted = strings.TrimRight(formatted, "0")
+	return strings.TrimSuffix(formatted, ".")
+}
+
+// MarshalJSON encodes the rate as a JSON string, so it's never read back through a float.
+func (r Rate) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(r.String())), nil
+}
+
+// UnmarshalJSON accepts both JSON strings ("48.95") and JSON numbers (48.95), parsed from their decimal text.
+func (r *Rate) UnmarshalJSON(data []byte) error {
+	text := string(bytes.TrimSpace(data))
+	if unquoted, err := strconv.Unquote(text); err == nil {
+		text = unquoted
+	}
+	parsed, err := ParseRate(text)
+	if err != nil {
+		return err
+	}
+	*r = parsed
+	return nil
+}
@@ -0,0 +1,144 @@
+// Package idempotency remembers the first response sent for an idempotency key,
+// so retried requests get the same answer instead of being applied twice.
+package idempotency
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultRetention is how long a key is remembered when no retention window is configured.
+	DefaultRetention = 24 * time.Hour
+)
+
+var (
+	// ErrKeyReused is returned when a key is sent again with a different payload.
+	ErrKeyReused = errors.New("idempotency_key_reused")
+
+	// ErrRequestInProgress is returned when the first request holding a key has not finished yet.
+	ErrRequestInProgress = errors.New("request_in_progress")
+)
+
+// Response is the stored outcome of the first request sent with a key, its headers are replayed with it.
+type Response struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+type record struct {
+	fingerprint string
+	response    *Response
+	expiresAt   time.Time
+}
+
+type Store struct {
+	mu        sync.Mutex
+	records   map[string]*record
+	retention time.Duration
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewStore(retention time.Duration) *Store {
+	if retention <= 0 {
+		retention = DefaultRetention
+	}
+	return &Store{
+		records:   make(map[string]*record),
+		retention: retention,
+		now:       time.Now,
+	}
+}
+
+func (s *Store) Retention() time.Duration {
+	return s.retention
+}
+
+// Begin claims the key for a request with the given payload fingerprint.
+// It returns the stored response if the key was already completed with the same payload,
+// or nil if the caller now owns the key and should process the request.
+// Keys are scoped by client, so two clients sending the same key never see each other's responses.
+func (s *Store) Begin(client string, key string, fingerprint string) (*Response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+
+	id := client + "/" + key
+	existing, exists := s.records[id]
+	if exists && now.After(existing.expiresAt) {
+		delete(s.records, id)
+		exists = false
+	}
+
+	if !exists {
+		s.records[id] = &record{
+			fingerprint: fingerprint,
+			expiresAt:   now.Add(s.retention),
+		}
+		return nil, nil
+	}
+
+	if existing.fingerprint != fingerprint {
+		return nil, ErrKeyReused
+	}
+
+	if existing.response == nil {
+		return nil, ErrRequestInProgress
+	}
+
+	return existing.response, nil
+}
+
+// Complete stores the response of a request that claimed the key with Begin.
+// Responses that are worth retrying (server errors, locked rows, rate limits) release the key instead.
+func (s *Store) Complete(client string, key string, response Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := client + "/" + key
+	existing, exists := s.records[id]
+	if !exists {
+		return
+	}
+
+	if retryable(response.Status) {
+		delete(s.records, id)
+		return
+	}
+
+	existing.response = &response
+	existing.expiresAt = s.now().Add(s.retention)
+}
+
+// Release forgets a key claimed with Begin without storing a response.
+func (s *Store) Release(client string, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, client+"/"+key)
+}
+
+// sweep drops expired keys, at most once per retention window.
+func (s *Store) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < s.retention {
+		return
+	}
+	s.lastSweep = now
+
+	for id, record := range s.records {
+		if now.After(record.expiresAt) {
+			delete(s.records, id)
+		}
+	}
+}
+
+func retryable(status int) bool {
+	return status >= http.StatusInternalServerError ||
+		status == http.StatusLocked ||
+		status == http.StatusTooManyRequests
+}
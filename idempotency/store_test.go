@@ -0,0 +1,66 @@
+package idempotency_test
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/0xSherlokMo/banking-system-challenge/idempotency"
+)
+
+func TestStoreReplaysFirstResponse(t *testing.T) {
+	store := idempotency.NewStore(time.Hour)
+
+	response, err := store.Begin("client", "key", "payload")
+	if response != nil || err != nil {
+		t.Fatalf("expected first request to claim the key but got %v %v", response, err)
+	}
+
+	_, err = store.Begin("client", "key", "payload")
+	if !errors.Is(err, idempotency.ErrRequestInProgress) {
+		t.Errorf("expected ErrRequestInProgress but got %v", err)
+	}
+
+	store.Complete("client", "key", idempotency.Response{Status: http.StatusOK, Body: []byte("done")})
+
+	response, err = store.Begin("client", "key", "payload")
+	if err != nil || response == nil || string(response.Body) != "done" {
+		t.Errorf("expected stored response but got %v %v", response, err)
+	}
+
+	_, err = store.Begin("client", "key", "another-payload")
+	if !errors.Is(err, idempotency.ErrKeyReused) {
+		t.Errorf("expected ErrKeyReused but got %v", err)
+	}
+
+	response, err = store.Begin("another-client", "key", "another-payload")
+	if response != nil || err != nil {
+		t.Errorf("expected keys to be scoped by client but got %v %v", response, err)
+	}
+}
+
+func TestStoreReleasesRetryableResponses(t *testing.T) {
+	store := idempotency.NewStore(time.Hour)
+
+	store.Begin("client", "key", "payload")
+	store.Complete("client", "key", idempotency.Response{Status: http.StatusLocked})
+
+	response, err := store.Begin("client", "key", "payload")
+	if response != nil || err != nil {
+		t.Errorf("expected locked response not to be stored but got %v %v", response, err)
+	}
+}
+
+func TestStoreExpiresKeys(t *testing.T) {
+	store := idempotency.NewStore(10 * time.Millisecond)
+
+	store.Begin("client", "key", "payload")
+	store.Complete("client", "key", idempotency.Response{Status: http.StatusOK})
+	time.Sleep(20 * time.Millisecond)
+
+	response, err := store.Begin("client", "key", "another-payload")
+	if response != nil || err != nil {
+		t.Errorf("expected expired key to be claimable again but got %v %v", response, err)
+	}
+}
@@ -0,0 +1,241 @@
+// Description: Ledger package models and errors.
+
+package ledger
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/0xSherlokMo/banking-system-challenge/calculator"
+	"github.com/0xSherlokMo/banking-system-challenge/money"
+	"github.com/google/uuid"
+)
+
+const (
+	EntryIdPrefix = "entry-"
+
+	// OpeningBalanceAccount is the system account that funds the opening balance of seeded accounts.
+	OpeningBalanceAccount = "system-opening-balance"
+
+	// ExternalFundsAccount is the system account on the other side of the deposits and withdrawals,
+	// its balance is the money that left the bank minus the money that came in.
+	ExternalFundsAccount = "system-external-funds"
+
+	// FXAccount is the system account on the other side of both legs of a conversion, one per currency.
+	// Its balances are the bank's position in every currency.
+	FXAccount = "system-fx"
+)
+
+var (
+	ErrUnbalancedEntry = errors.New("entry debits and credits are not balanced")
+	ErrInvalidPosting  = errors.New("invalid posting")
+	ErrEmptyEntry      = errors.New("entry needs at least one debit and one credit")
+	ErrMixedCurrencies = errors.New("entry moves money between currencies without an fx leg")
+)
+
+type Direction string
+
+const (
+	// Debit decreases the balance of a customer account.
+	Debit Direction = "debit"
+
+	// Credit increases the balance of a customer account.
+	Credit Direction = "credit"
+)
+
+type Kind string
+
+const (
+	KindOpeningBalance Kind = "opening_balance"
+	KindTransfer       Kind = "transfer"
+	KindReversal       Kind = "reversal"
+	KindDeposit        Kind = "deposit"
+	KindWithdrawal     Kind = "withdrawal"
+	KindConversion     Kind = "conversion"
+)
+
+type Posting struct {
+	Account   string         `json:"account"`
+	Direction Direction      `json:"direction"`
+	Currency  money.Currency `json:"currency"`
+	Amount    money.Money    `json:"amount"`
+}
+
+// Signed returns the posting effect on the account balance, credits are positive and debits are negative.
+func (p Posting) Signed() money.Money {
+	if p.Direction == Debit {
+		return p.Amount.Neg()
+	}
+	return p.Amount
+}
+
+type Entry struct {
+	ID        uuid.UUID `json:"id"`
+	Sequence  uint64    `json:"sequence"`
+	Kind      Kind      `json:"kind"`
+	Postings  []Posting `json:"postings"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func NewEntry(kind Kind, postings ...Posting) *Entry {
+	return &Entry{
+		ID:        uuid.New(),
+		Kind:      kind,
+		Postings:  postings,
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+// NewTransferEntry debits the sender and credits the receiver with the same amount.
+func NewTransferEntry(sender string, receiver string, currency money.Currency, amount money.Money) *Entry {
+	return NewEntry(
+		KindTransfer,
+		Posting{Account: sender, Direction: Debit, Currency: currency, Amount: amount},
+		Posting{Account: receiver, Direction: Credit, Currency: currency, Amount: amount},
+	)
+}
+
+// NewConversionEntry debits the sender with the source amount and credits the receiver with the converted amount,
+// each leg is balanced by the FXAccount of its currency.
+func NewConversionEntry(sender string, source money.Currency, amount money.Money, receiver string, target money.Currency, converted money.Money) *Entry {
+	return NewEntry(
+		KindConversion,
+		Posting{Account: sender, Direction: Debit, Currency: source, Amount: amount},
+		Posting{Account: SystemAccount(FXAccount, source), Direction: Credit, Currency: source, Amount: amount},
+		Posting{Account: SystemAccount(FXAccount, target), Direction: Debit, Currency: target, Amount: converted},
+		Posting{Account: receiver, Direction: Credit, Currency: target, Amount: converted},
+	)
+}
+
+// SystemAccount returns the system account holding the given currency, every currency has its own so
+// the balance of a system account never mixes amounts in different currencies.
+// The default currency keeps the bare name, the one used before currencies were added. ex: system-external-funds-EGP
+func SystemAccount(name string, currency money.Currency) string {
+	if currency == "" || currency == money.DefaultCurrency {
+		return name
+	}
+	return fmt.Sprintf("%s-%s", name, currency)
+}
+
+// NewDepositEntry credits an account with money coming from outside the bank, funded by ExternalFundsAccount.
+func NewDepositEntry(account string, currency money.Currency, amount money.Money) *Entry {
+	return NewEntry(
+		KindDeposit,
+		Posting{Account: SystemAccount(ExternalFundsAccount, currency), Direction: Debit, Currency: currency, Amount: amount},
+		Posting{Account: account, Direction: Credit, Currency: currency, Amount: amount},
+	)
+}
+
+// NewWithdrawalEntry debits an account with money leaving the bank, credited to ExternalFundsAccount.
+func NewWithdrawalEntry(account string, currency money.Currency, amount money.Money) *Entry {
+	return NewEntry(
+		KindWithdrawal,
+		Posting{Account: account, Direction: Debit, Currency: currency, Amount: amount},
+		Posting{Account: SystemAccount(ExternalFundsAccount, currency), Direction: Credit, Currency: currency, Amount: amount},
+	)
+}
+
+// NewOpeningEntry credits an account with its opening balance, funded by OpeningBalanceAccount.
+// A negative opening balance is recorded as a debit instead.
+func NewOpeningEntry(account string, currency money.Currency, balance money.Money) *Entry {
+	funding := SystemAccount(OpeningBalanceAccount, currency)
+	if balance.Sign() < 0 {
+		return NewEntry(
+			KindOpeningBalance,
+			Posting{Account: account, Direction: Debit, Currency: currency, Amount: balance.Neg()},
+			Posting{Account: funding, Direction: Credit, Currency: currency, Amount: balance.Neg()},
+		)
+	}
+
+	return NewEntry(
+		KindOpeningBalance,
+		Posting{Account: funding, Direction: Debit, Currency: currency, Amount: balance},
+		Posting{Account: account, Direction: Credit, Currency: currency, Amount: balance},
+	)
+}
+
+// NewReversalEntry cancels the given entry by posting it again with the directions flipped.
+func NewReversalEntry(entry *Entry) *Entry {
+	postings := make([]Posting, 0, len(entry.Postings))
+	for _, posting := range entry.Postings {
+		reversed := posting
+		reversed.Direction = Credit
+		if posting.Direction == Credit {
+			reversed.Direction = Debit
+		}
+		postings = append(postings, reversed)
+	}
+	return NewEntry(KindReversal, postings...)
+}
+
+func (e *Entry) GetID() string {
+	return fmt.Sprintf("%s-%s", EntryIdPrefix, e.ID.String())
+}
+
+// Validate checks that the entry has positive postings and that its debits equal its credits in every currency.
+// An entry in more than one currency must go through the FXAccount of each of them, like a conversion.
+func (e *Entry) Validate() error {
+	var debits, credits int
+	totals := make(map[money.Currency]money.Money)
+	exchanged := make(map[money.Currency]bool)
+	for _, posting := range e.Postings {
+		if posting.Account == "" || posting.Currency == "" || posting.Amount.Sign() < 0 {
+			return ErrInvalidPosting
+		}
+		if posting.Account == SystemAccount(FXAccount, posting.Currency) {
+			exchanged[posting.Currency] = true
+		}
+
+		switch posting.Direction {
+		case Debit:
+			debits++
+		case Credit:
+			credits++
+		default:
+			return ErrInvalidPosting
+		}
+
+		total, err := calculator.PreciseAdd(totals[posting.Currency], posting.Signed())
+		if err != nil {
+			return err
+		}
+		totals[posting.Currency] = total
+	}
+
+	if debits == 0 || credits == 0 {
+		return ErrEmptyEntry
+	}
+
+	// the currencies are checked in the order of the postings, so the same entry always fails the same way.
+	for _, posting := range e.Postings {
+		if !totals[posting.Currency].IsZero() {
+			return fmt.Errorf("%w in %s", ErrUnbalancedEntry, posting.Currency)
+		}
+		if len(totals) > 1 && !exchanged[posting.Currency] {
+			return fmt.Errorf("%w in %s", ErrMixedCurrencies, posting.Currency)
+		}
+	}
+
+	return nil
+}
+
+// Touches reports whether the entry has a posting against the given account.
+func (e *Entry) Touches(account string) bool {
+	for _, posting := range e.Postings {
+		if posting.Account == account {
+			return true
+		}
+	}
+	return false
+}
+
+// touchedBefore reports whether one of the postings before the given one is on the account.
+func (e *Entry) touchedBefore(account string, posting int) bool {
+	for _, previous := range e.Postings[:posting] {
+		if previous.Account == account {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,147 @@
+// Package ledger keeps a double-entry journal of every balance movement.
+// Each entry holds balanced debit and credit postings, so account balances can be
+// derived from the postings alone and compared against the cached account balances.
+package ledger
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/0xSherlokMo/banking-system-challenge/calculator"
+	"github.com/0xSherlokMo/banking-system-challenge/memorydb"
+	"github.com/0xSherlokMo/banking-system-challenge/money"
+)
+
+// Store is the subset of the database the ledger needs to persist its entries.
+type Store interface {
+	Setnx(key string, record *Entry) error
+	GetM(terms []string, opts memorydb.Opts) []*Entry
+	Keys() []memorydb.Key
+}
+
+type Ledger struct {
+	store    Store
+	mu       sync.Mutex
+	sequence uint64
+	// accounts indexes the entries touching each account in sequence order, so the history and balance
+	// of an account don't scan the whole journal.
+	accounts map[string][]*Entry
+}
+
+// Mismatch describes an account whose cached balance differs from the balance derived from its postings.
+type Mismatch struct {
+	Account string      `json:"account"`
+	Cached  money.Money `json:"cached"`
+	Derived money.Money `json:"derived"`
+}
+
+func New(store Store) *Ledger {
+	l := &Ledger{store: store, accounts: make(map[string][]*Entry)}
+	for _, entry := range l.all() {
+		if entry.Sequence > l.sequence {
+			l.sequence = entry.Sequence
+		}
+		l.index(entry)
+	}
+	return l
+}
+
+// Record validates the entry, assigns its sequence number and appends it to the journal.
+func (l *Ledger) Record(entry *Entry) error {
+	if err := entry.Validate(); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entry.Sequence = l.sequence + 1
+	if err := l.store.Setnx(entry.GetID(), entry); err != nil {
+		return err
+	}
+	l.sequence = entry.Sequence
+	l.index(entry)
+	return nil
+}
+
+// Entries returns the journal entries touching the given account, ordered by sequence.
+func (l *Ledger) Entries(account string) []*Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]*Entry{}, l.accounts[account]...)
+}
+
+// Balance derives the balance of an account from its postings.
+func (l *Ledger) Balance(account string) (money.Money, error) {
+	var balance money.Money
+	for _, entry := range l.Entries(account) {
+		for _, posting := range entry.Postings {
+			if posting.Account != account {
+				continue
+			}
+			var err error
+			if balance, err = calculator.PreciseAdd(balance, posting.Signed()); err != nil {
+				return money.Money{}, err
+			}
+		}
+	}
+	return balance, nil
+}
+
+// Balances derives the balances of every account that has at least one posting.
+func (l *Ledger) Balances() (map[string]money.Money, error) {
+	balances := make(map[string]money.Money)
+	for _, entry := range l.all() {
+		for _, posting := range entry.Postings {
+			balance, err := calculator.PreciseAdd(balances[posting.Account], posting.Signed())
+			if err != nil {
+				return nil, err
+			}
+			balances[posting.Account] = balance
+		}
+	}
+	return balances, nil
+}
+
+// Reconcile compares the cached balances against the balances derived from the postings.
+// It returns an empty slice when every account matches.
+func (l *Ledger) Reconcile(cached map[string]money.Money) ([]Mismatch, error) {
+	derived, err := l.Balances()
+	if err != nil {
+		return nil, err
+	}
+
+	mismatches := []Mismatch{}
+	for account, balance := range cached {
+		if balance.Cmp(derived[account]) != 0 {
+			mismatches = append(mismatches, Mismatch{
+				Account: account,
+				Cached:  balance,
+				Derived: derived[account],
+			})
+		}
+	}
+
+	sort.Slice(mismatches, func(i, j int) bool {
+		return mismatches[i].Account < mismatches[j].Account
+	})
+	return mismatches, nil
+}
+
+// index appends the entry to the history of every account it touches, once per account.
+// Entries are recorded in sequence order, so the histories stay sorted.
+func (l *Ledger) index(entry *Entry) {
+	for idx, posting := range entry.Postings {
+		if entry.touchedBefore(posting.Account, idx) {
+			continue
+		}
+		l.accounts[posting.Account] = append(l.accounts[posting.Account], entry)
+	}
+}
+
+func (l *Ledger) all() []*Entry {
+	entries := l.store.GetM(l.store.Keys(), memorydb.Opts{Safe: memorydb.ConcurrentNotSafe})
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Sequence < entries[j].Sequence
+	})
+	return entries
+}
@@ -0,0 +1,121 @@
+package ledger_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/0xSherlokMo/banking-system-challenge/ledger"
+	"github.com/0xSherlokMo/banking-system-challenge/memorydb"
+	"github.com/0xSherlokMo/banking-system-challenge/money"
+)
+
+func TestRecordRejectsUnbalancedEntries(t *testing.T) {
+	journal := ledger.New(memorydb.Default[*ledger.Entry]())
+
+	entry := ledger.NewEntry(
+		ledger.KindTransfer,
+		ledger.Posting{Account: "a", Direction: ledger.Debit, Currency: "USD", Amount: money.MustParse("10")},
+		ledger.Posting{Account: "b", Direction: ledger.Credit, Currency: "USD", Amount: money.MustParse("9.99")},
+	)
+	if err := journal.Record(entry); !errors.Is(err, ledger.ErrUnbalancedEntry) {
+		t.Errorf("expected ErrUnbalancedEntry but got %v", err)
+	}
+
+	entry = ledger.NewEntry(
+		ledger.KindTransfer,
+		ledger.Posting{Account: "a", Direction: ledger.Credit, Currency: "USD", Amount: money.MustParse("10")},
+	)
+	if err := journal.Record(entry); !errors.Is(err, ledger.ErrEmptyEntry) {
+		t.Errorf("expected ErrEmptyEntry but got %v", err)
+	}
+
+	// amounts in different currencies don't balance each other.
+	entry = ledger.NewEntry(
+		ledger.KindConversion,
+		ledger.Posting{Account: "a", Direction: ledger.Debit, Currency: "USD", Amount: money.New(100, 0)},
+		ledger.Posting{Account: "b", Direction: ledger.Credit, Currency: "JPY", Amount: money.New(100, 0)},
+	)
+	if err := journal.Record(entry); !errors.Is(err, ledger.ErrUnbalancedEntry) {
+		t.Errorf("expected a debit in USD not to balance a credit in JPY but got %v", err)
+	}
+
+	entry = ledger.NewEntry(
+		ledger.KindTransfer,
+		ledger.Posting{Account: "a", Direction: ledger.Debit, Currency: "USD", Amount: money.New(100, 0)},
+		ledger.Posting{Account: "b", Direction: ledger.Credit, Currency: "USD", Amount: money.New(100, 0)},
+		ledger.Posting{Account: "c", Direction: ledger.Debit, Currency: "JPY", Amount: money.New(100, 0)},
+		ledger.Posting{Account: "a", Direction: ledger.Credit, Currency: "JPY", Amount: money.New(100, 0)},
+	)
+	if err := journal.Record(entry); !errors.Is(err, ledger.ErrMixedCurrencies) {
+		t.Errorf("expected ErrMixedCurrencies but got %v", err)
+	}
+
+	entry = ledger.NewEntry(
+		ledger.KindTransfer,
+		ledger.Posting{Account: "a", Direction: ledger.Debit, Amount: money.MustParse("10")},
+		ledger.Posting{Account: "b", Direction: ledger.Credit, Amount: money.MustParse("10")},
+	)
+	if err := journal.Record(entry); !errors.Is(err, ledger.ErrInvalidPosting) {
+		t.Errorf("expected a posting without a currency to be rejected but got %v", err)
+	}
+
+	conversion := ledger.NewConversionEntry("a", "USD", money.New(1000, 2), "b", "JPY", money.New(1485, 0))
+	if err := conversion.Validate(); err != nil {
+		t.Errorf("expected a conversion through the fx account to balance but got %v", err)
+	}
+
+	if len(journal.Entries("a")) != 0 {
+		t.Errorf("expected rejected entries not to be recorded")
+	}
+}
+
+func TestBalancesAreDerivedFromPostings(t *testing.T) {
+	store := memorydb.Default[*ledger.Entry]()
+	journal := ledger.New(store)
+
+	entries := []*ledger.Entry{
+		ledger.NewOpeningEntry("a", money.DefaultCurrency, money.MustParse("100")),
+		ledger.NewOpeningEntry("b", money.DefaultCurrency, money.MustParse("0.50")),
+		ledger.NewTransferEntry("a", "b", money.DefaultCurrency, money.MustParse("30.25")),
+		ledger.NewTransferEntry("b", "a", money.DefaultCurrency, money.MustParse("0.75")),
+	}
+	for _, entry := range entries {
+		if err := journal.Record(entry); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+
+	balance, _ := journal.Balance("a")
+	if balance.Cmp(money.MustParse("70.50")) != 0 {
+		t.Errorf("expected a to have 70.50 but got %s", balance)
+	}
+	balance, _ = journal.Balance("b")
+	if balance.Cmp(money.MustParse("30")) != 0 {
+		t.Errorf("expected b to have 30.00 but got %s", balance)
+	}
+	balance, _ = journal.Balance(ledger.OpeningBalanceAccount)
+	if balance.Cmp(money.MustParse("-100.50")) != 0 {
+		t.Errorf("expected opening balance account to have -100.50 but got %s", balance)
+	}
+
+	history := journal.Entries("b")
+	if len(history) != 3 || history[0].Sequence >= history[1].Sequence {
+		t.Errorf("expected 3 ordered entries for b but got %+v", history)
+	}
+	// the account histories are indexed again from the store.
+	reopened := ledger.New(store)
+	if history := reopened.Entries("b"); len(history) != 3 || history[0].Sequence >= history[1].Sequence || history[1].Sequence >= history[2].Sequence {
+		t.Errorf("expected the history of b to be rebuilt in order but got %+v", history)
+	}
+	if balance, _ := reopened.Balance("a"); balance.Cmp(money.MustParse("70.50")) != 0 {
+		t.Errorf("expected a to still have 70.50 but got %s", balance)
+	}
+
+	mismatches, _ := journal.Reconcile(map[string]money.Money{
+		"a": money.MustParse("70.50"),
+		"b": money.MustParse("31"),
+	})
+	if len(mismatches) != 1 || mismatches[0].Account != "b" {
+		t.Errorf("expected a single mismatch for b but got %+v", mismatches)
+	}
+}
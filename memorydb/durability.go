@@ -0,0 +1,192 @@
+package memorydb
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// Open returns a MemoryDB backed by a write-ahead log.
+// The records are loaded from the newest valid snapshot, then the log written after it is replayed.
+// A truncated or corrupt log tail is dropped.
+func Open[T IdentifiedRecord](opts WALOptions) (*MemoryDB[T], RecoveryInfo, error) {
+	snapshots, err := listSnapshots(opts.Path)
+	if err != nil {
+		return nil, RecoveryInfo{}, err
+	}
+
+	m := Default[T]()
+	var base uint64
+	var skipped int
+	for _, snapshot := range snapshots {
+		lsn, records, err := readSnapshot(snapshot.Path)
+		if err == nil {
+			err = m.load(records)
+		}
+		if err != nil {
+			skipped++
+			m = Default[T]()
+			continue
+		}
+		base = lsn
+		break
+	}
+
+	log, info, err := openWAL(opts, base, func(record walRecord) error {
+		if record.op == opBatch {
+			records, err := decodeBatch(record.value)
+			if err != nil {
+				return err
+			}
+			for i := range records {
+				records[i].version = record.lsn
+			}
+			return m.load(records)
+		}
+		return m.load([]snapshotRecord{{key: record.key, value: record.value, version: record.lsn}})
+	})
+	info.SnapshotLSN = base
+	info.SkippedSnapshots = skipped
+	if err != nil {
+		return nil, info, err
+	}
+
+	// commit versions are lsns, so they keep increasing across restarts.
+	m.wal = log
+	m.clock.reset(log.lsn)
+	m.feed.reset(log.lsn)
+	return m, info, nil
+}
+
+// load applies recovered records, it runs before the database is shared so it takes no locks.
+// Only the latest version of a record is kept, no reader can ask for an older one.
+func (m *MemoryDB[T]) load(records []snapshotRecord) error {
+	for _, record := range records {
+		// encoded records are never empty, only deletes have no value.
+		if len(record.value) == 0 {
+			m.shardFor(record.key).remove(record.key, record.version, record.version)
+			continue
+		}
+
+		var value T
+		if err := json.Unmarshal(record.value, &value); err != nil {
+			return err
+		}
+		m.shardFor(record.key).put(record.key, value, record.version, record.version)
+	}
+	return nil
+}
+
+// Snapshot writes a point-in-time dump of every record to disk and compacts the write-ahead log behind it.
+// Writers are only blocked while the records are copied, they're encoded and written to disk afterwards.
+func (m *MemoryDB[T]) Snapshot() (SnapshotInfo, error) {
+	if m.wal == nil {
+		return SnapshotInfo{}, ErrNotDurable
+	}
+	if !m.snapshotting.CompareAndSwap(false, true) {
+		return SnapshotInfo{}, ErrSnapshotInProgress
+	}
+	defer m.snapshotting.Store(false)
+
+	type capturedRecord struct {
+		key Key
+		version[T]
+	}
+
+	m.commitmu.Lock()
+	lsn := m.wal.currentLSN()
+	var captured []capturedRecord
+	for _, shard := range m.shards {
+		shard.mu.RLock()
+		for key, chain := range shard.versions {
+			if chain[len(chain)-1].deleted {
+				continue
+			}
+			captured = append(captured, capturedRecord{key: key, version: chain[len(chain)-1]})
+		}
+		shard.mu.RUnlock()
+	}
+	m.commitmu.Unlock()
+
+	records := make([]snapshotRecord, 0, len(captured))
+	for _, capture := range captured {
+		value, err := json.Marshal(capture.record)
+		if err != nil {
+			return SnapshotInfo{}, err
+		}
+		records = append(records, snapshotRecord{key: capture.key, value: value, version: capture.at})
+	}
+
+	info := SnapshotInfo{Path: snapshotPath(m.wal.path, lsn), LSN: lsn, Records: len(records)}
+	if err := writeSnapshot(info.Path, lsn, records); err != nil {
+		return SnapshotInfo{}, err
+	}
+
+	snapshots, err := listSnapshots(m.wal.path)
+	if err != nil {
+		return info, err
+	}
+	if len(snapshots) > snapshotsKept {
+		for _, old := range snapshots[snapshotsKept:] {
+			os.Remove(old.Path)
+		}
+		snapshots = snapshots[:snapshotsKept]
+	}
+
+	return info, m.wal.compact(snapshots[len(snapshots)-1].LSN)
+}
+
+// Sync flushes the write-ahead log to disk, it's a no-op for in-memory only databases.
+func (m *MemoryDB[T]) Sync() error {
+	if m.wal == nil {
+		return nil
+	}
+	return m.wal.sync()
+}
+
+// Close flushes and closes the write-ahead log, it's a no-op for in-memory only databases.
+func (m *MemoryDB[T]) Close() error {
+	if m.wal == nil {
+		return nil
+	}
+	return m.wal.close()
+}
+
+// log writes the record to the write-ahead log, and returns the version of the commit: the lsn of its frame,
+// or the next version of the clock for in-memory only databases.
+// The version must be published once the write is applied, even if logging failed.
+func (m *MemoryDB[T]) log(op byte, key Key, record T) (uint64, error) {
+	if m.wal == nil {
+		return m.clock.begin(), nil
+	}
+
+	encoded, err := json.Marshal(record)
+	if err != nil {
+		return 0, err
+	}
+	return m.wal.append(op, key, encoded)
+}
+
+// logDelete writes the delete to the write-ahead log, and returns the version of the commit like log.
+func (m *MemoryDB[T]) logDelete(key Key) (uint64, error) {
+	if m.wal == nil {
+		return m.clock.begin(), nil
+	}
+	return m.wal.append(opDelete, key, nil)
+}
+
+// logBatch writes every record in a single frame, and returns the version of the commit like log.
+func (m *MemoryDB[T]) logBatch(keys []Key, records []T) (uint64, error) {
+	if m.wal == nil {
+		return m.clock.begin(), nil
+	}
+
+	batch := make([]snapshotRecord, 0, len(keys))
+	for i, key := range keys {
+		encoded, err := json.Marshal(records[i])
+		if err != nil {
+			return 0, err
+		}
+		batch = append(batch, snapshotRecord{key: key, value: encoded})
+	}
+	return m.wal.append(opBatch, "", encodeBatch(batch))
+}
@@ -0,0 +1,220 @@
+package memorydb
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// DefaultFeedRetention is how many change events are kept for subscribers to resume from.
+	DefaultFeedRetention = 4096
+
+	// DefaultWatchBuffer is how many change events are buffered for a subscriber that isn't reading.
+	DefaultWatchBuffer = 64
+
+	// feedBatch is how many events a subscriber copies out of the feed at once.
+	feedBatch = 256
+)
+
+var (
+	// ErrSubscriberLagged is returned by Subscription.Err when the subscriber fell so far behind
+	// that the events it didn't read yet were dropped. It can resume from the last version it read.
+	ErrSubscriberLagged = errors.New("subscriber_lagged")
+
+	// ErrVersionCompacted is returned by Watch when the events after the version to resume from aren't kept anymore.
+	ErrVersionCompacted = errors.New("version_compacted")
+)
+
+type ChangeKind string
+
+const (
+	ChangeCreate ChangeKind = "create"
+	ChangeUpdate ChangeKind = "update"
+	ChangeDelete ChangeKind = "delete"
+)
+
+// ChangeEvent describes a committed write. Old is empty for creates and New is empty for deletes.
+type ChangeEvent[T IdentifiedRecord] struct {
+	Version uint64
+	Key     Key
+	Kind    ChangeKind
+	Old     T
+	New     T
+}
+
+type WatchOptions struct {
+	// FromVersion resumes the subscription right after the given version, 0 only watches new commits.
+	FromVersion uint64
+
+	// Buffer is how many events are buffered for the subscriber, DefaultWatchBuffer by default.
+	Buffer int
+}
+
+// Subscription delivers the change events of a Watch in commit order.
+type Subscription[T IdentifiedRecord] interface {
+	// Events is closed when the subscription ends, Err tells why.
+	Events() <-chan ChangeEvent[T]
+
+	// Err returns nil if the subscription was closed or its context is done, ErrSubscriberLagged if it fell behind.
+	Err() error
+
+	Close()
+}
+
+// feed keeps the latest change events in commit order.
+// Writers hand their events to the clock with their commit, and only the writer making commits visible
+// appends them here. Every subscriber reads it at its own pace from its own goroutine,
+// so a slow subscriber never blocks a write: it's dropped once the events it didn't read are evicted.
+type feed[T IdentifiedRecord] struct {
+	mu        sync.Mutex
+	events    []ChangeEvent[T]
+	base      uint64 // sequence of events[0]
+	floor     uint64 // every event after this version is still kept
+	retention int
+	notify    chan struct{}
+}
+
+func newFeed[T IdentifiedRecord]() *feed[T] {
+	return &feed[T]{
+		retention: DefaultFeedRetention,
+		notify:    make(chan struct{}),
+	}
+}
+
+// release appends the events of a commit that just became visible, commits are released in order.
+func (f *feed[T]) release(at uint64, events []ChangeEvent[T]) {
+	if len(events) == 0 {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.events = append(f.events, events...)
+	// the feed is trimmed in bulk, so appending stays cheap.
+	if len(f.events) > 2*f.retention {
+		evicted := len(f.events) - f.retention
+		f.floor = f.events[evicted-1].Version
+		f.base += uint64(evicted)
+		f.events = append(f.events[:0:0], f.events[evicted:]...)
+	}
+
+	close(f.notify)
+	f.notify = make(chan struct{})
+}
+
+// reset drops every event, the feed starts over after the given version.
+func (f *feed[T]) reset(at uint64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.base += uint64(len(f.events))
+	f.events = nil
+	f.floor = at
+}
+
+// SetFeedRetention sets how many change events are kept for subscribers to resume from.
+func (m *MemoryDB[T]) SetFeedRetention(events int) {
+	m.feed.mu.Lock()
+	defer m.feed.mu.Unlock()
+	m.feed.retention = events
+}
+
+// Watch subscribes to the changes of the keys starting with the given prefix, in commit order.
+// The subscription ends when ctx is done or it's closed. A subscriber that doesn't keep up is dropped
+// with ErrSubscriberLagged instead of slowing writers down, it can then resume from the last version it read.
+func (m *MemoryDB[T]) Watch(ctx context.Context, prefix Key, opts WatchOptions) (Subscription[T], error) {
+	if opts.Buffer <= 0 {
+		opts.Buffer = DefaultWatchBuffer
+	}
+
+	m.feed.mu.Lock()
+	next := m.feed.base + uint64(len(m.feed.events))
+	if opts.FromVersion != 0 {
+		if opts.FromVersion < m.feed.floor {
+			m.feed.mu.Unlock()
+			return nil, ErrVersionCompacted
+		}
+		skipped := sort.Search(len(m.feed.events), func(i int) bool {
+			return m.feed.events[i].Version > opts.FromVersion
+		})
+		next = m.feed.base + uint64(skipped)
+	}
+	m.feed.mu.Unlock()
+
+	watchCtx, cancel := context.WithCancel(ctx)
+	subscription := &watcher[T]{
+		feed:   m.feed,
+		prefix: prefix,
+		next:   next,
+		events: make(chan ChangeEvent[T], opts.Buffer),
+		ctx:    watchCtx,
+		cancel: cancel,
+	}
+	go subscription.run()
+	return subscription, nil
+}
+
+type watcher[T IdentifiedRecord] struct {
+	feed   *feed[T]
+	prefix Key
+	next   uint64
+	events chan ChangeEvent[T]
+	err    error
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func (w *watcher[T]) Events() <-chan ChangeEvent[T] {
+	return w.events
+}
+
+func (w *watcher[T]) Err() error {
+	// only read once the events channel is closed, after run wrote it.
+	return w.err
+}
+
+func (w *watcher[T]) Close() {
+	w.cancel()
+}
+
+func (w *watcher[T]) run() {
+	defer close(w.events)
+	defer w.cancel()
+
+	for {
+		w.feed.mu.Lock()
+		if w.next < w.feed.base {
+			w.feed.mu.Unlock()
+			w.err = ErrSubscriberLagged
+			return
+		}
+		start := int(w.next - w.feed.base)
+		end := min(len(w.feed.events), start+feedBatch)
+		batch := append([]ChangeEvent[T](nil), w.feed.events[start:end]...)
+		notify := w.feed.notify
+		w.feed.mu.Unlock()
+
+		if len(batch) == 0 {
+			select {
+			case <-notify:
+				continue
+			case <-w.ctx.Done():
+				return
+			}
+		}
+
+		for _, event := range batch {
+			w.next++
+			if !strings.HasPrefix(event.Key, w.prefix) {
+				continue
+			}
+			select {
+			case w.events <- event:
+			case <-w.ctx.Done():
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package memorydb
+
+import (
+	"context"
+	"errors"
+)
+
+// latch is a row lock that can be waited on with a context.
+// Waiters are queued on a channel send, the runtime wakes them up in FIFO order and hands
+// the latch over directly on release, so a late TryLock can't jump ahead of a waiter.
+type latch chan struct{}
+
+func newLatch() latch {
+	return make(latch, 1)
+}
+
+func (l latch) TryLock() bool {
+	select {
+	case l <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// LockContext waits for the latch until it's acquired or the context is done.
+func (l latch) LockContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return errors.Join(ErrRowLocked, err)
+	}
+
+	select {
+	case l <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return errors.Join(ErrRowLocked, ctx.Err())
+	}
+}
+
+func (l latch) Lock() {
+	l <- struct{}{}
+}
+
+// Unlock releases the latch, it returns false if the latch was not locked.
+func (l latch) Unlock() bool {
+	select {
+	case <-l:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,120 @@
+package memorydb
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultLeaseTTL is how long a row lock is held before it's released automatically.
+	DefaultLeaseTTL = 30 * time.Second
+)
+
+var (
+	// ErrNotOwner is returned when a row is unlocked or written with a lease that doesn't hold it.
+	ErrNotOwner = errors.New("not_lease_owner")
+
+	// ErrLeaseExpired is returned when a row is unlocked or written with a lease that already expired.
+	ErrLeaseExpired = errors.New("lease_expired")
+)
+
+// Token identifies the lease returned by Lock, only its holder can write or unlock the row.
+type Token uint64
+
+var lastToken atomic.Uint64
+
+func newToken() Token {
+	return Token(lastToken.Add(1))
+}
+
+// header holds the row latch and the lease of its current holder.
+// Leases expire on their own, so a crashed holder can't keep a row locked forever.
+type header struct {
+	latch latch
+
+	mu      sync.Mutex
+	lease   Token
+	expired Token
+	timer   *time.Timer
+
+	// retired is set when the record is deleted, whoever gets the latch afterwards hands it over and gives up.
+	retired atomic.Bool
+}
+
+func newHeader() *header {
+	return &header{latch: newLatch()}
+}
+
+// grant hands a new lease to the caller that just acquired the latch.
+func (h *header) grant(ttl time.Duration) Token {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	token := newToken()
+	h.lease = token
+	h.timer = time.AfterFunc(ttl, func() {
+		h.expire(token)
+	})
+	return token
+}
+
+func (h *header) expire(token Token) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.lease != token {
+		return
+	}
+
+	h.lease = 0
+	h.expired = token
+	h.latch.Unlock()
+}
+
+// check verifies that the token holds the row. h.mu must be held.
+func (h *header) check(token Token) error {
+	if token != 0 && h.lease == token {
+		return nil
+	}
+	if token != 0 && h.expired == token {
+		return ErrLeaseExpired
+	}
+	if h.lease == 0 && token != 0 {
+		return ErrUnlockedBefore
+	}
+	return ErrNotOwner
+}
+
+// release ends the lease and unlocks the row.
+func (h *header) release(token Token) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.check(token); err != nil {
+		return err
+	}
+
+	h.end()
+	return nil
+}
+
+// end ends the current lease and unlocks the row. h.mu must be held.
+func (h *header) end() {
+	h.timer.Stop()
+	h.lease = 0
+	h.latch.Unlock()
+}
+
+// retire marks the header of a deleted record, the shard lock must be held.
+func (h *header) retire() {
+	h.retired.Store(true)
+}
+
+// acquired is called once the latch is acquired, it returns false and hands the latch over if the record was deleted meanwhile.
+func (h *header) acquired() bool {
+	if h.retired.Load() {
+		h.latch.Unlock()
+		return false
+	}
+	return true
+}
@@ -1,12 +1,16 @@
 // Package memorydb provides an in-memory database implementation that can be used to store and retrieve records.
 // It provides methods to lock and unlock records, set and get records, and retrieve all keys and records.
+// Writes can optionally be made durable with a write-ahead log that is replayed on startup.
 // The MemoryDB type is generic and can store any type that implements the IdentifiedRecord interface.
 // The package also defines several errors that can be returned by the methods.
 package memorydb
 
 import (
+	"context"
 	"errors"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type Key = string
@@ -28,17 +32,19 @@ var (
 
 	// ErrUnlockedBefore is returned when a record is unlocked before trying to unlock it.
 	ErrUnlockedBefore = errors.New("unlocked_before")
-)
 
-type header struct {
-	latch sync.Mutex
-}
+	// ErrVersionMismatch is returned by CompareAndSet when the record was written since the expected version.
+	ErrVersionMismatch = errors.New("version_mismatch")
+)
 
 type Opts struct {
 	/*
 		Shouldn't be enabled unless locked the key manually, or you want high available endpoint.
 	*/
 	Safe bool
+
+	// Lease is the token returned by Lock, it's required to write a row that is locked.
+	Lease Token
 }
 
 const (
@@ -49,80 +55,254 @@ const (
 	ConcurrentNotSafe = false
 )
 
+// MemoryDB stores records in lock-striped shards, every map access goes through its shard lock.
+// Stored records are shared with readers, so they must not be mutated in place: Set a modified copy instead.
 type MemoryDB[T IdentifiedRecord] struct {
-	records map[Key]T
-	header  map[Key]*header
-	setnxmu sync.Mutex
+	shards []*shard[T]
+	wal    *wal
+	// commitmu makes appending to the log and applying the write atomic for snapshots.
+	commitmu     sync.RWMutex
+	snapshotting atomic.Bool
+	leaseTTL     atomic.Int64
+	clock        *clock[T]
+	feed         *feed[T]
 }
 
 func Default[T IdentifiedRecord]() *MemoryDB[T] {
-	return &MemoryDB[T]{
-		records: make(map[Key]T),
-		header:  make(map[Key]*header),
+	m := &MemoryDB[T]{
+		shards: newShards[T](),
+		clock:  newClock[T](),
+		feed:   newFeed[T](),
 	}
+	m.clock.visibleHook = m.feed.release
+	m.SetLeaseTTL(DefaultLeaseTTL)
+	return m
+}
+
+// SetLeaseTTL sets how long the leases returned by Lock and LockContext are valid.
+func (m *MemoryDB[T]) SetLeaseTTL(ttl time.Duration) {
+	m.leaseTTL.Store(int64(ttl))
 }
 
-// Lock acquires a lock on the given key in the memory database.
+// Lock acquires a lock on the given key in the memory database, and returns the lease that holds it.
 // If the key does not exist, it returns an error.
-func (m *MemoryDB[T]) Lock(key Key) error {
-	pageHeader, exists := m.header[key]
+func (m *MemoryDB[T]) Lock(key Key) (Token, error) {
+	pageHeader, exists := m.shardFor(key).getHeader(key)
 	if !exists {
-		return ErrRecordNotFound
+		return 0, ErrRecordNotFound
 	}
 
 	ok := pageHeader.latch.TryLock()
 	if !ok {
-		return ErrRowLocked
+		return 0, ErrRowLocked
 	}
-	return nil
+	if !pageHeader.acquired() {
+		return 0, ErrRecordNotFound
+	}
+	return pageHeader.grant(time.Duration(m.leaseTTL.Load())), nil
+}
+
+// LockContext acquires a lock on the given key, waiting for it in FIFO order until the context is done.
+// If the context is done first, it returns an error wrapping both ErrRowLocked and the context error.
+func (m *MemoryDB[T]) LockContext(ctx context.Context, key Key) (Token, error) {
+	pageHeader, exists := m.shardFor(key).getHeader(key)
+	if !exists {
+		return 0, ErrRecordNotFound
+	}
+
+	if err := pageHeader.latch.LockContext(ctx); err != nil {
+		return 0, err
+	}
+	if !pageHeader.acquired() {
+		return 0, ErrRecordNotFound
+	}
+	return pageHeader.grant(time.Duration(m.leaseTTL.Load())), nil
 }
 
 // Unlock releases a lock on the given key in the memory database.
-func (m *MemoryDB[T]) Unlock(key Key) error {
-	pageHeader, exists := m.header[key]
+// Only the holder of the lease can release it, otherwise it returns ErrNotOwner or ErrLeaseExpired.
+func (m *MemoryDB[T]) Unlock(key Key, lease Token) error {
+	pageHeader, exists := m.shardFor(key).getHeader(key)
 	if !exists {
 		return ErrRecordNotFound
 	}
 
-	ok := pageHeader.latch.TryLock()
-	defer pageHeader.latch.Unlock()
-	if ok {
-		return ErrUnlockedBefore
-	}
-	return nil
+	return pageHeader.release(lease)
 }
 
 // Setnx sets the given key to the given record in the memory database if not exists. otherwise returns an error.
 func (m *MemoryDB[T]) Setnx(key Key, record T) error {
-	m.setnxmu.Lock()
-	defer m.setnxmu.Unlock()
-	_, exists := m.header[key]
+	m.commitmu.RLock()
+	defer m.commitmu.RUnlock()
+
+	shard := m.shardFor(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	_, exists := shard.header[key]
 	if exists {
 		return ErrRecordExists
 	}
 
-	m.header[key] = new(header)
-	m.records[key] = record
+	committed, err := m.log(opSetnx, key, record)
+	if err != nil {
+		m.clock.publish(committed, nil)
+		return err
+	}
+	m.clock.publish(committed, []ChangeEvent[T]{shard.put(key, record, committed, m.clock.horizon())})
 	return nil
 }
 
 // Set sets the given key to the given record in the memory database.
-func (m *MemoryDB[T]) Set(key Key, record T, opts Opts) {
+// A row locked with Lock can only be written with its lease in opts.Lease.
+// Otherwise, a safe write waits for the row to be unlocked and an unsafe one fails with ErrRowLocked.
+// The record is written to the write-ahead log first, if there is one.
+func (m *MemoryDB[T]) Set(key Key, record T, opts Opts) error {
+	pageHeader, exists := m.shardFor(key).getHeader(key)
+	if !exists {
+		return m.commit(key, record)
+	}
+
+	if opts.Lease != 0 {
+		// the lease can't expire halfway through the write.
+		pageHeader.mu.Lock()
+		defer pageHeader.mu.Unlock()
+		if err := pageHeader.check(opts.Lease); err != nil {
+			return err
+		}
+		return m.commit(key, record)
+	}
+
 	if !opts.Safe {
-		m.records[key] = record
-		return
+		// the latch is taken before the lease is granted, checking the lease alone would race a locker.
+		if !pageHeader.latch.TryLock() {
+			return ErrRowLocked
+		}
+		defer pageHeader.latch.Unlock()
+		return m.commit(key, record)
 	}
 
-	pageHeader, exists := m.header[key]
+	pageHeader.latch.Lock()
+	defer pageHeader.latch.Unlock()
+	return m.commit(key, record)
+}
+
+// Delete removes the given key from the memory database, following the same rules as Set for locked rows.
+// Readers that started before the delete still see the record, it's dropped once none of them can.
+// A lease passed in opts.Lease is released with the record, and anyone waiting for its lock gets ErrRecordNotFound.
+func (m *MemoryDB[T]) Delete(key Key, opts Opts) error {
+	pageHeader, exists := m.shardFor(key).getHeader(key)
 	if !exists {
-		m.records[key] = record
-		m.header[key] = new(header)
-		return
+		return ErrRecordNotFound
+	}
+
+	if opts.Lease != 0 {
+		pageHeader.mu.Lock()
+		defer pageHeader.mu.Unlock()
+		if err := pageHeader.check(opts.Lease); err != nil {
+			return err
+		}
+		if err := m.remove(key, pageHeader); err != nil {
+			return err
+		}
+		pageHeader.end()
+		return nil
+	}
+
+	if !opts.Safe {
+		if !pageHeader.latch.TryLock() {
+			return ErrRowLocked
+		}
+		defer pageHeader.latch.Unlock()
+		return m.remove(key, pageHeader)
 	}
 
 	pageHeader.latch.Lock()
 	defer pageHeader.latch.Unlock()
-	m.records[key] = record
+	return m.remove(key, pageHeader)
+}
+
+// remove logs the delete, then writes the tombstone.
+func (m *MemoryDB[T]) remove(key Key, pageHeader *header) error {
+	m.commitmu.RLock()
+	defer m.commitmu.RUnlock()
+
+	shard := m.shardFor(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	if shard.header[key] != pageHeader {
+		return ErrRecordNotFound
+	}
+
+	committed, err := m.logDelete(key)
+	if err != nil {
+		m.clock.publish(committed, nil)
+		return err
+	}
+	m.clock.publish(committed, []ChangeEvent[T]{shard.remove(key, committed, m.clock.horizon())})
+	return nil
+}
+
+// commit logs the write, then applies it.
+func (m *MemoryDB[T]) commit(key Key, record T) error {
+	m.commitmu.RLock()
+	defer m.commitmu.RUnlock()
+
+	shard := m.shardFor(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	committed, err := m.log(opSet, key, record)
+	if err != nil {
+		m.clock.publish(committed, nil)
+		return err
+	}
+	m.clock.publish(committed, []ChangeEvent[T]{shard.put(key, record, committed, m.clock.horizon())})
+	return nil
+}
+
+// CompareAndSet sets the given key to the given record only if its latest version is still the expected one,
+// otherwise it returns ErrVersionMismatch. It returns the version of the new record.
+// It never waits: a row locked by someone else fails with ErrRowLocked, its holder is about to write it.
+func (m *MemoryDB[T]) CompareAndSet(key Key, expected uint64, record T) (uint64, error) {
+	shard := m.shardFor(key)
+	pageHeader, exists := shard.getHeader(key)
+	if !exists {
+		return 0, ErrRecordNotFound
+	}
+	if !pageHeader.latch.TryLock() {
+		return 0, ErrRowLocked
+	}
+	defer pageHeader.latch.Unlock()
+
+	m.commitmu.RLock()
+	defer m.commitmu.RUnlock()
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	if shard.header[key] != pageHeader {
+		// deleted meanwhile.
+		return 0, ErrRecordNotFound
+	}
+	chain := shard.versions[key]
+	if chain[len(chain)-1].at != expected {
+		return 0, ErrVersionMismatch
+	}
+
+	committed, err := m.log(opSet, key, record)
+	if err != nil {
+		m.clock.publish(committed, nil)
+		return 0, err
+	}
+	m.clock.publish(committed, []ChangeEvent[T]{shard.put(key, record, committed, m.clock.horizon())})
+	return committed, nil
+}
+
+// GetWithVersion returns the latest record for the given key along with its version,
+// the version to pass to CompareAndSet. It doesn't wait for the row to be unlocked.
+func (m *MemoryDB[T]) GetWithVersion(key Key) (T, uint64, error) {
+	record, version, exists := m.shardFor(key).getLatest(key)
+	if !exists {
+		return record, 0, ErrRecordNotFound
+	}
+	return record, version, nil
 }
 
 // GetM returns the records for the given keys in the memory database.
@@ -142,8 +322,9 @@ func (m *MemoryDB[T]) GetM(terms []Key, opts Opts) []T {
 
 // Get returns the record for the given key in the memory database.
 func (m *MemoryDB[T]) Get(key Key, opts Opts) (T, error) {
+	shard := m.shardFor(key)
 	if !opts.Safe {
-		document, exists := m.records[key]
+		document, exists := shard.getRecord(key)
 		if !exists {
 			return document, ErrRecordNotFound
 		}
@@ -151,27 +332,45 @@ func (m *MemoryDB[T]) Get(key Key, opts Opts) (T, error) {
 	}
 
 	var record T
-	header, exists := m.header[key]
+	header, exists := shard.getHeader(key)
 	if !exists {
 		return record, ErrRecordNotFound
 	}
 
 	header.latch.Lock()
 	defer header.latch.Unlock()
-	document := m.records[key]
+	document, exists := shard.getRecord(key)
+	if !exists {
+		return document, ErrRecordNotFound
+	}
 	return document, nil
 }
 
 // Keys returns all the keys in the MemoryDB.
 func (m *MemoryDB[T]) Keys() []Key {
 	var keys []Key
-	for k := range m.records {
-		keys = append(keys, k)
+	for _, shard := range m.shards {
+		shard.mu.RLock()
+		for k := range shard.records {
+			keys = append(keys, k)
+		}
+		shard.mu.RUnlock()
 	}
 	return keys
 }
 
+// Version returns the latest visible commit version, the version a View would read at.
+func (m *MemoryDB[T]) Version() uint64 {
+	return m.clock.visible.Load()
+}
+
 // Length returns the number of items in the MemoryDB.
 func (m *MemoryDB[T]) Length() int {
-	return len(m.records)
+	length := 0
+	for _, shard := range m.shards {
+		shard.mu.RLock()
+		length += len(shard.records)
+		shard.mu.RUnlock()
+	}
+	return length
 }
@@ -0,0 +1,605 @@
+package memorydb_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/0xSherlokMo/banking-system-challenge/memorydb"
+)
+
+// TestConcurrentAccess is meant to be run with -race, it mixes every operation on shared and new keys.
+func TestConcurrentAccess(t *testing.T) {
+	db := memorydb.Default[*record]()
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i)
+		db.Setnx(key, &record{ID: key})
+	}
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < 8; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				key := strconv.Itoa(i % 100)
+				newKey := fmt.Sprintf("%d-%d", worker, i)
+
+				db.Setnx(newKey, &record{ID: newKey})
+				db.Set(key, &record{ID: key, Value: i}, memorydb.Opts{Safe: i%2 == 0})
+				db.Get(key, memorydb.Opts{Safe: i%3 == 0})
+				if lease, err := db.Lock(key); err == nil {
+					db.Set(key, &record{ID: key, Value: -i}, memorydb.Opts{Lease: lease})
+					db.Unlock(key, lease)
+				}
+				if i%50 == 0 {
+					db.GetM(db.Keys(), memorydb.Opts{Safe: memorydb.ConcurrentNotSafe})
+					db.Length()
+				}
+			}
+		}(worker)
+	}
+	wg.Wait()
+
+	if db.Length() != 100+8*500 {
+		t.Errorf("expected %d records but got %d", 100+8*500, db.Length())
+	}
+}
+
+func TestRowLatchSemantics(t *testing.T) {
+	db := memorydb.Default[*record]()
+	db.Setnx("a", &record{ID: "a"})
+
+	if _, err := db.Lock("missing"); err != memorydb.ErrRecordNotFound {
+		t.Errorf("expected ErrRecordNotFound but got %v", err)
+	}
+	lease, err := db.Lock("a")
+	if err != nil {
+		t.Fatalf("expected to lock a but got %v", err)
+	}
+	if _, err := db.Lock("a"); err != memorydb.ErrRowLocked {
+		t.Errorf("expected ErrRowLocked but got %v", err)
+	}
+	if err := db.Unlock("a", lease); err != nil {
+		t.Errorf("expected to unlock a but got %v", err)
+	}
+	if err := db.Unlock("a", lease); err != memorydb.ErrUnlockedBefore {
+		t.Errorf("expected ErrUnlockedBefore but got %v", err)
+	}
+}
+
+func TestLeaseOwnership(t *testing.T) {
+	db := memorydb.Default[*record]()
+	db.Setnx("a", &record{ID: "a"})
+
+	lease, _ := db.Lock("a")
+	other := lease + 1000
+	if err := db.Unlock("a", other); err != memorydb.ErrNotOwner {
+		t.Errorf("expected ErrNotOwner on unlock but got %v", err)
+	}
+	if err := db.Set("a", &record{ID: "a", Value: 1}, memorydb.Opts{Lease: other}); err != memorydb.ErrNotOwner {
+		t.Errorf("expected ErrNotOwner on set but got %v", err)
+	}
+	if err := db.Set("a", &record{ID: "a", Value: 1}, memorydb.Opts{Safe: memorydb.ConcurrentNotSafe}); err != memorydb.ErrRowLocked {
+		t.Errorf("expected unsafe set on a locked row to fail but got %v", err)
+	}
+	if err := db.Set("a", &record{ID: "a", Value: 2}, memorydb.Opts{Lease: lease}); err != nil {
+		t.Errorf("expected the holder to write but got %v", err)
+	}
+	db.Unlock("a", lease)
+
+	value, _ := db.Get("a", memorydb.Opts{Safe: memorydb.ConcurrentSafe})
+	if value.Value != 2 {
+		t.Errorf("expected only the holder write to be applied but got %d", value.Value)
+	}
+}
+
+func TestLeaseExpires(t *testing.T) {
+	db := memorydb.Default[*record]()
+	db.SetLeaseTTL(10 * time.Millisecond)
+	db.Setnx("a", &record{ID: "a"})
+
+	lease, _ := db.Lock("a")
+	waitCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	next, err := db.LockContext(waitCtx, "a")
+	if err != nil {
+		t.Fatalf("expected the expired lease to hand the row over but got %v", err)
+	}
+
+	if err := db.Set("a", &record{ID: "a", Value: 1}, memorydb.Opts{Lease: lease}); err != memorydb.ErrLeaseExpired {
+		t.Errorf("expected ErrLeaseExpired on set but got %v", err)
+	}
+	if err := db.Unlock("a", lease); err != memorydb.ErrLeaseExpired {
+		t.Errorf("expected ErrLeaseExpired on unlock but got %v", err)
+	}
+	if err := db.Unlock("a", next); err != nil {
+		t.Errorf("expected the new holder to unlock but got %v", err)
+	}
+}
+
+func TestCommitAfterLeaseExpiredAndDelete(t *testing.T) {
+	db := memorydb.Default[*record]()
+	db.SetLeaseTTL(10 * time.Millisecond)
+	db.Setnx("a", &record{ID: "a"})
+
+	err := db.Update(context.Background(), func(tx memorydb.Txn[*record]) error {
+		if err := tx.Lock("a"); err != nil {
+			return err
+		}
+		time.Sleep(30 * time.Millisecond)
+		if err := db.Delete("a", memorydb.Opts{Safe: memorydb.ConcurrentSafe}); err != nil {
+			t.Fatalf("expected the row to be deleted once the lease expired but got %v", err)
+		}
+		return tx.Set("a", &record{ID: "a", Value: 1})
+	})
+	if err != memorydb.ErrLeaseExpired {
+		t.Errorf("expected ErrLeaseExpired but got %v", err)
+	}
+	if _, err := db.Get("a", memorydb.Opts{}); err != memorydb.ErrRecordNotFound {
+		t.Errorf("expected the row to stay deleted but got %v", err)
+	}
+}
+
+func TestLockContextWaitsForLatch(t *testing.T) {
+	db := memorydb.Default[*record]()
+	db.Setnx("a", &record{ID: "a"})
+	held, _ := db.Lock("a")
+
+	timeoutCtx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := db.LockContext(timeoutCtx, "a")
+	if !errors.Is(err, memorydb.ErrRowLocked) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected ErrRowLocked after the deadline but got %v", err)
+	}
+
+	// waiters get the latch in the order they started waiting.
+	var order []int
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			lease, err := db.LockContext(context.Background(), "a")
+			if err != nil {
+				t.Errorf("unexpected error %v", err)
+				return
+			}
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+			db.Unlock("a", lease)
+		}(i)
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if _, err := db.Lock("a"); err != memorydb.ErrRowLocked {
+		t.Errorf("expected TryLock not to jump ahead of waiters")
+	}
+	db.Unlock("a", held)
+	wg.Wait()
+
+	for i, waiter := range order {
+		if waiter != i {
+			t.Errorf("expected waiters to be served in FIFO order but got %v", order)
+			break
+		}
+	}
+}
+
+func TestUpdateRollsBackOnError(t *testing.T) {
+	db := memorydb.Default[*record]()
+	db.Setnx("a", &record{ID: "a", Value: 1})
+	db.Setnx("b", &record{ID: "b", Value: 1})
+
+	failure := errors.New("failure")
+	err := db.Update(context.Background(), func(tx memorydb.Txn[*record]) error {
+		tx.Set("a", &record{ID: "a", Value: 2})
+		tx.Set("c", &record{ID: "c", Value: 2})
+		written, _ := tx.Get("a")
+		if written.Value != 2 {
+			t.Errorf("expected the transaction to read its own write but got %d", written.Value)
+		}
+		return failure
+	})
+	if err != failure {
+		t.Errorf("expected the function error but got %v", err)
+	}
+
+	a, _ := db.Get("a", memorydb.Opts{Safe: memorydb.ConcurrentSafe})
+	if a.Value != 1 {
+		t.Errorf("expected the write to be discarded but got %d", a.Value)
+	}
+	if _, err := db.Get("c", memorydb.Opts{}); err != memorydb.ErrRecordNotFound {
+		t.Errorf("expected the new key to be discarded but got %v", err)
+	}
+	// the locks were released.
+	if _, err := db.Lock("a"); err != nil {
+		t.Errorf("expected the row to be unlocked but got %v", err)
+	}
+}
+
+// TestUpdateIsAtomic moves values between two keys from both sides at once, the total must never change.
+func TestUpdateIsAtomic(t *testing.T) {
+	db := memorydb.Default[*record]()
+	db.Setnx("a", &record{ID: "a", Value: 1000})
+	db.Setnx("b", &record{ID: "b", Value: 1000})
+
+	move := func(from, to memorydb.Key) func(tx memorydb.Txn[*record]) error {
+		return func(tx memorydb.Txn[*record]) error {
+			if err := tx.Lock(from, to); err != nil {
+				return err
+			}
+			source, _ := tx.Get(from)
+			target, _ := tx.Get(to)
+			tx.Set(from, &record{ID: from, Value: source.Value - 1})
+			tx.Set(to, &record{ID: to, Value: target.Value + 1})
+			return nil
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 200; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := db.Update(context.Background(), move("a", "b")); err != nil {
+				t.Errorf("unexpected error %v", err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if err := db.Update(context.Background(), move("b", "a")); err != nil {
+				t.Errorf("unexpected error %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	a, _ := db.Get("a", memorydb.Opts{Safe: memorydb.ConcurrentSafe})
+	b, _ := db.Get("b", memorydb.Opts{Safe: memorydb.ConcurrentSafe})
+	if a.Value != 1000 || b.Value != 1000 {
+		t.Errorf("expected both values to be 1000 but got %d and %d", a.Value, b.Value)
+	}
+}
+
+func TestViewReadsSingleVersion(t *testing.T) {
+	db := memorydb.Default[*record]()
+	db.Setnx("a", &record{ID: "a", Value: 1})
+
+	db.View(func(tx memorydb.ReadTxn[*record]) error {
+		// commits made while the view is open aren't visible to it, and it doesn't wait for them.
+		lease, _ := db.Lock("a")
+		db.Set("a", &record{ID: "a", Value: 2}, memorydb.Opts{Lease: lease})
+		db.Unlock("a", lease)
+		db.Setnx("b", &record{ID: "b", Value: 2})
+
+		a, err := tx.Get("a")
+		if err != nil || a.Value != 1 {
+			t.Errorf("expected the view to read a at its version but got %+v %v", a, err)
+		}
+		if _, err := tx.Get("b"); err != memorydb.ErrRecordNotFound {
+			t.Errorf("expected b not to exist at the view version but got %v", err)
+		}
+		if keys := tx.Keys(); len(keys) != 1 {
+			t.Errorf("expected only a to exist at the view version but got %v", keys)
+		}
+		return nil
+	})
+
+	db.View(func(tx memorydb.ReadTxn[*record]) error {
+		if records := tx.GetM([]memorydb.Key{"a", "b"}); len(records) != 2 || records[0].Value != 2 {
+			t.Errorf("expected a new view to see the latest commits but got %+v", records)
+		}
+		return nil
+	})
+}
+
+func TestCompareAndSet(t *testing.T) {
+	db := memorydb.Default[*record]()
+	db.Setnx("a", &record{ID: "a"})
+	_, version, _ := db.GetWithVersion("a")
+
+	next, err := db.CompareAndSet("a", version, &record{ID: "a", Value: 1})
+	if err != nil || next <= version {
+		t.Fatalf("expected the write to go through with a greater version but got %d %v", next, err)
+	}
+	if _, err := db.CompareAndSet("a", version, &record{ID: "a", Value: 2}); err != memorydb.ErrVersionMismatch {
+		t.Errorf("expected ErrVersionMismatch on a stale version but got %v", err)
+	}
+
+	lease, _ := db.Lock("a")
+	if _, err := db.CompareAndSet("a", next, &record{ID: "a", Value: 3}); err != memorydb.ErrRowLocked {
+		t.Errorf("expected ErrRowLocked on a locked row but got %v", err)
+	}
+	db.Unlock("a", lease)
+
+	if _, err := db.CompareAndSet("missing", 1, &record{ID: "missing"}); err != memorydb.ErrRecordNotFound {
+		t.Errorf("expected ErrRecordNotFound but got %v", err)
+	}
+
+	value, latest, _ := db.GetWithVersion("a")
+	if value.Value != 1 || latest != next {
+		t.Errorf("expected only the first write to be applied but got %+v at %d", value, latest)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	db := memorydb.Default[*record]()
+	db.Setnx("a", &record{ID: "a", Value: 1})
+
+	lease, _ := db.Lock("a")
+	waited := make(chan error)
+	go func() {
+		_, err := db.LockContext(context.Background(), "a")
+		waited <- err
+	}()
+	time.Sleep(5 * time.Millisecond)
+
+	db.View(func(tx memorydb.ReadTxn[*record]) error {
+		if err := db.Delete("a", memorydb.Opts{Lease: lease}); err != nil {
+			t.Fatalf("expected the holder to delete but got %v", err)
+		}
+		// the view started before the delete.
+		if a, err := tx.Get("a"); err != nil || a.Value != 1 {
+			t.Errorf("expected an older view to still read the record but got %+v %v", a, err)
+		}
+		return nil
+	})
+
+	if err := <-waited; err != memorydb.ErrRecordNotFound {
+		t.Errorf("expected the waiter to give up with ErrRecordNotFound but got %v", err)
+	}
+	if _, err := db.Get("a", memorydb.Opts{Safe: memorydb.ConcurrentSafe}); err != memorydb.ErrRecordNotFound {
+		t.Errorf("expected the record to be deleted but got %v", err)
+	}
+	if _, err := db.Lock("a"); err != memorydb.ErrRecordNotFound {
+		t.Errorf("expected the row lock to be dropped but got %v", err)
+	}
+	if err := db.Unlock("a", lease); err != memorydb.ErrRecordNotFound {
+		t.Errorf("expected the lease to be released with the record but got %v", err)
+	}
+	if db.Length() != 0 {
+		t.Errorf("expected no records but got %d", db.Length())
+	}
+
+	if err := db.Setnx("a", &record{ID: "a", Value: 2}); err != nil {
+		t.Errorf("expected a deleted key to be reusable but got %v", err)
+	}
+	if err := db.Delete("missing", memorydb.Opts{}); err != memorydb.ErrRecordNotFound {
+		t.Errorf("expected ErrRecordNotFound but got %v", err)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	db := memorydb.Default[*record]()
+	db.Setnx("account-a", &record{ID: "account-a"})
+
+	subscription, err := db.Watch(context.Background(), "account-", memorydb.WatchOptions{})
+	if err != nil {
+		t.Fatalf("cannot watch: %v", err)
+	}
+	defer subscription.Close()
+
+	db.Set("account-a", &record{ID: "account-a", Value: 1}, memorydb.Opts{})
+	db.Setnx("other", &record{ID: "other"})
+	db.Update(context.Background(), func(tx memorydb.Txn[*record]) error {
+		tx.Set("account-a", &record{ID: "account-a", Value: 2})
+		return tx.Set("account-b", &record{ID: "account-b", Value: 3})
+	})
+	db.Delete("account-b", memorydb.Opts{Safe: memorydb.ConcurrentSafe})
+
+	expected := []struct {
+		key  memorydb.Key
+		kind memorydb.ChangeKind
+		old  int
+		new  int
+	}{
+		{"account-a", memorydb.ChangeUpdate, 0, 1},
+		{"account-a", memorydb.ChangeUpdate, 1, 2},
+		{"account-b", memorydb.ChangeCreate, 0, 3},
+		{"account-b", memorydb.ChangeDelete, 3, 0},
+	}
+	var events []memorydb.ChangeEvent[*record]
+	for _, want := range expected {
+		event := <-subscription.Events()
+		events = append(events, event)
+		var old, new int
+		if event.Old != nil {
+			old = event.Old.Value
+		}
+		if event.New != nil {
+			new = event.New.Value
+		}
+		if event.Key != want.key || event.Kind != want.kind || old != want.old || new != want.new {
+			t.Errorf("expected %+v but got %s %s %d -> %d", want, event.Key, event.Kind, old, new)
+		}
+	}
+	if events[1].Version != events[2].Version || events[0].Version >= events[1].Version || events[2].Version >= events[3].Version {
+		t.Errorf("expected events in commit order, the transaction ones sharing a version, but got %+v", events)
+	}
+
+	// a subscriber resuming from a version gets the events committed after it.
+	resumed, err := db.Watch(context.Background(), "account-", memorydb.WatchOptions{FromVersion: events[0].Version})
+	if err != nil {
+		t.Fatalf("cannot resume: %v", err)
+	}
+	defer resumed.Close()
+	for _, want := range events[1:] {
+		if event := <-resumed.Events(); event.Version != want.Version || event.Key != want.Key {
+			t.Errorf("expected the resumed event %d %s but got %d %s", want.Version, want.Key, event.Version, event.Key)
+		}
+	}
+
+	subscription.Close()
+	if _, open := <-subscription.Events(); open || subscription.Err() != nil {
+		t.Errorf("expected a closed subscription to end without error but got %v", subscription.Err())
+	}
+}
+
+// writers make their commits visible without waiting on each other, the feed must still get every one in order.
+func TestWatchOrdersConcurrentCommits(t *testing.T) {
+	db := memorydb.Default[*record]()
+	db.SetFeedRetention(100_000)
+	subscription, err := db.Watch(context.Background(), "", memorydb.WatchOptions{Buffer: 100_000})
+	if err != nil {
+		t.Fatalf("cannot watch: %v", err)
+	}
+	defer subscription.Close()
+
+	const writers, commits = 8, 500
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < commits; i++ {
+				key := fmt.Sprintf("%d-%d", w, i%10)
+				db.Set(key, &record{ID: key, Value: i}, memorydb.Opts{Safe: memorydb.ConcurrentSafe})
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	var last uint64
+	for i := 0; i < writers*commits; i++ {
+		event := <-subscription.Events()
+		if event.Version != last+1 {
+			t.Fatalf("expected version %d but got %d", last+1, event.Version)
+		}
+		last = event.Version
+	}
+	if db.Version() != last {
+		t.Errorf("expected every commit to be visible at %d but got %d", last, db.Version())
+	}
+}
+
+func TestWatchDropsSlowSubscribers(t *testing.T) {
+	db := memorydb.Default[*record]()
+	db.SetFeedRetention(8)
+	db.Setnx("a", &record{ID: "a"})
+
+	slow, _ := db.Watch(context.Background(), "", memorydb.WatchOptions{Buffer: 1})
+	// nobody reads the subscription, writes still go through.
+	for i := 0; i < 100; i++ {
+		db.Set("a", &record{ID: "a", Value: i}, memorydb.Opts{})
+	}
+
+	read := 0
+	for range slow.Events() {
+		read++
+	}
+	if slow.Err() != memorydb.ErrSubscriberLagged || read >= 100 {
+		t.Errorf("expected the slow subscriber to be dropped but got %v after %d events", slow.Err(), read)
+	}
+
+	if _, err := db.Watch(context.Background(), "", memorydb.WatchOptions{FromVersion: 1}); err != memorydb.ErrVersionCompacted {
+		t.Errorf("expected resuming from an evicted version to fail but got %v", err)
+	}
+}
+
+// run with -cpu 1,2,4,8 to see throughput scaling with cores.
+func BenchmarkGet(b *testing.B) {
+	db := seededDB(10_000)
+	var workers atomic.Int64
+	b.RunParallel(func(pb *testing.PB) {
+		// every worker starts on a different key, so they don't all hit the same shard at once.
+		i := int(workers.Add(1) * 7919)
+		for pb.Next() {
+			db.Get(strconv.Itoa(i%10_000), memorydb.Opts{Safe: memorydb.ConcurrentNotSafe})
+			i++
+		}
+	})
+}
+
+func BenchmarkSafeGet(b *testing.B) {
+	db := seededDB(10_000)
+	var workers atomic.Int64
+	b.RunParallel(func(pb *testing.PB) {
+		// every worker starts on a different key, so they don't all hit the same shard at once.
+		i := int(workers.Add(1) * 7919)
+		for pb.Next() {
+			db.Get(strconv.Itoa(i%10_000), memorydb.Opts{Safe: memorydb.ConcurrentSafe})
+			i++
+		}
+	})
+}
+
+func BenchmarkSet(b *testing.B) {
+	db := seededDB(10_000)
+	var workers atomic.Int64
+	b.RunParallel(func(pb *testing.PB) {
+		// every worker starts on a different key, so they don't all hit the same shard at once.
+		i := int(workers.Add(1) * 7919)
+		for pb.Next() {
+			key := strconv.Itoa(i % 10_000)
+			db.Set(key, &record{ID: key, Value: i}, memorydb.Opts{Safe: memorydb.ConcurrentNotSafe})
+			i++
+		}
+	})
+}
+
+func BenchmarkSetnx(b *testing.B) {
+	db := memorydb.Default[*record]()
+	var counter atomic.Int64
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			key := strconv.FormatInt(counter.Add(1), 10)
+			db.Setnx(key, &record{ID: key})
+		}
+	})
+}
+
+// commits only meet in the clock, so transactions on different keys should scale with cores like Set.
+func BenchmarkUpdate(b *testing.B) {
+	db := seededDB(10_000)
+	var workers atomic.Int64
+	b.RunParallel(func(pb *testing.PB) {
+		// every worker starts on a different key, so they don't all hit the same shard at once.
+		i := int(workers.Add(1) * 7919)
+		for pb.Next() {
+			from, to := strconv.Itoa(i%10_000), strconv.Itoa((i+1)%10_000)
+			db.Update(context.Background(), func(tx memorydb.Txn[*record]) error {
+				if err := tx.Lock(from, to); err != nil {
+					return err
+				}
+				tx.Set(from, &record{ID: from, Value: i})
+				return tx.Set(to, &record{ID: to, Value: i})
+			})
+			i += 2
+		}
+	})
+}
+
+func BenchmarkMixed(b *testing.B) {
+	db := seededDB(10_000)
+	var workers atomic.Int64
+	b.RunParallel(func(pb *testing.PB) {
+		// every worker starts on a different key, so they don't all hit the same shard at once.
+		i := int(workers.Add(1) * 7919)
+		for pb.Next() {
+			key := strconv.Itoa(i % 10_000)
+			if i%10 == 0 {
+				db.Set(key, &record{ID: key, Value: i}, memorydb.Opts{Safe: memorydb.ConcurrentSafe})
+			} else {
+				db.Get(key, memorydb.Opts{Safe: memorydb.ConcurrentNotSafe})
+			}
+			i++
+		}
+	})
+}
+
+func seededDB(size int) *memorydb.MemoryDB[*record] {
+	db := memorydb.Default[*record]()
+	for i := 0; i < size; i++ {
+		key := strconv.Itoa(i)
+		db.Setnx(key, &record{ID: key})
+	}
+	return db
+}
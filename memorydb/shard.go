@@ -0,0 +1,148 @@
+package memorydb
+
+import (
+	"sync"
+)
+
+// shardCount is the number of lock stripes, a power of two so the hash can be masked.
+const shardCount = 64
+
+// shard guards its records and headers maps with its own lock, so operations on keys
+// living in different shards never contend with each other.
+type shard[T IdentifiedRecord] struct {
+	mu      sync.RWMutex
+	records map[Key]T
+	header  map[Key]*header
+
+	// versions keeps the committed versions of every record that readers may still see, oldest first.
+	versions map[Key][]version[T]
+
+	// tombstones are the deleted keys whose versions are kept until no reader can see them.
+	tombstones map[Key]struct{}
+}
+
+func newShards[T IdentifiedRecord]() []*shard[T] {
+	shards := make([]*shard[T], shardCount)
+	for i := range shards {
+		shards[i] = &shard[T]{
+			records:    make(map[Key]T),
+			header:     make(map[Key]*header),
+			versions:   make(map[Key][]version[T]),
+			tombstones: make(map[Key]struct{}),
+		}
+	}
+	return shards
+}
+
+// shardFor hashes the key with FNV-1a, inlined to avoid allocating a hasher on every call.
+func (m *MemoryDB[T]) shardFor(key Key) *shard[T] {
+	hash := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint32(key[i])
+		hash *= 16777619
+	}
+	return m.shards[hash&(shardCount-1)]
+}
+
+func (s *shard[T]) getHeader(key Key) (*header, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	pageHeader, exists := s.header[key]
+	return pageHeader, exists
+}
+
+func (s *shard[T]) getRecord(key Key) (T, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	record, exists := s.records[key]
+	return record, exists
+}
+
+// getLatest returns the latest record and the version it was committed at.
+func (s *shard[T]) getLatest(key Key) (T, uint64, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	chain, exists := s.versions[key]
+	if !exists || chain[len(chain)-1].deleted {
+		var empty T
+		return empty, 0, false
+	}
+	latest := chain[len(chain)-1]
+	return latest.record, latest.at, true
+}
+
+// getVersion returns the record as it was committed at the given version.
+func (s *shard[T]) getVersion(key Key, version uint64) (T, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return at(s.versions[key], version)
+}
+
+// put stores the record committed at the given version, creating its header if it's a new key.
+// The shard lock must be held. It returns the change it made.
+func (s *shard[T]) put(key Key, record T, committed uint64, horizon uint64) ChangeEvent[T] {
+	return s.apply(key, version[T]{at: committed, record: record}, horizon)
+}
+
+// remove writes a tombstone for the key committed at the given version, and drops its header.
+// The shard lock must be held. It returns the change it made.
+func (s *shard[T]) remove(key Key, committed uint64, horizon uint64) ChangeEvent[T] {
+	return s.apply(key, version[T]{at: committed, deleted: true}, horizon)
+}
+
+// apply adds the version to the key chain, then drops the versions older than the horizon that no reader can see anymore.
+func (s *shard[T]) apply(key Key, committed version[T], horizon uint64) ChangeEvent[T] {
+	change := ChangeEvent[T]{Version: committed.at, Key: key, Kind: ChangeCreate, New: committed.record}
+	if old, exists := s.records[key]; exists {
+		change.Kind, change.Old = ChangeUpdate, old
+	}
+	if committed.deleted {
+		change.Kind = ChangeDelete
+	}
+
+	chain := s.versions[key]
+	// commits usually land in version order, but two of them can race to the same key.
+	i := len(chain)
+	for i > 0 && chain[i-1].at > committed.at {
+		i--
+	}
+	chain = append(chain, version[T]{})
+	copy(chain[i+1:], chain[i:])
+	chain[i] = committed
+	chain = prune(chain, horizon)
+	s.versions[key] = chain
+
+	latest := chain[len(chain)-1]
+	if latest.deleted {
+		delete(s.records, key)
+		if pageHeader, exists := s.header[key]; exists {
+			pageHeader.retire()
+			delete(s.header, key)
+		}
+		s.tombstones[key] = struct{}{}
+	} else {
+		s.records[key] = latest.record
+		if _, exists := s.header[key]; !exists {
+			s.header[key] = newHeader()
+		}
+	}
+
+	s.sweep(horizon)
+	return change
+}
+
+// sweep forgets the deleted keys once no reader can see them anymore.
+func (s *shard[T]) sweep(horizon uint64) {
+	for key := range s.tombstones {
+		chain := s.versions[key]
+		latest := chain[len(chain)-1]
+		if !latest.deleted {
+			delete(s.tombstones, key)
+			continue
+		}
+		if latest.at <= horizon {
+			delete(s.versions, key)
+			delete(s.tombstones, key)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package memorydb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Snapshot file layout, all integers are little endian:
+//
+//	magic "MDBSNAP" | version (uint16) | lsn (uint64) | records count (uint64)
+//	records: record version (uvarint) | key length (uvarint) | key | value length (uvarint) | value
+//	crc32 castagnoli of everything above (uint32)
+//
+// Version 1 snapshots have no record versions, their records are loaded at the snapshot lsn.
+const (
+	snapshotMagic   = "MDBSNAP"
+	snapshotVersion = uint16(2)
+
+	// snapshotsKept is how many snapshots are kept on disk, the log is only compacted behind the oldest one,
+	// so recovery can fall back to an older snapshot if the newest one is corrupt.
+	snapshotsKept = 2
+)
+
+var (
+	// ErrNotDurable is returned when snapshotting a database that has no write-ahead log.
+	ErrNotDurable = errors.New("not_durable")
+
+	// ErrSnapshotInProgress is returned when a snapshot is requested while another one is being taken.
+	ErrSnapshotInProgress = errors.New("snapshot_in_progress")
+
+	// ErrMissingHistory is returned on recovery when the log was compacted but no valid snapshot covers it.
+	ErrMissingHistory = errors.New("missing_history")
+
+	errCorruptSnapshot = errors.New("corrupt_snapshot")
+)
+
+// SnapshotInfo describes a snapshot that was written to disk.
+type SnapshotInfo struct {
+	Path    string
+	LSN     uint64
+	Records int
+}
+
+type snapshotRecord struct {
+	key   Key
+	value []byte
+
+	// version is the commit version the record was written at.
+	version uint64
+}
+
+func snapshotPath(walPath string, lsn uint64) string {
+	return fmt.Sprintf("%s.snapshot-%020d", walPath, lsn)
+}
+
+// listSnapshots returns the snapshots of a log, newest first.
+func listSnapshots(walPath string) ([]SnapshotInfo, error) {
+	matches, err := filepath.Glob(walPath + ".snapshot-*")
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots []SnapshotInfo
+	for _, match := range matches {
+		lsn, err := strconv.ParseUint(strings.TrimPrefix(match, walPath+".snapshot-"), 10, 64)
+		if err != nil {
+			continue
+		}
+		snapshots = append(snapshots, SnapshotInfo{Path: match, LSN: lsn})
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].LSN > snapshots[j].LSN
+	})
+	return snapshots, nil
+}
+
+// writeSnapshot writes the records to a temporary file first, so a crash never leaves a half written snapshot behind.
+func writeSnapshot(path string, lsn uint64, records []snapshotRecord) error {
+	temp := path + ".tmp"
+	file, err := os.OpenFile(temp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp)
+
+	hash := crc32.New(crcTable)
+	writer := bufio.NewWriter(io.MultiWriter(file, hash))
+
+	var header []byte
+	header = append(header, snapshotMagic...)
+	header = binary.LittleEndian.AppendUint16(header, snapshotVersion)
+	header = binary.LittleEndian.AppendUint64(header, lsn)
+	header = binary.LittleEndian.AppendUint64(header, uint64(len(records)))
+	writer.Write(header)
+
+	var buffer []byte
+	for _, record := range records {
+		buffer = binary.AppendUvarint(buffer[:0], record.version)
+		buffer = binary.AppendUvarint(buffer, uint64(len(record.key)))
+		buffer = append(buffer, record.key...)
+		buffer = binary.AppendUvarint(buffer, uint64(len(record.value)))
+		writer.Write(buffer)
+		writer.Write(record.value)
+	}
+
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := binary.Write(file, binary.LittleEndian, hash.Sum32()); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(temp, path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+func readSnapshot(path string) (uint64, []snapshotRecord, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	headerSize := len(snapshotMagic) + 2 + 8 + 8
+	if len(content) < headerSize+4 {
+		return 0, nil, errCorruptSnapshot
+	}
+
+	body, trailer := content[:len(content)-4], content[len(content)-4:]
+	if crc32.Checksum(body, crcTable) != binary.LittleEndian.Uint32(trailer) {
+		return 0, nil, errCorruptSnapshot
+	}
+	if !bytes.HasPrefix(body, []byte(snapshotMagic)) {
+		return 0, nil, errCorruptSnapshot
+	}
+
+	offset := len(snapshotMagic)
+	version := binary.LittleEndian.Uint16(body[offset:])
+	if version != 1 && version != snapshotVersion {
+		return 0, nil, fmt.Errorf("%w: unsupported version %d", errCorruptSnapshot, version)
+	}
+	lsn := binary.LittleEndian.Uint64(body[offset+2:])
+	count := binary.LittleEndian.Uint64(body[offset+10:])
+
+	reader := bytes.NewReader(body[headerSize:])
+	records := make([]snapshotRecord, 0, count)
+	for i := uint64(0); i < count; i++ {
+		recordVersion := lsn
+		if version > 1 {
+			recordVersion, err = binary.ReadUvarint(reader)
+			if err != nil {
+				return 0, nil, errCorruptSnapshot
+			}
+		}
+		key, err := readChunk(reader)
+		if err != nil {
+			return 0, nil, err
+		}
+		value, err := readChunk(reader)
+		if err != nil {
+			return 0, nil, err
+		}
+		records = append(records, snapshotRecord{key: Key(key), value: value, version: recordVersion})
+	}
+	if reader.Len() != 0 {
+		return 0, nil, errCorruptSnapshot
+	}
+
+	return lsn, records, nil
+}
+
+func readChunk(reader *bytes.Reader) ([]byte, error) {
+	length, err := binary.ReadUvarint(reader)
+	if err != nil || length > uint64(reader.Len()) {
+		return nil, errCorruptSnapshot
+	}
+	chunk := make([]byte, length)
+	reader.Read(chunk)
+	return chunk, nil
+}
+
+func syncDir(dir string) error {
+	handle, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer handle.Close()
+	return handle.Sync()
+}
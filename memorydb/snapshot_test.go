@@ -0,0 +1,126 @@
+package memorydb_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/0xSherlokMo/banking-system-challenge/memorydb"
+)
+
+func TestSnapshotRecovery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "records.wal")
+	opts := memorydb.WALOptions{Path: path, Sync: memorydb.SyncNever}
+
+	db, _, _ := memorydb.Open[*record](opts)
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprint(i)
+		db.Setnx(key, &record{ID: key, Value: i})
+	}
+	first, err := db.Snapshot()
+	if err != nil || first.LSN != 10 || first.Records != 10 {
+		t.Fatalf("unexpected snapshot %+v %v", first, err)
+	}
+
+	db.Set("0", &record{ID: "0", Value: 100}, memorydb.Opts{Safe: memorydb.ConcurrentSafe})
+	second, _ := db.Snapshot()
+	db.Set("1", &record{ID: "1", Value: 200}, memorydb.Opts{Safe: memorydb.ConcurrentSafe})
+	db.Close()
+
+	db, info, err := memorydb.Open[*record](opts)
+	if err != nil {
+		t.Fatalf("cannot reopen: %v", err)
+	}
+	if info.SnapshotLSN != second.LSN || info.Replayed != 1 {
+		t.Errorf("expected recovery from the newest snapshot and one replayed record but got %+v", info)
+	}
+	assertValue(t, db, "0", 100)
+	assertValue(t, db, "1", 200)
+	db.Close()
+
+	// a corrupt newest snapshot falls back to the older one, the log still covers the writes after it.
+	content, _ := os.ReadFile(second.Path)
+	content[len(content)/2] ^= 0xff
+	os.WriteFile(second.Path, content, 0o644)
+
+	db, info, err = memorydb.Open[*record](opts)
+	if err != nil {
+		t.Fatalf("cannot reopen: %v", err)
+	}
+	if info.SnapshotLSN != first.LSN || info.SkippedSnapshots != 1 || info.Replayed != 2 {
+		t.Errorf("expected recovery from the older snapshot but got %+v", info)
+	}
+	assertValue(t, db, "0", 100)
+	assertValue(t, db, "1", 200)
+	assertValue(t, db, "9", 9)
+	db.Close()
+}
+
+func TestVersionsSurviveRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "records.wal")
+	opts := memorydb.WALOptions{Path: path, Sync: memorydb.SyncNever}
+
+	db, _, _ := memorydb.Open[*record](opts)
+	db.Setnx("a", &record{ID: "a"})
+	db.Setnx("b", &record{ID: "b"})
+	db.Snapshot()
+	db.Set("b", &record{ID: "b", Value: 1}, memorydb.Opts{Safe: memorydb.ConcurrentSafe})
+	_, a, _ := db.GetWithVersion("a")
+	_, b, _ := db.GetWithVersion("b")
+	db.Close()
+
+	// a comes from the snapshot and b from the log, both keep the version they had.
+	db, _, _ = memorydb.Open[*record](opts)
+	defer db.Close()
+	if _, version, _ := db.GetWithVersion("a"); version != a {
+		t.Errorf("expected a to be recovered at version %d but got %d", a, version)
+	}
+	if _, version, _ := db.GetWithVersion("b"); version != b {
+		t.Errorf("expected b to be recovered at version %d but got %d", b, version)
+	}
+	if version, err := db.CompareAndSet("a", a, &record{ID: "a", Value: 1}); err != nil || version <= b {
+		t.Errorf("expected versions to keep increasing after a restart but got %d %v", version, err)
+	}
+}
+
+func TestSnapshotCompactsLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "records.wal")
+	opts := memorydb.WALOptions{Path: path, Sync: memorydb.SyncNever}
+
+	db, _, _ := memorydb.Open[*record](opts)
+	for i := 0; i < 100; i++ {
+		db.Set("key", &record{ID: "key", Value: i}, memorydb.Opts{Safe: memorydb.ConcurrentSafe})
+	}
+	before, _ := os.Stat(path)
+	snapshot, _ := db.Snapshot()
+	after, _ := os.Stat(path)
+	db.Close()
+
+	if after.Size() >= before.Size() {
+		t.Errorf("expected log to shrink after a snapshot but went from %d to %d bytes", before.Size(), after.Size())
+	}
+
+	// the only snapshot is gone and the log was compacted behind it, recovery can't silently lose data.
+	os.Remove(snapshot.Path)
+	_, _, err := memorydb.Open[*record](opts)
+	if !errors.Is(err, memorydb.ErrMissingHistory) {
+		t.Errorf("expected ErrMissingHistory but got %v", err)
+	}
+}
+
+func TestSnapshotRequiresLog(t *testing.T) {
+	_, err := memorydb.Default[*record]().Snapshot()
+	if !errors.Is(err, memorydb.ErrNotDurable) {
+		t.Errorf("expected ErrNotDurable but got %v", err)
+	}
+}
+
+func assertValue(t *testing.T, db *memorydb.MemoryDB[*record], key string, expected int) {
+	t.Helper()
+	value, err := db.Get(key, memorydb.Opts{Safe: memorydb.ConcurrentSafe})
+	if err != nil || value.Value != expected {
+		t.Errorf("expected %s to be %d but got %+v %v", key, expected, value, err)
+	}
+}
@@ -0,0 +1,175 @@
+package memorydb
+
+import (
+	"context"
+	"sort"
+)
+
+// Txn reads and writes several keys atomically, it's handed to the function run by Update.
+type Txn[T IdentifiedRecord] interface {
+	// Lock locks the given keys in sorted order, so transactions touching the same keys queue
+	// behind each other instead of deadlocking. Keys already locked by the transaction are skipped.
+	Lock(keys ...Key) error
+
+	// Get returns the record for the given key, or the record the transaction wrote to it.
+	// The key is locked first if it isn't already.
+	Get(key Key) (T, error)
+
+	// GetWithVersion returns the latest committed record for the given key along with its version,
+	// the writes of the transaction don't change it until they're committed.
+	// The key is locked first if it isn't already, so the version can't change until the transaction is done.
+	GetWithVersion(key Key) (T, uint64, error)
+
+	// Set buffers a write, it's only applied if the transaction commits.
+	// Existing keys are locked first if they aren't already.
+	Set(key Key, record T) error
+}
+
+// Tx is the MemoryDB implementation of Txn.
+type Tx[T IdentifiedRecord] struct {
+	db     *MemoryDB[T]
+	ctx    context.Context
+	leases map[Key]Token
+	writes map[Key]T
+}
+
+// Update runs fn in a transaction. Every key the transaction reads or writes stays locked until it's done,
+// waiting for each lock until ctx is done.
+// If fn returns an error, its writes are discarded. Otherwise they're written to the log in a single frame
+// and applied together, so they're recovered all together or not at all.
+// Locks are released in both cases.
+func (m *MemoryDB[T]) Update(ctx context.Context, fn func(tx Txn[T]) error) error {
+	tx := &Tx[T]{
+		db:     m,
+		ctx:    ctx,
+		leases: make(map[Key]Token),
+		writes: make(map[Key]T),
+	}
+	defer tx.release()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.commit()
+}
+
+func (tx *Tx[T]) Lock(keys ...Key) error {
+	ordered := make([]Key, 0, len(keys))
+	for _, key := range keys {
+		if _, held := tx.leases[key]; !held {
+			ordered = append(ordered, key)
+		}
+	}
+	sort.Strings(ordered)
+
+	for _, key := range ordered {
+		if _, held := tx.leases[key]; held {
+			// duplicated key.
+			continue
+		}
+
+		lease, err := tx.db.LockContext(tx.ctx, key)
+		if err != nil {
+			return err
+		}
+		tx.leases[key] = lease
+	}
+	return nil
+}
+
+func (tx *Tx[T]) Get(key Key) (T, error) {
+	if record, written := tx.writes[key]; written {
+		return record, nil
+	}
+
+	if err := tx.Lock(key); err != nil {
+		var empty T
+		return empty, err
+	}
+	record, exists := tx.db.shardFor(key).getRecord(key)
+	if !exists {
+		return record, ErrRecordNotFound
+	}
+	return record, nil
+}
+
+func (tx *Tx[T]) GetWithVersion(key Key) (T, uint64, error) {
+	if err := tx.Lock(key); err != nil {
+		var empty T
+		return empty, 0, err
+	}
+	return tx.db.GetWithVersion(key)
+}
+
+func (tx *Tx[T]) Set(key Key, record T) error {
+	if _, exists := tx.db.shardFor(key).getHeader(key); exists {
+		if err := tx.Lock(key); err != nil {
+			return err
+		}
+	}
+
+	tx.writes[key] = record
+	return nil
+}
+
+// commit checks that every lease is still held, then logs and applies the writes.
+func (tx *Tx[T]) commit() error {
+	if len(tx.writes) == 0 {
+		return nil
+	}
+
+	keys := make([]Key, 0, len(tx.writes))
+	for key := range tx.writes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	// the leases can't expire halfway through the commit.
+	for _, key := range keys {
+		lease, held := tx.leases[key]
+		if !held {
+			continue
+		}
+
+		pageHeader, exists := tx.db.shardFor(key).getHeader(key)
+		if !exists {
+			// the row was deleted once the lease expired, the lease went with it.
+			return ErrLeaseExpired
+		}
+		pageHeader.mu.Lock()
+		defer pageHeader.mu.Unlock()
+		if err := pageHeader.check(lease); err != nil {
+			return err
+		}
+	}
+
+	records := make([]T, 0, len(keys))
+	for _, key := range keys {
+		records = append(records, tx.writes[key])
+	}
+
+	tx.db.commitmu.RLock()
+	defer tx.db.commitmu.RUnlock()
+	committed, err := tx.db.logBatch(keys, records)
+	if err != nil {
+		tx.db.clock.publish(committed, nil)
+		return err
+	}
+	horizon := tx.db.clock.horizon()
+	changes := make([]ChangeEvent[T], 0, len(keys))
+	for i, key := range keys {
+		shard := tx.db.shardFor(key)
+		shard.mu.Lock()
+		changes = append(changes, shard.put(key, records[i], committed, horizon))
+		shard.mu.Unlock()
+	}
+	tx.db.clock.publish(committed, changes)
+	return nil
+}
+
+func (tx *Tx[T]) release() {
+	for key, lease := range tx.leases {
+		// an expired lease was already released, the commit reported it.
+		tx.db.Unlock(key, lease)
+	}
+}
@@ -0,0 +1,226 @@
+package memorydb
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
+// version is a record as it was committed at a given commit version.
+type version[T IdentifiedRecord] struct {
+	at     uint64
+	record T
+
+	// deleted marks a tombstone, the record didn't exist from this version on.
+	deleted bool
+}
+
+// pendingCommits is how many published commits can wait for an older one to be published, a power of two
+// so the version can be masked. Commits beyond it wait in an overflow map instead.
+const pendingCommits = 1 << 12
+
+// clock hands out commit versions and tracks which of them readers can see.
+// A version becomes visible once it and every version before it are applied,
+// so a reader never sees half of a commit, nor a commit without the ones before it.
+// Writers never wait on each other in it: versions are handed out by an atomic counter, and published commits
+// are made visible in order by whichever writer gets to them first.
+type clock[T IdentifiedRecord] struct {
+	last    atomic.Uint64
+	visible atomic.Uint64
+
+	// pending holds the published commits that aren't visible yet, by version.
+	pending    [pendingCommits]atomic.Pointer[pendingCommit[T]]
+	overflowed atomic.Int64
+	overflowMu sync.Mutex
+	overflow   map[uint64]*pendingCommit[T]
+
+	// advancing is held by the writer making the published commits visible, the others don't wait for it.
+	advancing sync.Mutex
+
+	// mu guards the readers, oldest is the oldest version they read so writers can read it without locking.
+	mu      sync.Mutex
+	readers map[uint64]int
+	oldest  atomic.Uint64
+
+	// visibleHook is called with every version that becomes visible and the changes of its commit, in order.
+	visibleHook func(at uint64, changes []ChangeEvent[T])
+}
+
+type pendingCommit[T IdentifiedRecord] struct {
+	at      uint64
+	changes []ChangeEvent[T]
+}
+
+func newClock[T IdentifiedRecord]() *clock[T] {
+	c := &clock[T]{
+		overflow: make(map[uint64]*pendingCommit[T]),
+		readers:  make(map[uint64]int),
+	}
+	c.oldest.Store(math.MaxUint64)
+	return c
+}
+
+// begin returns the version of a new commit, it must be published once applied, even if it failed.
+// Durable databases use the lsn of the commit log frame instead.
+func (c *clock[T]) begin() uint64 {
+	return c.last.Add(1)
+}
+
+// reset makes the given version the last visible one, after recovering the records committed up to it.
+func (c *clock[T]) reset(at uint64) {
+	c.last.Store(at)
+	c.visible.Store(at)
+}
+
+// publish marks the commit as applied with its changes, making it visible as soon as the commits before it are.
+// Version 0 is the version of a commit that never got one, it's ignored.
+func (c *clock[T]) publish(at uint64, changes []ChangeEvent[T]) {
+	if at == 0 {
+		return
+	}
+
+	commit := &pendingCommit[T]{at: at, changes: changes}
+	// the slot is still taken when the commit pendingCommits versions before this one isn't visible yet.
+	if !c.pending[at&(pendingCommits-1)].CompareAndSwap(nil, commit) {
+		c.overflowMu.Lock()
+		c.overflow[at] = commit
+		c.overflowMu.Unlock()
+		c.overflowed.Add(1)
+	}
+	c.advance()
+}
+
+// advance makes the published commits visible in order. A writer that can't take it over leaves it to the
+// one running it, which looks for the next commit again once it's done, so no published commit is missed.
+func (c *clock[T]) advance() {
+	for c.ready() && c.advancing.TryLock() {
+		visible := c.visible.Load()
+		for {
+			commit := c.take(visible + 1)
+			if commit == nil {
+				break
+			}
+			visible++
+			if c.visibleHook != nil {
+				c.visibleHook(visible, commit.changes)
+			}
+			c.visible.Store(visible)
+		}
+		c.advancing.Unlock()
+	}
+}
+
+// ready reports whether the commit right after the visible one was published.
+func (c *clock[T]) ready() bool {
+	next := c.visible.Load() + 1
+	if commit := c.pending[next&(pendingCommits-1)].Load(); commit != nil && commit.at == next {
+		return true
+	}
+	if c.overflowed.Load() == 0 {
+		return false
+	}
+	c.overflowMu.Lock()
+	defer c.overflowMu.Unlock()
+	_, found := c.overflow[next]
+	return found
+}
+
+// take removes the published commit of the given version, advancing must be held.
+func (c *clock[T]) take(at uint64) *pendingCommit[T] {
+	slot := &c.pending[at&(pendingCommits-1)]
+	if commit := slot.Load(); commit != nil && commit.at == at {
+		slot.Store(nil)
+		return commit
+	}
+	if c.overflowed.Load() == 0 {
+		return nil
+	}
+	c.overflowMu.Lock()
+	defer c.overflowMu.Unlock()
+	commit, found := c.overflow[at]
+	if !found {
+		return nil
+	}
+	delete(c.overflow, at)
+	c.overflowed.Add(-1)
+	return commit
+}
+
+// acquire pins the visible version for a reader, the versions it can see aren't pruned until it's released.
+func (c *clock[T]) acquire() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for {
+		at := c.visible.Load()
+		c.readers[at]++
+		if at < c.oldest.Load() {
+			c.oldest.Store(at)
+		}
+		// a writer reading the horizon before the pin could have pruned what it needs, unless the visible
+		// version didn't move since: writers read it first, so one that saw a newer version sees the pin.
+		if c.visible.Load() == at {
+			return at
+		}
+		c.unpin(at)
+	}
+}
+
+func (c *clock[T]) release(at uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.unpin(at)
+}
+
+// unpin drops a reader of the given version, mu must be held.
+func (c *clock[T]) unpin(at uint64) {
+	c.readers[at]--
+	if c.readers[at] != 0 {
+		return
+	}
+	delete(c.readers, at)
+	if at != c.oldest.Load() {
+		return
+	}
+	oldest := uint64(math.MaxUint64)
+	for pinned := range c.readers {
+		oldest = min(oldest, pinned)
+	}
+	c.oldest.Store(oldest)
+}
+
+// horizon returns the oldest version a current or future reader can ask for.
+// The visible version is read before the readers, see acquire.
+func (c *clock[T]) horizon() uint64 {
+	visible := c.visible.Load()
+	return min(visible, c.oldest.Load())
+}
+
+// at returns the newest version of the chain committed at or before the given version.
+func at[T IdentifiedRecord](chain []version[T], at uint64) (T, bool) {
+	var empty T
+	for i := len(chain) - 1; i >= 0; i-- {
+		if chain[i].at <= at {
+			if chain[i].deleted {
+				return empty, false
+			}
+			return chain[i].record, true
+		}
+	}
+	return empty, false
+}
+
+// prune drops the versions no reader can see anymore: everything older than
+// the newest version committed at or before the horizon.
+func prune[T IdentifiedRecord](chain []version[T], horizon uint64) []version[T] {
+	keep := 0
+	for i := len(chain) - 1; i >= 0; i-- {
+		if chain[i].at <= horizon {
+			keep = i
+			break
+		}
+	}
+	if keep == 0 {
+		return chain
+	}
+	return append(chain[:0:0], chain[keep:]...)
+}
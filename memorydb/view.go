@@ -0,0 +1,71 @@
+package memorydb
+
+// ReadTxn reads the records as they were at a single commit version, it's handed to the function run by View.
+// It never waits for writers: commits made after it started aren't visible to it.
+type ReadTxn[T IdentifiedRecord] interface {
+	// Version returns the commit version the transaction reads at.
+	Version() uint64
+
+	Get(key Key) (T, error)
+	GetM(keys []Key) []T
+	Keys() []Key
+}
+
+// ReadTx is the MemoryDB implementation of ReadTxn.
+type ReadTx[T IdentifiedRecord] struct {
+	db      *MemoryDB[T]
+	version uint64
+}
+
+// View runs fn in a read-only transaction that sees every record as of the latest visible commit.
+// A commit is either entirely visible or not at all, so a transaction that moved values between keys
+// never shows up half-applied.
+func (m *MemoryDB[T]) View(fn func(tx ReadTxn[T]) error) error {
+	tx := &ReadTx[T]{
+		db:      m,
+		version: m.clock.acquire(),
+	}
+	defer m.clock.release(tx.version)
+
+	return fn(tx)
+}
+
+func (tx *ReadTx[T]) Version() uint64 {
+	return tx.version
+}
+
+func (tx *ReadTx[T]) Get(key Key) (T, error) {
+	record, exists := tx.db.shardFor(key).getVersion(key, tx.version)
+	if !exists {
+		return record, ErrRecordNotFound
+	}
+	return record, nil
+}
+
+// GetM returns the records of the given keys, skipping the ones that didn't exist at the transaction version.
+func (tx *ReadTx[T]) GetM(keys []Key) []T {
+	records := make([]T, 0, len(keys))
+	for _, key := range keys {
+		record, err := tx.Get(key)
+		if err != nil {
+			continue
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// Keys returns the keys that existed at the transaction version.
+func (tx *ReadTx[T]) Keys() []Key {
+	var keys []Key
+	for _, shard := range tx.db.shards {
+		shard.mu.RLock()
+		for key, chain := range shard.versions {
+			if _, exists := at(chain, tx.version); exists {
+				keys = append(keys, key)
+			}
+		}
+		shard.mu.RUnlock()
+	}
+	return keys
+}
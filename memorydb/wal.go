@@ -0,0 +1,439 @@
+package memorydb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// SyncPolicy decides when the write-ahead log is fsynced to disk.
+type SyncPolicy string
+
+const (
+	// SyncAlways fsyncs after every write, nothing acknowledged is lost on a crash.
+	SyncAlways SyncPolicy = "always"
+
+	// SyncInterval fsyncs in the background every WALOptions.SyncInterval,
+	// a machine crash can lose the writes of the last interval.
+	SyncInterval SyncPolicy = "interval"
+
+	// SyncNever leaves flushing to the operating system.
+	SyncNever SyncPolicy = "never"
+
+	DefaultSyncInterval = 100 * time.Millisecond
+)
+
+const (
+	opSet   byte = 1
+	opSetnx byte = 2
+
+	// opCheckpoint starts a compacted log, its lsn is the last lsn that was compacted away.
+	opCheckpoint byte = 3
+
+	// opBatch holds every write of a transaction, so they're recovered all together or not at all.
+	opBatch byte = 4
+
+	// opDelete has no value, it's recovered as a tombstone.
+	opDelete byte = 5
+
+	// frame header: payload length followed by its crc32 checksum.
+	frameHeaderSize = 8
+
+	// maxFrameSize guards against allocating garbage lengths read from a corrupt tail.
+	maxFrameSize = 64 << 20
+)
+
+var (
+	// ErrInvalidSyncPolicy is returned when opening a log with an unknown sync policy.
+	ErrInvalidSyncPolicy = errors.New("invalid_sync_policy")
+
+	errCorruptFrame = errors.New("corrupt_frame")
+	crcTable        = crc32.MakeTable(crc32.Castagnoli)
+)
+
+type WALOptions struct {
+	// Path of the log file, it's created if it doesn't exist.
+	Path         string
+	Sync         SyncPolicy
+	SyncInterval time.Duration
+}
+
+// RecoveryInfo describes what happened while replaying the log on startup.
+type RecoveryInfo struct {
+	// SnapshotLSN is the lsn of the snapshot the records were loaded from, 0 when there was none.
+	SnapshotLSN      uint64
+	SkippedSnapshots int
+	Replayed         int
+	DroppedBytes     int64
+}
+
+type walRecord struct {
+	op    byte
+	lsn   uint64
+	key   Key
+	value []byte
+}
+
+// wal is an append-only log of every write, each frame is checksummed so a torn
+// or corrupt tail can be detected and dropped on recovery.
+type wal struct {
+	mu     sync.Mutex
+	path   string
+	file   *os.File
+	policy SyncPolicy
+	lsn    uint64
+	offset int64
+	dirty  bool
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// openWAL replays the frames written after the base lsn (the lsn of the snapshot the records were loaded from).
+func openWAL(opts WALOptions, base uint64, apply func(walRecord) error) (*wal, RecoveryInfo, error) {
+	if opts.Sync == "" {
+		opts.Sync = SyncInterval
+	}
+	if opts.Sync != SyncAlways && opts.Sync != SyncInterval && opts.Sync != SyncNever {
+		return nil, RecoveryInfo{}, ErrInvalidSyncPolicy
+	}
+	if opts.SyncInterval <= 0 {
+		opts.SyncInterval = DefaultSyncInterval
+	}
+
+	file, err := os.OpenFile(opts.Path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, RecoveryInfo{}, err
+	}
+
+	w := &wal{
+		path:   opts.Path,
+		file:   file,
+		policy: opts.Sync,
+		lsn:    base,
+	}
+
+	info, err := w.replay(base, apply)
+	if err != nil {
+		file.Close()
+		return nil, info, err
+	}
+
+	if w.policy == SyncInterval {
+		w.stop = make(chan struct{})
+		w.done = make(chan struct{})
+		go w.syncLoop(opts.SyncInterval)
+	}
+
+	return w, info, nil
+}
+
+// replay applies every valid frame after the base lsn, then truncates the log right after the last one.
+func (w *wal) replay(base uint64, apply func(walRecord) error) (RecoveryInfo, error) {
+	var info RecoveryInfo
+
+	stat, err := w.file.Stat()
+	if err != nil {
+		return info, err
+	}
+
+	reader := bufio.NewReader(w.file)
+	var offset int64
+	for {
+		record, size, err := readFrame(reader)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, errCorruptFrame) {
+				return info, err
+			}
+			break
+		}
+
+		offset += size
+		if record.op == opCheckpoint {
+			if record.lsn > base {
+				return info, ErrMissingHistory
+			}
+			continue
+		}
+		if record.lsn <= base {
+			continue
+		}
+		// frames before base+1 were compacted away, and no snapshot covers them.
+		if record.lsn != w.lsn+1 {
+			return info, ErrMissingHistory
+		}
+
+		if err := apply(record); err != nil {
+			return info, err
+		}
+		info.Replayed++
+		w.lsn = record.lsn
+	}
+
+	info.DroppedBytes = stat.Size() - offset
+	if info.DroppedBytes > 0 {
+		if err := w.file.Truncate(offset); err != nil {
+			return info, err
+		}
+		if err := w.file.Sync(); err != nil {
+			return info, err
+		}
+	}
+
+	w.offset = offset
+	_, err = w.file.Seek(offset, io.SeekStart)
+	return info, err
+}
+
+func readFrame(reader io.Reader) (walRecord, int64, error) {
+	var header [frameHeaderSize]byte
+	n, err := io.ReadFull(reader, header[:])
+	if err != nil {
+		if n > 0 {
+			return walRecord{}, 0, errCorruptFrame
+		}
+		return walRecord{}, 0, io.EOF
+	}
+
+	length := binary.LittleEndian.Uint32(header[0:4])
+	checksum := binary.LittleEndian.Uint32(header[4:8])
+	if length == 0 || length > maxFrameSize {
+		return walRecord{}, 0, errCorruptFrame
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return walRecord{}, 0, errCorruptFrame
+	}
+	if crc32.Checksum(payload, crcTable) != checksum {
+		return walRecord{}, 0, errCorruptFrame
+	}
+
+	record, err := decodeRecord(payload)
+	if err != nil {
+		return walRecord{}, 0, err
+	}
+	return record, int64(frameHeaderSize) + int64(length), nil
+}
+
+// payload layout: op (1 byte) | lsn (8 bytes) | key length (uvarint) | key | value
+func encodeRecord(record walRecord) []byte {
+	payload := make([]byte, 0, 1+8+binary.MaxVarintLen64+len(record.key)+len(record.value))
+	payload = append(payload, record.op)
+	payload = binary.LittleEndian.AppendUint64(payload, record.lsn)
+	payload = binary.AppendUvarint(payload, uint64(len(record.key)))
+	payload = append(payload, record.key...)
+	payload = append(payload, record.value...)
+	return payload
+}
+
+func decodeRecord(payload []byte) (walRecord, error) {
+	if len(payload) < 9 {
+		return walRecord{}, errCorruptFrame
+	}
+
+	record := walRecord{
+		op:  payload[0],
+		lsn: binary.LittleEndian.Uint64(payload[1:9]),
+	}
+	keyLength, n := binary.Uvarint(payload[9:])
+	if n <= 0 || uint64(len(payload)-9-n) < keyLength {
+		return walRecord{}, errCorruptFrame
+	}
+	start := 9 + n
+	record.key = Key(payload[start : start+int(keyLength)])
+	record.value = payload[start+int(keyLength):]
+	return record, nil
+}
+
+func frameOf(payload []byte) []byte {
+	frame := make([]byte, frameHeaderSize, frameHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(frame[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(frame[4:8], crc32.Checksum(payload, crcTable))
+	return append(frame, payload...)
+}
+
+// batch value layout: records count (uvarint) | key length (uvarint) | key | value length (uvarint) | value ...
+func encodeBatch(records []snapshotRecord) []byte {
+	batch := binary.AppendUvarint(nil, uint64(len(records)))
+	for _, record := range records {
+		batch = binary.AppendUvarint(batch, uint64(len(record.key)))
+		batch = append(batch, record.key...)
+		batch = binary.AppendUvarint(batch, uint64(len(record.value)))
+		batch = append(batch, record.value...)
+	}
+	return batch
+}
+
+func decodeBatch(value []byte) ([]snapshotRecord, error) {
+	reader := bytes.NewReader(value)
+	count, err := binary.ReadUvarint(reader)
+	if err != nil || count > uint64(len(value)) {
+		return nil, errCorruptFrame
+	}
+
+	records := make([]snapshotRecord, 0, count)
+	for i := uint64(0); i < count; i++ {
+		key, err := readChunk(reader)
+		if err != nil {
+			return nil, errCorruptFrame
+		}
+		value, err := readChunk(reader)
+		if err != nil {
+			return nil, errCorruptFrame
+		}
+		records = append(records, snapshotRecord{key: Key(key), value: value})
+	}
+	return records, nil
+}
+
+// append writes one frame to the log and syncs it according to the policy, it returns the lsn of the frame.
+// The lsn is returned even if the sync failed, the frame was written and the lsn is taken.
+func (w *wal) append(op byte, key Key, encoded []byte) (uint64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	frame := frameOf(encodeRecord(walRecord{op: op, lsn: w.lsn + 1, key: key, value: encoded}))
+
+	if _, err := w.file.Write(frame); err != nil {
+		// don't leave a torn frame behind, later frames would be dropped with it on recovery.
+		w.file.Truncate(w.offset)
+		w.file.Seek(w.offset, io.SeekStart)
+		return 0, err
+	}
+	w.lsn++
+	w.offset += int64(len(frame))
+
+	if w.policy == SyncAlways {
+		return w.lsn, w.file.Sync()
+	}
+	w.dirty = true
+	return w.lsn, nil
+}
+
+func (w *wal) currentLSN() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.lsn
+}
+
+// compact rewrites the log without the frames at or before the given lsn.
+// Appends are blocked while the remaining frames are copied, which should be short right after a snapshot.
+func (w *wal) compact(after uint64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	source, err := os.Open(w.path)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	temp := w.path + ".tmp"
+	target, err := os.OpenFile(temp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp)
+
+	reader := bufio.NewReader(io.LimitReader(source, w.offset))
+	writer := bufio.NewWriter(target)
+	checkpoint := frameOf(encodeRecord(walRecord{op: opCheckpoint, lsn: after}))
+	writer.Write(checkpoint)
+	written := int64(len(checkpoint))
+	for {
+		record, size, err := readFrame(reader)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			target.Close()
+			return err
+		}
+		if record.op == opCheckpoint || record.lsn <= after {
+			continue
+		}
+
+		writer.Write(frameOf(encodeRecord(record)))
+		written += size
+	}
+
+	if err := writer.Flush(); err != nil {
+		target.Close()
+		return err
+	}
+	if err := target.Sync(); err != nil {
+		target.Close()
+		return err
+	}
+	if err := target.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(temp, w.path); err != nil {
+		return err
+	}
+	if err := syncDir(filepath.Dir(w.path)); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(w.path, os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Seek(written, io.SeekStart); err != nil {
+		file.Close()
+		return err
+	}
+
+	w.file.Close()
+	w.file = file
+	w.offset = written
+	w.dirty = false
+	return nil
+}
+
+func (w *wal) syncLoop(interval time.Duration) {
+	defer close(w.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			w.sync()
+		}
+	}
+}
+
+func (w *wal) sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.dirty {
+		return nil
+	}
+	w.dirty = false
+	return w.file.Sync()
+}
+
+func (w *wal) close() error {
+	if w.stop != nil {
+		close(w.stop)
+		<-w.done
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.file.Sync(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
@@ -0,0 +1,138 @@
+package memorydb_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/0xSherlokMo/banking-system-challenge/memorydb"
+)
+
+type record struct {
+	ID    string `json:"id"`
+	Value int    `json:"value"`
+}
+
+func (r *record) GetID() string {
+	return r.ID
+}
+
+func TestWALReplaysWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "records.wal")
+	opts := memorydb.WALOptions{Path: path, Sync: memorydb.SyncAlways}
+
+	db, _, err := memorydb.Open[*record](opts)
+	if err != nil {
+		t.Fatalf("cannot open log: %v", err)
+	}
+	db.Setnx("a", &record{ID: "a", Value: 1})
+	db.Setnx("b", &record{ID: "b", Value: 2})
+	db.Set("a", &record{ID: "a", Value: 3}, memorydb.Opts{Safe: memorydb.ConcurrentSafe})
+	db.Setnx("c", &record{ID: "c", Value: 4})
+	db.Delete("c", memorydb.Opts{Safe: memorydb.ConcurrentSafe})
+	if err := db.Close(); err != nil {
+		t.Fatalf("cannot close log: %v", err)
+	}
+
+	db, info, err := memorydb.Open[*record](opts)
+	if err != nil {
+		t.Fatalf("cannot reopen log: %v", err)
+	}
+	defer db.Close()
+
+	if info.Replayed != 5 || info.DroppedBytes != 0 {
+		t.Errorf("expected 5 replayed records and nothing dropped but got %+v", info)
+	}
+	a, err := db.Get("a", memorydb.Opts{Safe: memorydb.ConcurrentSafe})
+	if err != nil || a.Value != 3 {
+		t.Errorf("expected a to be recovered with its last value but got %+v %v", a, err)
+	}
+	if err := db.Setnx("b", &record{ID: "b"}); err != memorydb.ErrRecordExists {
+		t.Errorf("expected recovered record to exist but got %v", err)
+	}
+	if _, err := db.Get("c", memorydb.Opts{}); err != memorydb.ErrRecordNotFound {
+		t.Errorf("expected deleted record not to be recovered but got %v", err)
+	}
+}
+
+func TestWALReplaysTransactions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "records.wal")
+	opts := memorydb.WALOptions{Path: path, Sync: memorydb.SyncAlways}
+
+	db, _, err := memorydb.Open[*record](opts)
+	if err != nil {
+		t.Fatalf("cannot open log: %v", err)
+	}
+	db.Setnx("a", &record{ID: "a", Value: 1})
+	err = db.Update(context.Background(), func(tx memorydb.Txn[*record]) error {
+		tx.Set("a", &record{ID: "a", Value: 2})
+		return tx.Set("b", &record{ID: "b", Value: 2})
+	})
+	if err != nil {
+		t.Fatalf("cannot commit transaction: %v", err)
+	}
+	db.Close()
+
+	db, info, err := memorydb.Open[*record](opts)
+	if err != nil {
+		t.Fatalf("cannot reopen log: %v", err)
+	}
+	defer db.Close()
+
+	if info.Replayed != 2 {
+		t.Errorf("expected the transaction to be replayed as a single frame but got %+v", info)
+	}
+	for _, key := range []memorydb.Key{"a", "b"} {
+		value, err := db.Get(key, memorydb.Opts{})
+		if err != nil || value.Value != 2 {
+			t.Errorf("expected %s to be recovered from the transaction but got %+v %v", key, value, err)
+		}
+	}
+}
+
+func TestWALDropsCorruptTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "records.wal")
+	opts := memorydb.WALOptions{Path: path, Sync: memorydb.SyncNever}
+
+	db, _, _ := memorydb.Open[*record](opts)
+	db.Setnx("a", &record{ID: "a", Value: 1})
+	db.Setnx("b", &record{ID: "b", Value: 2})
+	db.Close()
+
+	stat, _ := os.Stat(path)
+	intact := stat.Size()
+
+	// a torn write: half a frame at the end of the log.
+	file, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	file.Write([]byte{42, 0, 0, 0, 1, 2, 3, 4, 5})
+	file.Close()
+
+	db, info, err := memorydb.Open[*record](opts)
+	if err != nil {
+		t.Fatalf("cannot reopen log: %v", err)
+	}
+	if info.Replayed != 2 || info.DroppedBytes != 9 {
+		t.Errorf("expected 2 replayed records and 9 dropped bytes but got %+v", info)
+	}
+	db.Close()
+
+	stat, _ = os.Stat(path)
+	if stat.Size() != intact {
+		t.Errorf("expected log to be truncated to %d bytes but got %d", intact, stat.Size())
+	}
+
+	// flip a byte of the last frame payload so its checksum doesn't match.
+	content, _ := os.ReadFile(path)
+	content[len(content)-2] ^= 0xff
+	os.WriteFile(path, content, 0o644)
+
+	db, info, err = memorydb.Open[*record](opts)
+	if err != nil {
+		t.Fatalf("cannot reopen log: %v", err)
+	}
+	defer db.Close()
+	if info.Replayed != 1 || db.Length() != 1 {
+		t.Errorf("expected only the first record to survive but got %+v with %d records", info, db.Length())
+	}
+}
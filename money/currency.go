@@ -0,0 +1,61 @@
+package money
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Currency is an ISO 4217 currency code, ex: "USD".
+type Currency string
+
+const (
+	// DefaultCurrency is the currency of the accounts that don't set one, like the challenge seed.
+	DefaultCurrency Currency = "USD"
+
+	// MaxCurrencyScale is the largest minor-unit exponent of the supported currencies.
+	// Amounts are decoded at this scale until their currency is known, then rescaled to it.
+	MaxCurrencyScale uint8 = 3
+)
+
+// ErrUnknownCurrency is returned for a code that isn't a supported ISO 4217 currency.
+var ErrUnknownCurrency = errors.New("unknown currency")
+
+// exponents holds the minor-unit exponent of the supported currencies, as listed by ISO 4217.
+var exponents = map[Currency]uint8{
+	"AED": 2, "AUD": 2, "BHD": 3, "BRL": 2, "CAD": 2, "CHF": 2, "CLP": 0, "CNY": 2,
+	"DKK": 2, "EGP": 2, "EUR": 2, "GBP": 2, "HKD": 2, "IDR": 2, "INR": 2, "IQD": 3,
+	"ISK": 0, "JOD": 3, "JPY": 0, "KRW": 0, "KWD": 3, "LYD": 3, "MAD": 2, "MXN": 2,
+	"NOK": 2, "NZD": 2, "OMR": 3, "PKR": 2, "PLN": 2, "QAR": 2, "SAR": 2, "SEK": 2,
+	"SGD": 2, "TND": 3, "TRY": 2, "UGX": 0, "USD": 2, "VND": 0, "XAF": 0, "XOF": 0,
+	"ZAR": 2,
+}
+
+// ParseCurrency returns the supported currency of the given code, in any case.
+func ParseCurrency(code string) (Currency, error) {
+	currency := Currency(strings.ToUpper(strings.TrimSpace(code)))
+	if _, known := exponents[currency]; !known {
+		return "", fmt.Errorf("%w %q", ErrUnknownCurrency, code)
+	}
+	return currency, nil
+}
+
+// Scale returns the minor-unit exponent of the currency, ex: 2 for USD, 0 for JPY and 3 for KWD.
+// DefaultScale is returned for an unknown currency.
+func (c Currency) Scale() uint8 {
+	if exponent, known := exponents[c]; known {
+		return exponent
+	}
+	return DefaultScale
+}
+
+// Amount validates an amount against the minor-unit exponent of the currency, returning it at the currency scale.
+// It fails with ErrTooPrecise when the amount has more decimal places than the currency allows.
+func (c Currency) Amount(amount Money) (Money, error) {
+	return amount.Rescale(c.Scale())
+}
+
+// Zero returns a zero amount at the currency scale.
+func (c Currency) Zero() Money {
+	return Zero(c.Scale())
+}
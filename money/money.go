@@ -0,0 +1,278 @@
+// Package money provides a fixed-point monetary amount.
+// Amounts are stored as an integer number of minor units together with the scale
+// (number of decimal places) of the currency, so arithmetic never goes through floats.
+package money
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultScale is the number of decimal places used when no currency scale is given.
+	DefaultScale uint8 = 2
+
+	// maxScale keeps 10^scale within int64.
+	maxScale uint8 = 18
+)
+
+var (
+	// ErrInvalidFormat is returned when an amount cannot be parsed as a decimal number.
+	ErrInvalidFormat = errors.New("invalid amount format")
+
+	// ErrTooPrecise is returned when an amount has more decimal places than the scale allows.
+	ErrTooPrecise = errors.New("amount has more precision than the currency allows")
+
+	// ErrOverflow is returned when an amount does not fit in the minor units range.
+	ErrOverflow = errors.New("amount overflow")
+)
+
+type Money struct {
+	units int64
+	scale uint8
+}
+
+// New returns an amount of the given minor units at the given scale.
+// ex: New(1050, 2) is 10.50
+func New(units int64, scale uint8) Money {
+	return Money{units: units, scale: scale}
+}
+
+// Zero returns a zero amount at the given scale.
+func Zero(scale uint8) Money {
+	return Money{scale: scale}
+}
+
+// Parse parses a plain decimal string (ex: "-12.5") into an amount at the given scale.
+// Exponents are not accepted, and neither are more significant decimal places than the scale.
+func Parse(s string, scale uint8) (Money, error) {
+	if scale > maxScale {
+		return Money{}, ErrOverflow
+	}
+
+	negative := false
+	switch {
+	case strings.HasPrefix(s, "-"):
+		negative = true
+		s = s[1:]
+	case strings.HasPrefix(s, "+"):
+		s = s[1:]
+	}
+
+	whole, fraction, hasPoint := strings.Cut(s, ".")
+	if whole == "" || (hasPoint && fraction == "") || !isDigits(whole) || !isDigits(fraction) {
+		return Money{}, ErrInvalidFormat
+	}
+
+	trimmed := strings.TrimRight(fraction, "0")
+	if len(trimmed) > int(scale) {
+		return Money{}, ErrTooPrecise
+	}
+	trimmed += strings.Repeat("0", int(scale)-len(trimmed))
+
+	units, err := strconv.ParseInt(whole+trimmed, 10, 64)
+	if err != nil {
+		return Money{}, ErrOverflow
+	}
+	if negative {
+		units = -units
+	}
+
+	return Money{units: units, scale: scale}, nil
+}
+
+// MustParse is like Parse with DefaultScale but panics on error. Meant for constants and tests.
+func MustParse(s string) Money {
+	m, err := Parse(s, DefaultScale)
+	if err != nil {
+		panic(fmt.Sprintf("money: cannot parse %q: %v", s, err))
+	}
+	return m
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Units returns the amount in minor units.
+func (m Money) Units() int64 {
+	return m.units
+}
+
+// Scale returns the number of decimal places of the amount.
+func (m Money) Scale() uint8 {
+	return m.scale
+}
+
+// Rescale returns the same amount expressed at a higher or equal scale.
+// Scaling down is only allowed when no precision is lost.
+func (m Money) Rescale(scale uint8) (Money, error) {
+	if scale > maxScale {
+		return Money{}, ErrOverflow
+	}
+	if scale == m.scale {
+		return m, nil
+	}
+
+	if scale < m.scale {
+		factor := pow10(m.scale - scale)
+		if m.units%factor != 0 {
+			return Money{}, ErrTooPrecise
+		}
+		return Money{units: m.units / factor, scale: scale}, nil
+	}
+
+	factor := pow10(scale - m.scale)
+	if m.units > math.MaxInt64/factor || m.units < math.MinInt64/factor {
+		return Money{}, ErrOverflow
+	}
+	return Money{units: m.units * factor, scale: scale}, nil
+}
+
+// Neg returns the amount with its sign flipped.
+func (m Money) Neg() Money {
+	return Money{units: -m.units, scale: m.scale}
+}
+
+// Sign returns -1, 0 or 1 depending on the sign of the amount.
+func (m Money) Sign() int {
+	switch {
+	case m.units < 0:
+		return -1
+	case m.units > 0:
+		return 1
+	}
+	return 0
+}
+
+func (m Money) IsZero() bool {
+	return m.units == 0
+}
+
+// Cmp compares two amounts regardless of their scales, returning -1, 0 or 1.
+func (m Money) Cmp(other Money) int {
+	a, b, err := Align(m, other)
+	if err != nil {
+		// one side overflowed while upscaling, so it is the larger in magnitude.
+		if m.scale > other.scale {
+			return other.Neg().Sign()
+		}
+		return m.Sign()
+	}
+
+	switch {
+	case a.units < b.units:
+		return -1
+	case a.units > b.units:
+		return 1
+	}
+	return 0
+}
+
+// Align rescales both amounts to the larger of their scales.
+func Align(a, b Money) (Money, Money, error) {
+	scale := a.scale
+	if b.scale > scale {
+		scale = b.scale
+	}
+
+	a, err := a.Rescale(scale)
+	if err != nil {
+		return Money{}, Money{}, err
+	}
+	b, err = b.Rescale(scale)
+	if err != nil {
+		return Money{}, Money{}, err
+	}
+	return a, b, nil
+}
+
+// String formats the amount with exactly Scale decimal places. ex: "4488.10"
+func (m Money) String() string {
+	units := m.units
+	sign := ""
+	if units < 0 {
+		sign = "-"
+	}
+
+	digits := strconv.FormatUint(absUnits(units), 10)
+	if m.scale == 0 {
+		return sign + digits
+	}
+
+	if len(digits) <= int(m.scale) {
+		digits = strings.Repeat("0", int(m.scale)-len(digits)+1) + digits
+	}
+	point := len(digits) - int(m.scale)
+	return sign + digits[:point] + "." + digits[point:]
+}
+
+// MarshalJSON encodes the amount as a JSON string, ex: "4488.10".
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(m.String())), nil
+}
+
+// UnmarshalJSON accepts both JSON strings ("10.5") and JSON numbers (10.5).
+// The value is parsed from its decimal text, never through a float.
+// Amounts are decoded at the receiver's scale when it has one, otherwise at the scale they're written with:
+// MarshalJSON writes every decimal place of the scale, so "1.234" comes back at 3 and "100" at 0.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+
+	text := string(data)
+	if strings.HasPrefix(text, `"`) {
+		unquoted, err := strconv.Unquote(text)
+		if err != nil {
+			return ErrInvalidFormat
+		}
+		text = unquoted
+	}
+
+	scale := m.scale
+	if scale == 0 {
+		scale = writtenScale(text)
+	}
+	parsed, err := Parse(text, scale)
+	if err != nil {
+		return err
+	}
+
+	*m = parsed
+	return nil
+}
+
+// writtenScale is the number of decimal places of a decimal text, capped so Parse reports it as an overflow.
+func writtenScale(text string) uint8 {
+	_, fraction, _ := strings.Cut(text, ".")
+	if len(fraction) > int(maxScale) {
+		return maxScale + 1
+	}
+	return uint8(len(fraction))
+}
+
+func pow10(n uint8) int64 {
+	result := int64(1)
+	for i := uint8(0); i < n; i++ {
+		result *= 10
+	}
+	return result
+}
+
+func absUnits(units int64) uint64 {
+	if units < 0 {
+		return uint64(-(units + 1)) + 1
+	}
+	return uint64(units)
+}
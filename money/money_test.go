@@ -0,0 +1,131 @@
+package money_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/0xSherlokMo/banking-system-challenge/money"
+)
+
+func TestParse(t *testing.T) {
+	tt := []struct {
+		input    string
+		scale    uint8
+		expected string
+		err      error
+	}{
+		{input: "4488.1", scale: 2, expected: "4488.10"},
+		{input: "365.09", scale: 2, expected: "365.09"},
+		{input: "-0.5", scale: 2, expected: "-0.50"},
+		{input: "10", scale: 0, expected: "10"},
+		{input: "10.120", scale: 2, expected: "10.12"},
+		{input: "0.001", scale: 3, expected: "0.001"},
+		{input: "10.123", scale: 2, err: money.ErrTooPrecise},
+		{input: "1.5", scale: 0, err: money.ErrTooPrecise},
+		{input: "1e3", scale: 2, err: money.ErrInvalidFormat},
+		{input: "12.", scale: 2, err: money.ErrInvalidFormat},
+		{input: "", scale: 2, err: money.ErrInvalidFormat},
+		{input: "99999999999999999999", scale: 2, err: money.ErrOverflow},
+	}
+
+	for _, tc := range tt {
+		m, err := money.Parse(tc.input, tc.scale)
+		if tc.err != nil {
+			if !errors.Is(err, tc.err) {
+				t.Errorf("parse %q: expected error %v but got %v", tc.input, tc.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parse %q: unexpected error %v", tc.input, err)
+			continue
+		}
+		if m.String() != tc.expected {
+			t.Errorf("parse %q: expected %s but got %s", tc.input, tc.expected, m.String())
+		}
+	}
+}
+
+func TestJSON(t *testing.T) {
+	request := struct {
+		Amount  money.Money `json:"amount"`
+		Balance money.Money `json:"balance"`
+	}{Amount: money.Zero(2), Balance: money.Zero(2)}
+	err := json.Unmarshal([]byte(`{"amount": 10.1, "balance": "0.30"}`), &request)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if request.Amount.Units() != 1010 || request.Balance.Units() != 30 {
+		t.Errorf("unexpected amounts %s %s", request.Amount, request.Balance)
+	}
+
+	encoded, _ := json.Marshal(request)
+	if string(encoded) != `{"amount":"10.10","balance":"0.30"}` {
+		t.Errorf("unexpected encoding %s", encoded)
+	}
+
+	err = json.Unmarshal([]byte(`{"amount": 0.001}`), &request)
+	if !errors.Is(err, money.ErrTooPrecise) {
+		t.Errorf("expected ErrTooPrecise but got %v", err)
+	}
+
+	// without a scale, amounts keep the scale they're written with so they round trip whatever their currency.
+	for _, written := range []string{`"1.234"`, `"100"`, `"0.50"`, `"-7.000"`} {
+		var decoded money.Money
+		if err := json.Unmarshal([]byte(written), &decoded); err != nil {
+			t.Errorf("%s: unexpected error %v", written, err)
+			continue
+		}
+		if encoded, _ := json.Marshal(decoded); string(encoded) != written {
+			t.Errorf("expected %s to round trip but got %s", written, encoded)
+		}
+	}
+	var decoded money.Money
+	if err := json.Unmarshal([]byte(`"0.0000000000000000001"`), &decoded); !errors.Is(err, money.ErrOverflow) {
+		t.Errorf("expected ErrOverflow but got %v", err)
+	}
+}
+
+func TestCmp(t *testing.T) {
+	if money.New(100, 2).Cmp(money.New(1, 0)) != 0 {
+		t.Errorf("expected 1.00 to equal 1")
+	}
+	if money.New(101, 2).Cmp(money.New(1, 0)) != 1 {
+		t.Errorf("expected 1.01 to be greater than 1")
+	}
+	if money.New(-1, 3).Cmp(money.Zero(2)) != -1 {
+		t.Errorf("expected -0.001 to be less than 0")
+	}
+}
+
+func TestCurrency(t *testing.T) {
+	cases := []struct {
+		code     string
+		amount   string
+		expected string
+		err      error
+	}{
+		{"usd", "10.5", "10.50", nil},
+		{"JPY", "1000", "1000", nil},
+		{"JPY", "10.5", "", money.ErrTooPrecise},
+		{"KWD", "1.234", "1.234", nil},
+		{"KWD", "1.2345", "", money.ErrTooPrecise},
+		{"XYZ", "1", "", money.ErrUnknownCurrency},
+	}
+
+	for _, c := range cases {
+		currency, err := money.ParseCurrency(c.code)
+		var amount money.Money
+		if err == nil {
+			// amounts are decoded at the largest exponent before their currency is known.
+			amount, err = money.Parse(c.amount, money.MaxCurrencyScale)
+		}
+		if err == nil {
+			amount, err = currency.Amount(amount)
+		}
+		if !errors.Is(err, c.err) || (err == nil && amount.String() != c.expected) {
+			t.Errorf("%s %s: expected %q %v but got %q %v", c.code, c.amount, c.expected, c.err, amount, err)
+		}
+	}
+}
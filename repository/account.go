@@ -1,10 +1,16 @@
 package repository
 
 import (
+	"context"
+	"time"
+
 	"github.com/0xSherlokMo/banking-system-challenge/account"
 	"github.com/0xSherlokMo/banking-system-challenge/calculator"
 	"github.com/0xSherlokMo/banking-system-challenge/ctx"
+	"github.com/0xSherlokMo/banking-system-challenge/ledger"
 	"github.com/0xSherlokMo/banking-system-challenge/memorydb"
+	"github.com/0xSherlokMo/banking-system-challenge/money"
+	"github.com/0xSherlokMo/banking-system-challenge/transaction"
 )
 
 type AccountRepository struct {
@@ -17,16 +23,22 @@ func NewAccountRepository(ctx *ctx.DefaultContext) *AccountRepository {
 	}
 }
 
-type LockReleaser = func()
-
-func (a *AccountRepository) All(safe bool) []*account.Account {
-	database := a.ctx.MemoryDB()
-	accounts := database.GetM(database.Keys(), memorydb.Opts{
-		Safe: safe,
+// All returns every account as of a single commit version, so the balances always add up
+// even while transfers are running. It doesn't wait for any account lock.
+func (a *AccountRepository) All() []*account.Account {
+	var accounts []*account.Account
+	a.ctx.MemoryDB().View(func(tx memorydb.ReadTxn[*account.Account]) error {
+		accounts = tx.GetM(tx.Keys())
+		return nil
 	})
 	return accounts
 }
 
+// GetWithVersion returns the account along with its version, the one a conditional transfer expects.
+func (a *AccountRepository) GetWithVersion(key memorydb.Key) (*account.Account, uint64, error) {
+	return a.ctx.MemoryDB().GetWithVersion(key)
+}
+
 func (a *AccountRepository) GetByKey(key memorydb.Key, safe bool) (*account.Account, error) {
 	database := a.ctx.MemoryDB()
 	account, err := database.Get(key, memorydb.Opts{
@@ -38,77 +50,458 @@ func (a *AccountRepository) GetByKey(key memorydb.Key, safe bool) (*account.Acco
 	return account, nil
 }
 
-// !!! This method is not thread safe !!!
-// You should use PrepareAccounts, Commit and Rollback methods to make it thread safe.
-func (a *AccountRepository) TransferMoney(request account.TransferRequest) (*account.Account, error) {
-	database := a.ctx.MemoryDB()
-	senderAccount, err := database.Get(request.Sender, memorydb.Opts{
-		Safe: memorydb.ConcurrentNotSafe,
-	})
+// Create stores a new account and records its opening balance in the ledger.
+// It fails with account.ErrDuplicateRef if its external reference is used by another account.
+// The account is removed again if its opening entry cannot be recorded.
+func (a *AccountRepository) Create(opening *account.Account) error {
+	_, done, err := a.ctx.Track(context.Background())
 	if err != nil {
-		a.ctx.Logger().Errorw("account locked but doesn't exist", "account", request.Sender)
-		return nil, err
+		return err
 	}
-	receiverAccount, err := database.Get(request.Reciever, memorydb.Opts{
-		Safe: memorydb.ConcurrentNotSafe,
-	})
+	defer done()
+
+	key := opening.GetID()
+	if opening.ExternalRef != "" {
+		if err := a.ctx.ReserveReference(opening.ExternalRef, key); err != nil {
+			return err
+		}
+	}
+	if err := a.ctx.MemoryDB().Setnx(key, opening); err != nil {
+		if opening.ExternalRef != "" {
+			a.ctx.ReleaseReference(opening.ExternalRef, key)
+		}
+		return err
+	}
+
+	err = a.ctx.Ledger().Record(ledger.NewOpeningEntry(key, opening.Denomination(), opening.Balance))
 	if err != nil {
-		a.ctx.Logger().Errorw("account locked but doesn't exist", "account", request.Reciever)
-		return nil, err
+		if deleteErr := a.ctx.MemoryDB().Delete(key, memorydb.Opts{Safe: memorydb.ConcurrentSafe}); deleteErr != nil {
+			a.ctx.Logger().Errorw("cannot roll back account", "account", key, "error", deleteErr)
+		}
+		if opening.ExternalRef != "" {
+			a.ctx.ReleaseReference(opening.ExternalRef, key)
+		}
+		return err
 	}
+	return nil
+}
 
-	if err := request.ValidateAmount(senderAccount); err != nil {
-		a.ctx.Logger().Debugw("invalid amount", "request", request, "error", err)
-		return nil, err
+// TransferMoney moves the amount between the accounts in a single database transaction,
+// waiting for the account locks until lockCtx is done.
+// Every transfer between existing accounts is recorded as a transaction, including rejected ones.
+func (a *AccountRepository) TransferMoney(lockCtx context.Context, request account.TransferRequest) (*account.Account, *transaction.Transaction, error) {
+	if request.Sender == request.Reciever {
+		return nil, nil, account.ErrSameAccount
 	}
+	// the storage isn't closed on exit until the transfer is committed or rolled back.
+	lockCtx, done, err := a.ctx.Track(lockCtx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer done()
 
-	senderAccount.Balance = calculator.PreciseAdd(senderAccount.Balance, -request.Amount)
-	database.Set(request.Sender, senderAccount, memorydb.Opts{Safe: memorydb.ConcurrentNotSafe})
-	receiverAccount.Balance = calculator.PreciseAdd(receiverAccount.Balance, request.Amount)
-	database.Set(request.Reciever, receiverAccount, memorydb.Opts{Safe: memorydb.ConcurrentNotSafe})
+	var (
+		record *transaction.Transaction
+		entry  *ledger.Entry
+		sender account.Account
+	)
+	err = a.ctx.MemoryDB().Update(lockCtx, func(tx memorydb.Txn[*account.Account]) error {
+		err := tx.Lock(request.Sender, request.Reciever)
+		if err != nil {
+			a.ctx.Logger().Debugw("cannot lock accounts", "request", request, "error", err)
+			return err
+		}
 
-	return senderAccount, nil
-}
+		if err := checkVersion(tx, request.Sender, request.SenderVersion); err != nil {
+			return err
+		}
 
-func (a *AccountRepository) PrepareAccounts(keys ...memorydb.Key) error {
-	database := a.ctx.MemoryDB()
-	var releasers []LockReleaser
-	for _, key := range keys {
-		err := database.Lock(key)
+		senderAccount, err := tx.Get(request.Sender)
+		if err != nil {
+			return err
+		}
+		receiverAccount, err := tx.Get(request.Reciever)
+		if err != nil {
+			return err
+		}
+
+		record = transaction.NewTransaction(senderAccount.ID, receiverAccount.ID, request.Amount)
+		record.Currency = senderAccount.Denomination()
+
+		if senderAccount.Closed() || receiverAccount.Closed() {
+			return account.ErrAccountClosed
+		}
+		if senderAccount.Denomination() != receiverAccount.Denomination() && !request.Converts() {
+			return account.ErrCurrencyMismatch
+		}
+		request.Amount, err = senderAccount.Denomination().Amount(request.Amount)
+		if err != nil {
+			return err
+		}
+		record.Amount = request.Amount
+		if err := request.ValidateAmount(senderAccount); err != nil {
+			a.ctx.Logger().Debugw("invalid amount", "request", request, "error", err)
+			return err
+		}
+
+		// the receiver is credited the converted amount, both legs are kept on the record.
+		// a quote is checked even between accounts in the same currency, it can't be for their pair.
+		credited := request.Amount
+		if senderAccount.Denomination() != receiverAccount.Denomination() || request.QuoteID != "" {
+			conversion, err := a.ctx.FX().Convert(request.Amount, senderAccount.Denomination(), receiverAccount.Denomination(), request.QuoteID)
+			if err != nil {
+				a.ctx.Logger().Debugw("cannot convert amount", "request", request, "error", err)
+				return err
+			}
+			record.Conversion = &conversion
+			credited = conversion.Target.Amount
+		}
+
+		senderBalance, err := calculator.PreciseSub(senderAccount.Balance, request.Amount)
+		if err != nil {
+			a.ctx.Logger().Errorw("cannot debit sender", "request", request, "error", err)
+			return err
+		}
+		receiverBalance, err := calculator.PreciseAdd(receiverAccount.Balance, credited)
 		if err != nil {
-			a.ctx.Logger().Errorw("Cannot lock account", "account", key, "error", err)
-			go func() {
-				for _, releaser := range releasers {
-					releaser()
-				}
-			}()
+			a.ctx.Logger().Errorw("cannot credit receiver", "request", request, "error", err)
 			return err
 		}
 
-		releasers = append(releasers, func() {
-			a.Rollback(key)
-		})
+		// stored accounts are shared with readers, so the updated balances are set on copies.
+		sender = *senderAccount
+		sender.Balance = senderBalance
+		receiver := *receiverAccount
+		receiver.Balance = receiverBalance
+		if err := tx.Set(request.Sender, &sender); err != nil {
+			return err
+		}
+		if err := tx.Set(request.Reciever, &receiver); err != nil {
+			return err
+		}
+
+		// the journal entry is written last, so a balance never moves without a posting behind it.
+		entry = ledger.NewTransferEntry(request.Sender, request.Reciever, senderAccount.Denomination(), request.Amount)
+		if record.Conversion != nil {
+			entry = ledger.NewConversionEntry(
+				request.Sender, senderAccount.Denomination(), request.Amount,
+				request.Reciever, receiverAccount.Denomination(), credited,
+			)
+		}
+		err = a.ctx.Ledger().Record(entry)
+		if err != nil {
+			a.ctx.Logger().Errorw("cannot record journal entry", "request", request, "error", err)
+			entry = nil
+		}
+		return err
+	})
+	if err != nil {
+		if record == nil {
+			// one of the accounts is locked, doesn't exist or the sender changed.
+			return nil, nil, err
+		}
+		if entry != nil {
+			a.reverse(entry)
+		}
+		// the quote is kept for a retry when the transfer failed after it was converted.
+		if record.Conversion != nil && request.QuoteID != "" {
+			a.ctx.FX().Release(request.QuoteID)
+		}
+		return nil, a.fail(record, err), err
+	}
+	if request.QuoteID != "" {
+		a.ctx.FX().Consume(request.QuoteID)
 	}
 
-	return nil
+	record.Complete(entry.ID)
+	err = a.ctx.Transactions().Record(record)
+	if err != nil {
+		a.ctx.Logger().Errorw("cannot record transaction", "transaction", record, "error", err)
+	}
+
+	return &sender, record, nil
 }
 
-// added for readability
-func (a *AccountRepository) Rollback(keys ...memorydb.Key) {
-	a.unlock(keys...)
+// Deposit credits the account with money coming from outside the bank, funded by the external funds ledger account.
+// The account is locked and validated like the receiver of a transfer, and the deposit is recorded as a transaction
+// along with its counterparty, including a rejected one.
+func (a *AccountRepository) Deposit(lockCtx context.Context, request account.ExternalRequest) (*account.Account, *transaction.Transaction, error) {
+	return a.moveExternal(lockCtx, request, transaction.KindDeposit)
 }
 
-// added for readability
-func (a *AccountRepository) Commit(keys ...memorydb.Key) {
-	a.unlock(keys...)
+// Withdraw debits the account with money leaving the bank, like Deposit but validated like the sender of a transfer:
+// it fails with account.ErrInsufficientFunds when the balance doesn't cover the amount.
+func (a *AccountRepository) Withdraw(lockCtx context.Context, request account.ExternalRequest) (*account.Account, *transaction.Transaction, error) {
+	return a.moveExternal(lockCtx, request, transaction.KindWithdrawal)
 }
 
-func (a *AccountRepository) unlock(keys ...memorydb.Key) {
-	database := a.ctx.MemoryDB()
-	for _, key := range keys {
-		err := database.Unlock(key)
+func (a *AccountRepository) moveExternal(lockCtx context.Context, request account.ExternalRequest, kind transaction.Kind) (*account.Account, *transaction.Transaction, error) {
+	lockCtx, done, err := a.ctx.Track(lockCtx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer done()
+
+	var (
+		record *transaction.Transaction
+		entry  *ledger.Entry
+		moved  account.Account
+	)
+	err = a.ctx.MemoryDB().Update(lockCtx, func(tx memorydb.Txn[*account.Account]) error {
+		err := tx.Lock(request.Account)
+		if err != nil {
+			a.ctx.Logger().Debugw("cannot lock account", "request", request, "error", err)
+			return err
+		}
+		if err := checkVersion(tx, request.Account, request.Version); err != nil {
+			return err
+		}
+
+		owner, err := tx.Get(request.Account)
+		if err != nil {
+			return err
+		}
+
+		external := transaction.External{Reference: request.Reference, Channel: request.Channel, Memo: request.Memo}
+		var (
+			balance  money.Money
+			newEntry func(string, money.Currency, money.Money) *ledger.Entry
+		)
+		if kind == transaction.KindDeposit {
+			record = transaction.NewDeposit(owner.ID, request.Amount, external)
+		} else {
+			record = transaction.NewWithdrawal(owner.ID, request.Amount, external)
+		}
+		record.Currency = owner.Denomination()
+		if owner.Closed() {
+			return account.ErrAccountClosed
+		}
+		request.Amount, err = owner.Denomination().Amount(request.Amount)
+		if err != nil {
+			return err
+		}
+		record.Amount = request.Amount
+
+		if kind == transaction.KindDeposit {
+			if err := request.ValidateDeposit(); err != nil {
+				return err
+			}
+			balance, err = calculator.PreciseAdd(owner.Balance, request.Amount)
+			newEntry = ledger.NewDepositEntry
+		} else {
+			if err := request.ValidateWithdrawal(owner); err != nil {
+				a.ctx.Logger().Debugw("invalid amount", "request", request, "error", err)
+				return err
+			}
+			balance, err = calculator.PreciseSub(owner.Balance, request.Amount)
+			newEntry = ledger.NewWithdrawalEntry
+		}
+		if err != nil {
+			a.ctx.Logger().Errorw("cannot update balance", "request", request, "kind", kind, "error", err)
+			return err
+		}
+
+		moved = *owner
+		moved.Balance = balance
+		if err := tx.Set(request.Account, &moved); err != nil {
+			return err
+		}
+
+		entry = newEntry(request.Account, owner.Denomination(), request.Amount)
+		err = a.ctx.Ledger().Record(entry)
+		if err != nil {
+			a.ctx.Logger().Errorw("cannot record journal entry", "request", request, "kind", kind, "error", err)
+			entry = nil
+		}
+		return err
+	})
+	if err != nil {
+		if record == nil {
+			// the account is locked or doesn't exist.
+			return nil, nil, err
+		}
+		if entry != nil {
+			a.reverse(entry)
+		}
+		return nil, a.fail(record, err), err
+	}
+
+	record.Complete(entry.ID)
+	err = a.ctx.Transactions().Record(record)
+	if err != nil {
+		a.ctx.Logger().Errorw("cannot record transaction", "transaction", record, "error", err)
+	}
+
+	return &moved, record, nil
+}
+
+// CloseAccount closes the account, so it can't send or receive money anymore. It stays readable along with its history.
+// An account with money left is only closed if sweepTo is set: its balance is then transferred to the sweepTo account,
+// and recorded as a transaction like any other transfer. A negative balance can't be swept.
+// A non-zero version is the version the account must still be at, like the sender version of a transfer.
+func (a *AccountRepository) CloseAccount(lockCtx context.Context, key memorydb.Key, sweepTo memorydb.Key, version uint64) (*account.Account, *transaction.Transaction, error) {
+	if key == sweepTo {
+		return nil, nil, account.ErrSameAccount
+	}
+	lockCtx, done, err := a.ctx.Track(lockCtx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer done()
+
+	var (
+		record *transaction.Transaction
+		entry  *ledger.Entry
+		closed account.Account
+	)
+	err = a.ctx.MemoryDB().Update(lockCtx, func(tx memorydb.Txn[*account.Account]) error {
+		keys := []memorydb.Key{key}
+		if sweepTo != "" {
+			keys = append(keys, sweepTo)
+		}
+		err := tx.Lock(keys...)
+		if err != nil {
+			a.ctx.Logger().Debugw("cannot lock accounts", "account", key, "sweep_to", sweepTo, "error", err)
+			return err
+		}
+		if err := checkVersion(tx, key, version); err != nil {
+			return err
+		}
+
+		owner, err := tx.Get(key)
+		if err != nil {
+			return err
+		}
+		if owner.Closed() {
+			return account.ErrAccountClosed
+		}
+
+		closedAt := time.Now().UTC()
+		closed = *owner
+		closed.ClosedAt = &closedAt
+		if owner.Balance.IsZero() {
+			return tx.Set(key, &closed)
+		}
+		if sweepTo == "" || owner.Balance.Sign() < 0 {
+			return account.ErrNonZeroBalance
+		}
+
+		beneficiary, err := tx.Get(sweepTo)
+		if err != nil {
+			return err
+		}
+		record = transaction.NewTransaction(owner.ID, beneficiary.ID, owner.Balance)
+		record.Currency = owner.Denomination()
+		if beneficiary.Closed() {
+			return account.ErrAccountClosed
+		}
+		if beneficiary.Denomination() != owner.Denomination() {
+			return account.ErrCurrencyMismatch
+		}
+
+		beneficiaryBalance, err := calculator.PreciseAdd(beneficiary.Balance, owner.Balance)
+		if err != nil {
+			a.ctx.Logger().Errorw("cannot credit sweep account", "account", key, "sweep_to", sweepTo, "error", err)
+			return err
+		}
+
+		swept := *beneficiary
+		swept.Balance = beneficiaryBalance
+		closed.Balance = money.Zero(owner.Balance.Scale())
+		if err := tx.Set(key, &closed); err != nil {
+			return err
+		}
+		if err := tx.Set(sweepTo, &swept); err != nil {
+			return err
+		}
+
+		entry = ledger.NewTransferEntry(key, sweepTo, owner.Denomination(), owner.Balance)
+		err = a.ctx.Ledger().Record(entry)
 		if err != nil {
-			a.ctx.Logger().Errorw("Cannot unlock account", "account", key, "error", err)
+			a.ctx.Logger().Errorw("cannot record journal entry", "account", key, "sweep_to", sweepTo, "error", err)
+			entry = nil
 		}
+		return err
+	})
+	if err != nil {
+		if record == nil {
+			return nil, nil, err
+		}
+		if entry != nil {
+			a.reverse(entry)
+		}
+		return nil, a.fail(record, err), err
 	}
+
+	if record != nil {
+		record.Complete(entry.ID)
+		err = a.ctx.Transactions().Record(record)
+		if err != nil {
+			a.ctx.Logger().Errorw("cannot record transaction", "transaction", record, "error", err)
+		}
+	}
+
+	return &closed, record, nil
+}
+
+// reverse cancels a journal entry whose balances couldn't be committed.
+func (a *AccountRepository) reverse(entry *ledger.Entry) {
+	err := a.ctx.Ledger().Record(ledger.NewReversalEntry(entry))
+	if err != nil {
+		a.ctx.Logger().Errorw("cannot reverse journal entry", "entry", entry.ID, "error", err)
+	}
+}
+
+func (a *AccountRepository) fail(record *transaction.Transaction, reason error) *transaction.Transaction {
+	record.Fail(reason)
+	err := a.ctx.Transactions().Record(record)
+	if err != nil {
+		a.ctx.Logger().Errorw("cannot record transaction", "transaction", record, "error", err)
+	}
+	return record
+}
+
+// Reconcile checks the cached balance of every account against the balance derived from the ledger postings.
+func (a *AccountRepository) Reconcile() ([]ledger.Mismatch, error) {
+	cached := make(map[string]money.Money)
+	for _, account := range a.All() {
+		cached[account.GetID()] = account.Balance
+	}
+	return a.ctx.Ledger().Reconcile(cached)
+}
+
+// Version returns the latest commit version of the accounts.
+func (a *AccountRepository) Version() uint64 {
+	return a.ctx.MemoryDB().Version()
+}
+
+// Watch streams the changes of the account with the given key, or of every account if it's empty,
+// committed after the given version. 0 only streams new changes.
+func (a *AccountRepository) Watch(watchCtx context.Context, key memorydb.Key, fromVersion uint64) (memorydb.Subscription[*account.Account], error) {
+	prefix := key
+	if prefix == "" {
+		prefix = account.AccountIdPrefix
+	}
+	return a.ctx.MemoryDB().Watch(watchCtx, prefix, memorydb.WatchOptions{FromVersion: fromVersion})
+}
+
+// Entries returns the journal entries posted against the given account.
+func (a *AccountRepository) Entries(key memorydb.Key) []*ledger.Entry {
+	return a.ctx.Ledger().Entries(key)
+}
+
+// checkVersion fails with memorydb.ErrVersionMismatch when the locked account isn't at the expected version anymore,
+// 0 expects any version.
+func checkVersion(tx memorydb.Txn[*account.Account], key memorydb.Key, expected uint64) error {
+	if expected == 0 {
+		return nil
+	}
+	_, version, err := tx.GetWithVersion(key)
+	if err != nil {
+		return err
+	}
+	if version != expected {
+		return memorydb.ErrVersionMismatch
+	}
+	return nil
 }
@@ -1,13 +1,18 @@
 package repository_test
 
 import (
+	"context"
+	"errors"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/0xSherlokMo/banking-system-challenge/account"
 	"github.com/0xSherlokMo/banking-system-challenge/ctx"
+	"github.com/0xSherlokMo/banking-system-challenge/fx"
+	"github.com/0xSherlokMo/banking-system-challenge/ledger"
 	"github.com/0xSherlokMo/banking-system-challenge/memorydb"
+	"github.com/0xSherlokMo/banking-system-challenge/money"
 	"github.com/0xSherlokMo/banking-system-challenge/repository"
 )
 
@@ -20,13 +25,13 @@ const (
 
 type MoneyTransferOperation struct {
 	Direction TransferTestDirection
-	Amount    float64
+	Amount    money.Money
 }
 
-func NewMoneyTransferOperation(direction TransferTestDirection, amount float64) MoneyTransferOperation {
+func NewMoneyTransferOperation(direction TransferTestDirection, amount string) MoneyTransferOperation {
 	return MoneyTransferOperation{
 		Direction: direction,
-		Amount:    amount,
+		Amount:    money.MustParse(amount),
 	}
 }
 
@@ -34,7 +39,7 @@ type MoneyTransferTest struct {
 	FirstAccount    *account.Account
 	SecondAccount   *account.Account
 	operations      []MoneyTransferOperation
-	expectedAmounts []float64
+	expectedAmounts []money.Money
 }
 
 func TestMoneyTransfer(t *testing.T) {
@@ -49,12 +54,6 @@ func TestMoneyTransfer(t *testing.T) {
 				backoffInMillis := 10
 				// should add circuit breaker here but skipped for simplicity
 				for {
-					err := repositoryMock.PrepareAccounts(tc.FirstAccount.GetID(), tc.SecondAccount.GetID())
-					if err != nil {
-						time.Sleep(time.Duration(backoffInMillis) * time.Millisecond)
-						backoffInMillis *= 2
-						continue
-					}
 					request := account.TransferRequest{
 						Amount: operation.Amount,
 					}
@@ -66,14 +65,14 @@ func TestMoneyTransfer(t *testing.T) {
 						request.Sender = tc.SecondAccount.GetID()
 						request.Reciever = tc.FirstAccount.GetID()
 					}
-					_, err = repositoryMock.TransferMoney(request)
+					lockCtx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+					_, _, err := repositoryMock.TransferMoney(lockCtx, request)
+					cancel()
 					if err != nil {
-						repositoryMock.Rollback(tc.FirstAccount.GetID(), tc.SecondAccount.GetID())
 						time.Sleep(time.Duration(backoffInMillis) * time.Millisecond)
 						backoffInMillis *= 2
 						continue
 					}
-					repositoryMock.Commit(tc.FirstAccount.GetID(), tc.SecondAccount.GetID())
 					break
 				}
 			}(o)
@@ -81,89 +80,194 @@ func TestMoneyTransfer(t *testing.T) {
 		wg.Wait()
 		firstAccount, _ := repositoryMock.GetByKey(tc.FirstAccount.GetID(), memorydb.ConcurrentSafe)
 		secondAccount, _ := repositoryMock.GetByKey(tc.SecondAccount.GetID(), memorydb.ConcurrentSafe)
-		if firstAccount.Balance != tc.expectedAmounts[0] {
-			t.Errorf("expected first account to have %f but got %f, account %+v, tc %d", tc.expectedAmounts[0], firstAccount.Balance, firstAccount, id)
+		if firstAccount.Balance.Cmp(tc.expectedAmounts[0]) != 0 {
+			t.Errorf("expected first account to have %s but got %s, account %+v, tc %d", tc.expectedAmounts[0], firstAccount.Balance, firstAccount, id)
 		}
-		if secondAccount.Balance != tc.expectedAmounts[1] {
-			t.Errorf("expected second account to have %f but got %f, account %+v, tc %d", tc.expectedAmounts[1], secondAccount.Balance, secondAccount, id)
+		if secondAccount.Balance.Cmp(tc.expectedAmounts[1]) != 0 {
+			t.Errorf("expected second account to have %s but got %s, account %+v, tc %d", tc.expectedAmounts[1], secondAccount.Balance, secondAccount, id)
 		}
 	}
+
+	mismatches, err := repositoryMock.Reconcile()
+	if err != nil {
+		t.Fatalf("cannot reconcile ledger: %v", err)
+	}
+	if len(mismatches) != 0 {
+		t.Errorf("expected ledger to match account balances but got %+v", mismatches)
+	}
 }
 
 func LoadMoneyTransferTestTable() (*ctx.DefaultContext, []MoneyTransferTest) {
-	ctx := ctx.NewDefaultContext().WithMemoryDB()
+	ctx := ctx.NewDefaultContext().WithMemoryDB().WithLedger().WithTransactions()
 
 	testTable := []MoneyTransferTest{
 		{
-			FirstAccount:  account.NewAccount("mario", 100),
-			SecondAccount: account.NewAccount("jack", 0),
+			FirstAccount:  account.NewAccount("mario", money.DefaultCurrency, money.MustParse("100")),
+			SecondAccount: account.NewAccount("jack", money.DefaultCurrency, money.MustParse("0")),
 			operations: []MoneyTransferOperation{
-				NewMoneyTransferOperation(FromFirstToSecond, 50),
-				NewMoneyTransferOperation(FromSecondToFirst, 50),
-				NewMoneyTransferOperation(FromFirstToSecond, 10),
-				NewMoneyTransferOperation(FromFirstToSecond, 10),
-				NewMoneyTransferOperation(FromSecondToFirst, 20),
+				NewMoneyTransferOperation(FromFirstToSecond, "50"),
+				NewMoneyTransferOperation(FromSecondToFirst, "50"),
+				NewMoneyTransferOperation(FromFirstToSecond, "10"),
+				NewMoneyTransferOperation(FromFirstToSecond, "10"),
+				NewMoneyTransferOperation(FromSecondToFirst, "20"),
 			},
-			expectedAmounts: []float64{
-				100,
-				0,
+			expectedAmounts: []money.Money{
+				money.MustParse("100"),
+				money.MustParse("0"),
 			},
 		},
 		{
-			FirstAccount:  account.NewAccount("hello-kitty", 100),
-			SecondAccount: account.NewAccount("super-mario", 0),
+			FirstAccount:  account.NewAccount("hello-kitty", money.DefaultCurrency, money.MustParse("100")),
+			SecondAccount: account.NewAccount("super-mario", money.DefaultCurrency, money.MustParse("0")),
 			operations: []MoneyTransferOperation{
-				NewMoneyTransferOperation(FromFirstToSecond, 20),
-				NewMoneyTransferOperation(FromFirstToSecond, 5),
-				NewMoneyTransferOperation(FromSecondToFirst, 25),
+				NewMoneyTransferOperation(FromFirstToSecond, "20"),
+				NewMoneyTransferOperation(FromFirstToSecond, "5"),
+				NewMoneyTransferOperation(FromSecondToFirst, "25"),
 			},
-			expectedAmounts: []float64{
-				100,
-				0,
+			expectedAmounts: []money.Money{
+				money.MustParse("100"),
+				money.MustParse("0"),
 			},
 		},
 		{
 			// evil guy trying to double his money by transferring money to his friend
-			FirstAccount:  account.NewAccount("evil-guy", 100),
-			SecondAccount: account.NewAccount("friend-to-evil-guy", 100),
+			FirstAccount:  account.NewAccount("evil-guy", money.DefaultCurrency, money.MustParse("100")),
+			SecondAccount: account.NewAccount("friend-to-evil-guy", money.DefaultCurrency, money.MustParse("100")),
 			operations: []MoneyTransferOperation{
-				NewMoneyTransferOperation(FromFirstToSecond, 100),
-				NewMoneyTransferOperation(FromSecondToFirst, 100),
-				NewMoneyTransferOperation(FromFirstToSecond, 100),
-				NewMoneyTransferOperation(FromSecondToFirst, 100),
-				NewMoneyTransferOperation(FromFirstToSecond, 100),
-				NewMoneyTransferOperation(FromSecondToFirst, 100),
-				NewMoneyTransferOperation(FromFirstToSecond, 100),
-				NewMoneyTransferOperation(FromSecondToFirst, 100),
+				NewMoneyTransferOperation(FromFirstToSecond, "100"),
+				NewMoneyTransferOperation(FromSecondToFirst, "100"),
+				NewMoneyTransferOperation(FromFirstToSecond, "100"),
+				NewMoneyTransferOperation(FromSecondToFirst, "100"),
+				NewMoneyTransferOperation(FromFirstToSecond, "100"),
+				NewMoneyTransferOperation(FromSecondToFirst, "100"),
+				NewMoneyTransferOperation(FromFirstToSecond, "100"),
+				NewMoneyTransferOperation(FromSecondToFirst, "100"),
 			},
-			expectedAmounts: []float64{
-				100,
-				100,
+			expectedAmounts: []money.Money{
+				money.MustParse("100"),
+				money.MustParse("100"),
 			},
 		},
 		{
 			// lucky man trying to make poor guy have negative balance
-			FirstAccount:  account.NewAccount("lucky-guy", 100),
-			SecondAccount: account.NewAccount("poor-guy", 1),
+			FirstAccount:  account.NewAccount("lucky-guy", money.DefaultCurrency, money.MustParse("100")),
+			SecondAccount: account.NewAccount("poor-guy", money.DefaultCurrency, money.MustParse("1")),
 			operations: []MoneyTransferOperation{
-				NewMoneyTransferOperation(FromSecondToFirst, 99),
-				NewMoneyTransferOperation(FromFirstToSecond, 99),
-				NewMoneyTransferOperation(FromSecondToFirst, 1),
-				NewMoneyTransferOperation(FromFirstToSecond, 1),
-				NewMoneyTransferOperation(FromSecondToFirst, 1),
+				NewMoneyTransferOperation(FromSecondToFirst, "99"),
+				NewMoneyTransferOperation(FromFirstToSecond, "99"),
+				NewMoneyTransferOperation(FromSecondToFirst, "1"),
+				NewMoneyTransferOperation(FromFirstToSecond, "1"),
+				NewMoneyTransferOperation(FromSecondToFirst, "1"),
 			},
-			expectedAmounts: []float64{
-				101,
-				0,
+			expectedAmounts: []money.Money{
+				money.MustParse("101"),
+				money.MustParse("0"),
 			},
 		},
 	}
 
 	db := ctx.MemoryDB()
 	for _, tc := range testTable {
-		db.Setnx(tc.FirstAccount.GetID(), tc.FirstAccount)
-		db.Setnx(tc.SecondAccount.GetID(), tc.SecondAccount)
+		for _, account := range []*account.Account{tc.FirstAccount, tc.SecondAccount} {
+			db.Setnx(account.GetID(), account)
+			ctx.Ledger().Record(ledger.NewOpeningEntry(account.GetID(), account.Denomination(), account.Balance))
+		}
 	}
 
 	return ctx, testTable
 }
+
+func TestRecoverCurrencies(t *testing.T) {
+	dir := t.TempDir()
+	open := func() (*ctx.DefaultContext, *repository.AccountRepository) {
+		app := ctx.NewDefaultContext().
+			WithDurability(dir, memorydb.WALOptions{Sync: memorydb.SyncAlways}).
+			WithMemoryDB().
+			WithLedger().
+			WithTransactions()
+		return app, repository.NewAccountRepository(app)
+	}
+
+	app, repo := open()
+	dinars := account.NewAccount("Yambee", "KWD", money.New(1234, 3))
+	yen := account.NewAccount("Trudoo", "JPY", money.New(100, 0))
+	for _, opened := range []*account.Account{dinars, yen} {
+		if err := repo.Create(opened); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, deposit, err := repo.Deposit(context.Background(), account.ExternalRequest{
+		Account:   dinars.GetID(),
+		Amount:    money.New(5, 3),
+		Reference: "receipt-1",
+		Channel:   "cash",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Exit()
+
+	check := func(stage string, app *ctx.DefaultContext, repo *repository.AccountRepository) {
+		for key, expected := range map[string]string{dinars.GetID(): "1.239", yen.GetID(): "100"} {
+			if stored, err := repo.GetByKey(key, memorydb.ConcurrentNotSafe); err != nil || stored.Balance.String() != expected {
+				t.Errorf("%s: expected %s to be recovered with %s but got %v %v", stage, key, expected, stored, err)
+			}
+		}
+		if recorded, err := app.Transactions().Get(deposit.ID.String()); err != nil || recorded.Amount.String() != "0.005" {
+			t.Errorf("%s: expected the deposit amount to be recovered but got %v %v", stage, recorded, err)
+		}
+		if mismatches, err := repo.Reconcile(); err != nil || len(mismatches) != 0 {
+			t.Errorf("%s: expected the balances to match the ledger but got %v %v", stage, mismatches, err)
+		}
+	}
+
+	// the first reopen replays the write-ahead logs, the second one loads the snapshots.
+	for _, stage := range []string{"log", "snapshot"} {
+		app, repo := open()
+		check(stage, app, repo)
+		app.Snapshot()
+		app.Exit()
+	}
+}
+
+func TestQuoteIsKeptWhenTransferFails(t *testing.T) {
+	app := ctx.NewDefaultContext().WithMemoryDB().WithLedger().WithTransactions()
+	rate, _ := fx.ParseRate("150")
+	if err := app.FX().SetRates([]fx.TableRate{{From: "USD", To: "JPY", Rate: rate}}); err != nil {
+		t.Fatal(err)
+	}
+	repo := repository.NewAccountRepository(app)
+	dollars := account.NewAccount("Yambee", "USD", money.New(10000, 2))
+	// crediting anything more than 807 yen overflows the balance, after the amount was converted.
+	full := account.NewAccount("Trudoo", "JPY", money.New(9223372036854775000, 0))
+	yen := account.NewAccount("Quinu", "JPY", money.New(0, 0))
+	for _, opened := range []*account.Account{dollars, full, yen} {
+		if err := repo.Create(opened); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	quote, err := app.FX().Quote("USD", "JPY")
+	if err != nil {
+		t.Fatal(err)
+	}
+	transfer := func(receiver *account.Account) error {
+		_, _, err := repo.TransferMoney(context.Background(), account.TransferRequest{
+			Sender:   dollars.GetID(),
+			Reciever: receiver.GetID(),
+			Amount:   money.New(1000, 2),
+			QuoteID:  quote.ID.String(),
+		})
+		return err
+	}
+
+	if err := transfer(full); !errors.Is(err, money.ErrOverflow) {
+		t.Fatalf("expected the credit to overflow but got %v", err)
+	}
+	if err := transfer(yen); err != nil {
+		t.Errorf("expected the quote to be kept after the failed transfer but got %v", err)
+	}
+	if err := transfer(yen); !errors.Is(err, fx.ErrQuoteNotFound) {
+		t.Errorf("expected the quote to be used up by the committed transfer but got %v", err)
+	}
+}
@@ -0,0 +1,38 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/0xSherlokMo/banking-system-challenge/ctx"
+	"github.com/0xSherlokMo/banking-system-challenge/memorydb"
+	"github.com/0xSherlokMo/banking-system-challenge/transaction"
+	"github.com/google/uuid"
+)
+
+type TransactionRepository struct {
+	ctx *ctx.DefaultContext
+}
+
+func NewTransactionRepository(ctx *ctx.DefaultContext) *TransactionRepository {
+	return &TransactionRepository{
+		ctx: ctx,
+	}
+}
+
+func (t *TransactionRepository) GetByID(id string) (*transaction.Transaction, error) {
+	return t.ctx.Transactions().Get(id)
+}
+
+func (t *TransactionRepository) ListByAccount(account uuid.UUID, query transaction.Query) (transaction.Page, error) {
+	return t.ctx.Transactions().List(account, query)
+}
+
+// Version returns the version of the latest recorded transaction.
+func (t *TransactionRepository) Version() uint64 {
+	return t.ctx.Transactions().Version()
+}
+
+// Watch streams the transactions recorded after the given version, 0 only streams new ones.
+func (t *TransactionRepository) Watch(watchCtx context.Context, fromVersion uint64) (memorydb.Subscription[*transaction.Transaction], error) {
+	return t.ctx.Transactions().Watch(watchCtx, memorydb.WatchOptions{FromVersion: fromVersion})
+}
@@ -0,0 +1,221 @@
+package seed
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/0xSherlokMo/banking-system-challenge/account"
+	"github.com/0xSherlokMo/banking-system-challenge/money"
+	"github.com/google/uuid"
+)
+
+type Format string
+
+const (
+	// FormatJSON is a JSON array of accounts, like the challenge seed.
+	FormatJSON Format = "json"
+
+	// FormatJSONL is an account JSON object per line, blank lines are skipped.
+	FormatJSONL Format = "jsonl"
+
+	// FormatCSV has a header row naming the id, name, balance and optional currency columns, in any order.
+	FormatCSV Format = "csv"
+)
+
+var (
+	ErrUnknownFormat = errors.New("unknown seed format")
+	ErrMissingID     = errors.New("missing id")
+	ErrNegative      = errors.New("negative balance")
+	ErrDuplicateID   = errors.New("duplicate id")
+)
+
+// RowError is a seed row that can't be loaded. Rows are counted from 1: the array index for JSON,
+// and the line for JSONL and CSV (the CSV header being line 1).
+type RowError struct {
+	Row int
+	Err error
+}
+
+func (r *RowError) Error() string {
+	return fmt.Sprintf("row %d: %v", r.Row, r.Err)
+}
+
+func (r *RowError) Unwrap() error {
+	return r.Err
+}
+
+// Decode reads the accounts in the given format. Every invalid row is reported, not only the first one.
+func Decode(r io.Reader, format Format) ([]account.Account, error) {
+	switch format {
+	case FormatJSON:
+		return decodeJSON(r)
+	case FormatJSONL:
+		return decodeJSONL(r)
+	case FormatCSV:
+		return decodeCSV(r)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownFormat, format)
+	}
+}
+
+func decodeJSON(r io.Reader) ([]account.Account, error) {
+	var rows []json.RawMessage
+	if err := json.NewDecoder(r).Decode(&rows); err != nil {
+		return nil, fmt.Errorf("cannot decode accounts: %w", err)
+	}
+
+	var c collector
+	for idx, row := range rows {
+		parsed := account.Account{Balance: money.Zero(money.MaxCurrencyScale)}
+		if err := json.Unmarshal(row, &parsed); err != nil {
+			c.fail(idx+1, err)
+			continue
+		}
+		c.add(idx+1, parsed)
+	}
+	return c.result()
+}
+
+func decodeJSONL(r io.Reader) ([]account.Account, error) {
+	var c collector
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		row := bytes.TrimSpace(scanner.Bytes())
+		if len(row) == 0 {
+			continue
+		}
+		parsed := account.Account{Balance: money.Zero(money.MaxCurrencyScale)}
+		if err := json.Unmarshal(row, &parsed); err != nil {
+			c.fail(line, err)
+			continue
+		}
+		c.add(line, parsed)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("cannot read accounts: %w", err)
+	}
+	return c.result()
+}
+
+func decodeCSV(r io.Reader) ([]account.Account, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read csv header: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for idx, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = idx
+	}
+	for _, required := range []string{"id", "name", "balance"} {
+		if _, found := columns[required]; !found {
+			return nil, fmt.Errorf("csv header has no %s column", required)
+		}
+	}
+
+	var c collector
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				c.fail(parseErr.Line, parseErr.Err)
+				continue
+			}
+			return nil, fmt.Errorf("cannot read accounts: %w", err)
+		}
+		line, _ := reader.FieldPos(0)
+
+		field := func(name string) string {
+			if idx, found := columns[name]; found && idx < len(record) {
+				return strings.TrimSpace(record[idx])
+			}
+			return ""
+		}
+		var parsed account.Account
+		if id := field("id"); id != "" {
+			if parsed.ID, err = uuid.Parse(id); err != nil {
+				c.fail(line, fmt.Errorf("invalid id %q: %w", id, err))
+				continue
+			}
+		}
+		parsed.Name = field("name")
+		parsed.Currency = money.Currency(field("currency"))
+		if parsed.Balance, err = money.Parse(field("balance"), money.MaxCurrencyScale); err != nil {
+			c.fail(line, fmt.Errorf("invalid balance %q: %w", field("balance"), err))
+			continue
+		}
+		c.add(line, parsed)
+	}
+	return c.result()
+}
+
+// collector validates the decoded rows and gathers the errors of every invalid one.
+type collector struct {
+	accounts []account.Account
+	seen     map[uuid.UUID]int
+	errs     []error
+}
+
+func (c *collector) fail(row int, err error) {
+	c.errs = append(c.errs, &RowError{Row: row, Err: err})
+}
+
+// Balances are decoded at the largest exponent, and validated against the currency of their row here.
+// Rows without a currency are in money.DefaultCurrency.
+func (c *collector) add(row int, parsed account.Account) {
+	if parsed.ID == uuid.Nil {
+		c.fail(row, ErrMissingID)
+		return
+	}
+
+	currency := money.DefaultCurrency
+	if parsed.Currency != "" {
+		var err error
+		if currency, err = money.ParseCurrency(string(parsed.Currency)); err != nil {
+			c.fail(row, err)
+			return
+		}
+	}
+	balance, err := currency.Amount(parsed.Balance)
+	if err != nil {
+		c.fail(row, fmt.Errorf("invalid balance %s for %s: %w", parsed.Balance, currency, err))
+		return
+	}
+	if balance.Sign() < 0 {
+		c.fail(row, fmt.Errorf("%w %s", ErrNegative, balance))
+		return
+	}
+	parsed.Currency, parsed.Balance = currency, balance
+
+	if c.seen == nil {
+		c.seen = make(map[uuid.UUID]int)
+	}
+	if first, duplicate := c.seen[parsed.ID]; duplicate {
+		c.fail(row, fmt.Errorf("%w %s, first seen in row %d", ErrDuplicateID, parsed.ID, first))
+		return
+	}
+	c.seen[parsed.ID] = row
+	c.accounts = append(c.accounts, parsed)
+}
+
+func (c *collector) result() ([]account.Account, error) {
+	if len(c.errs) > 0 {
+		return nil, errors.Join(c.errs...)
+	}
+	return c.accounts, nil
+}
@@ -0,0 +1,160 @@
+// Package seed loads the accounts the database starts with, from a file, stdin or a URL.
+package seed
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/0xSherlokMo/banking-system-challenge/account"
+)
+
+const (
+	// ChallengeURL is the seed sent with the challenge, loaded when no other source is configured.
+	ChallengeURL = "https://gist.githubusercontent.com/paytabs-engineering/c470210ebb19511a4e744aefc871974f/raw/6296df58428c89b8f852a6a83b0a5d0ac38289b6/accounts-mock.json"
+
+	// DefaultTimeout is how long a URL seed can take to download.
+	DefaultTimeout = 30 * time.Second
+)
+
+// Loader reads the accounts to seed the database with.
+type Loader interface {
+	Load(loadCtx context.Context) ([]account.Account, error)
+
+	// String describes the source in the logs.
+	String() string
+}
+
+// None starts without any account.
+type None struct{}
+
+func (None) Load(context.Context) ([]account.Account, error) {
+	return nil, nil
+}
+
+func (None) String() string {
+	return "none"
+}
+
+// File reads the accounts from a local file, its format is guessed from its extension when it's not set.
+type File struct {
+	Path   string
+	Format Format
+}
+
+func (f File) Load(context.Context) ([]account.Account, error) {
+	file, err := os.Open(f.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return Decode(file, formatOf(f.Format, f.Path))
+}
+
+func (f File) String() string {
+	return f.Path
+}
+
+// Reader reads the accounts from a stream, stdin when In is nil.
+type Reader struct {
+	In     io.Reader
+	Format Format
+}
+
+func (r Reader) Load(context.Context) ([]account.Account, error) {
+	in := r.In
+	if in == nil {
+		in = os.Stdin
+	}
+	return Decode(in, formatOf(r.Format, ""))
+}
+
+func (r Reader) String() string {
+	return fmt.Sprintf("stdin (%s)", formatOf(r.Format, ""))
+}
+
+// URL downloads the accounts with a GET request, its format is guessed from the URL path when it's not set.
+type URL struct {
+	URL    string
+	Format Format
+	Client *http.Client
+}
+
+func (u URL) Load(loadCtx context.Context) ([]account.Account, error) {
+	client := u.Client
+	if client == nil {
+		client = &http.Client{Timeout: DefaultTimeout}
+	}
+
+	request, err := http.NewRequestWithContext(loadCtx, http.MethodGet, u.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected http GET status %d", res.StatusCode)
+	}
+
+	return Decode(res.Body, formatOf(u.Format, path.Base(request.URL.Path)))
+}
+
+func (u URL) String() string {
+	return u.URL
+}
+
+// Parse picks the loader of a configured source:
+//
+//	none                    no account
+//	stdin, stdin:csv        stdin, JSON unless a format is given
+//	http://..., https://... a URL
+//	file:./accounts.csv     a local file, the file: prefix is optional
+//
+// The format of files and URLs is guessed from their extension (.json, .jsonl, .ndjson or .csv), JSON by default.
+func Parse(source string) (Loader, error) {
+	source = strings.TrimSpace(source)
+	switch {
+	case source == "":
+		return URL{URL: ChallengeURL}, nil
+	case source == "none":
+		return None{}, nil
+	case source == "stdin" || strings.HasPrefix(source, "stdin:"):
+		format := Format(strings.TrimPrefix(strings.TrimPrefix(source, "stdin"), ":"))
+		if format != "" && !format.valid() {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownFormat, format)
+		}
+		return Reader{Format: format}, nil
+	case strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://"):
+		return URL{URL: source}, nil
+	default:
+		return File{Path: strings.TrimPrefix(source, "file:")}, nil
+	}
+}
+
+func (f Format) valid() bool {
+	return f == FormatJSON || f == FormatJSONL || f == FormatCSV
+}
+
+// formatOf returns the format if it's set, or the one of the file name extension.
+func formatOf(format Format, name string) Format {
+	if format != "" {
+		return format
+	}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".jsonl", ".ndjson":
+		return FormatJSONL
+	case ".csv":
+		return FormatCSV
+	default:
+		return FormatJSON
+	}
+}
@@ -0,0 +1,129 @@
+package seed_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/0xSherlokMo/banking-system-challenge/money"
+	"github.com/0xSherlokMo/banking-system-challenge/seed"
+)
+
+const (
+	first  = "0a637cbd-5aec-4c3b-8bf0-d8a5eb95024c"
+	second = "662178e0-e898-4fa0-a5ac-70951a564f7c"
+)
+
+func TestDecode(t *testing.T) {
+	cases := map[seed.Format]string{
+		seed.FormatJSON:  fmt.Sprintf(`[{"id": %q, "name": "Yambee", "balance": "3002.90"}, {"id": %q, "name": "Trudoo", "balance": 89.5}]`, first, second),
+		seed.FormatJSONL: fmt.Sprintf("{\"id\": %q, \"name\": \"Yambee\", \"balance\": \"3002.90\"}\n\n{\"id\": %q, \"name\": \"Trudoo\", \"balance\": 89.5}\n", first, second),
+		seed.FormatCSV:   fmt.Sprintf("name,id,balance\nYambee,%s,3002.90\nTrudoo,%s,89.5\n", first, second),
+	}
+
+	for format, input := range cases {
+		accounts, err := seed.Decode(strings.NewReader(input), format)
+		if err != nil {
+			t.Fatalf("%s: cannot decode: %v", format, err)
+		}
+		if len(accounts) != 2 || accounts[0].ID.String() != first || accounts[0].Name != "Yambee" ||
+			accounts[0].Balance.Cmp(money.MustParse("3002.90")) != 0 || accounts[1].Balance.Cmp(money.MustParse("89.50")) != 0 {
+			t.Errorf("%s: expected both accounts but got %+v", format, accounts)
+		}
+	}
+}
+
+func TestDecodeNamesInvalidRows(t *testing.T) {
+	input := fmt.Sprintf(
+		"id,name,balance\n%s,Yambee,10\nnot-an-id,Trudoo,10\n%s,Trudoo,-5\n%s,Again,1\n%s,Quinu,1.001\n",
+		first, second, first, second,
+	)
+
+	_, err := seed.Decode(strings.NewReader(input), seed.FormatCSV)
+	if !errors.Is(err, seed.ErrNegative) || !errors.Is(err, seed.ErrDuplicateID) || !errors.Is(err, money.ErrTooPrecise) {
+		t.Fatalf("expected every invalid row to be reported but got %v", err)
+	}
+	for _, row := range []string{"row 3: invalid id", "row 4: negative balance", "row 5: duplicate id", "first seen in row 2", "row 6: invalid balance"} {
+		if !strings.Contains(err.Error(), row) {
+			t.Errorf("expected %q in %v", row, err)
+		}
+	}
+
+	_, err = seed.Decode(strings.NewReader(`[{"name": "Yambee", "balance": "1"}]`), seed.FormatJSON)
+	var rowErr *seed.RowError
+	if !errors.As(err, &rowErr) || rowErr.Row != 1 || !errors.Is(err, seed.ErrMissingID) {
+		t.Errorf("expected the row without id to be reported but got %v", err)
+	}
+}
+
+func TestDecodeCurrencies(t *testing.T) {
+	input := fmt.Sprintf("id,name,balance,currency\n%s,Yambee,1000,jpy\n%s,Trudoo,1.234,KWD\n", first, second)
+	accounts, err := seed.Decode(strings.NewReader(input), seed.FormatCSV)
+	if err != nil {
+		t.Fatalf("cannot decode: %v", err)
+	}
+	if accounts[0].Currency != "JPY" || accounts[0].Balance.String() != "1000" || accounts[1].Balance.String() != "1.234" {
+		t.Errorf("expected the balances at their currency exponent but got %+v", accounts)
+	}
+
+	input = fmt.Sprintf("id,name,balance,currency\n%s,Yambee,10.5,JPY\n%s,Trudoo,1,XYZ\n", first, second)
+	_, err = seed.Decode(strings.NewReader(input), seed.FormatCSV)
+	if !errors.Is(err, money.ErrTooPrecise) || !errors.Is(err, money.ErrUnknownCurrency) {
+		t.Errorf("expected the invalid currencies to be reported but got %v", err)
+	}
+
+	accounts, _ = seed.Decode(strings.NewReader(fmt.Sprintf(`[{"id": %q, "balance": "89.5"}]`, first)), seed.FormatJSON)
+	if len(accounts) != 1 || accounts[0].Currency != money.DefaultCurrency || accounts[0].Balance.String() != "89.50" {
+		t.Errorf("expected the account in the default currency but got %+v", accounts)
+	}
+}
+
+func TestLoaders(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "accounts.jsonl")
+	os.WriteFile(path, []byte(fmt.Sprintf("{\"id\": %q, \"balance\": \"1\"}\n", first)), 0o644)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/accounts.csv" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, "id,name,balance\n%s,Yambee,1\n", first)
+	}))
+	defer server.Close()
+
+	cases := map[string]int{
+		"none":                           0,
+		path:                             1,
+		"file:" + path:                   1,
+		server.URL + "/accounts.csv":     1,
+		server.URL + "/accounts.csv?x=1": 1,
+	}
+	for source, expected := range cases {
+		loader, err := seed.Parse(source)
+		if err != nil {
+			t.Fatalf("%s: cannot parse source: %v", source, err)
+		}
+		accounts, err := loader.Load(context.Background())
+		if err != nil || len(accounts) != expected {
+			t.Errorf("%s: expected %d accounts but got %d %v", source, expected, len(accounts), err)
+		}
+	}
+
+	if _, err := seed.Parse("stdin:xml"); !errors.Is(err, seed.ErrUnknownFormat) {
+		t.Errorf("expected an unknown stdin format to be rejected but got %v", err)
+	}
+	loader := seed.Reader{In: strings.NewReader(fmt.Sprintf("id,name,balance\n%s,Yambee,1\n", first)), Format: seed.FormatCSV}
+	if accounts, err := loader.Load(context.Background()); err != nil || len(accounts) != 1 {
+		t.Errorf("expected the account read from the stream but got %+v %v", accounts, err)
+	}
+	if _, err := (seed.URL{URL: server.URL + "/missing.json"}).Load(context.Background()); err == nil {
+		t.Errorf("expected a failed download to be reported")
+	}
+}
@@ -0,0 +1,210 @@
+// Package transaction keeps a record of every transfer, deposit and withdrawal attempt and indexes it per account,
+// so the history of an account can be listed page by page.
+package transaction
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/0xSherlokMo/banking-system-challenge/memorydb"
+	"github.com/google/uuid"
+)
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// Store is the subset of the database the history needs to persist transactions.
+type Store interface {
+	Setnx(key string, record *Transaction) error
+	GetM(terms []string, opts memorydb.Opts) []*Transaction
+	Get(key string, opts memorydb.Opts) (*Transaction, error)
+	Keys() []memorydb.Key
+}
+
+// Feed is implemented by stores that stream their writes, like memorydb.
+type Feed interface {
+	Version() uint64
+	Watch(watchCtx context.Context, prefix string, opts memorydb.WatchOptions) (memorydb.Subscription[*Transaction], error)
+}
+
+// ErrNotWatchable is returned when watching a history whose store doesn't implement Feed.
+var ErrNotWatchable = errors.New("transactions can't be watched")
+
+type Direction string
+
+const (
+	DirectionAll      Direction = ""
+	DirectionIncoming Direction = "incoming"
+	DirectionOutgoing Direction = "outgoing"
+)
+
+// Query filters the history of an account. Zero values mean no filter.
+type Query struct {
+	Cursor    string
+	Limit     int
+	From      time.Time
+	To        time.Time
+	Direction Direction
+}
+
+type Page struct {
+	Transactions []*Transaction `json:"transactions"`
+	NextCursor   string         `json:"next_cursor,omitempty"`
+}
+
+type History struct {
+	store    Store
+	mu       sync.RWMutex
+	sequence uint64
+	// index holds the transactions of every account, ordered by sequence.
+	index map[uuid.UUID][]*Transaction
+}
+
+func New(store Store) *History {
+	h := &History{
+		store: store,
+		index: make(map[uuid.UUID][]*Transaction),
+	}
+
+	transactions := store.GetM(store.Keys(), memorydb.Opts{Safe: memorydb.ConcurrentNotSafe})
+	sort.Slice(transactions, func(i, j int) bool {
+		return transactions[i].Sequence < transactions[j].Sequence
+	})
+	for _, transaction := range transactions {
+		h.indexTransaction(transaction)
+	}
+
+	return h
+}
+
+// Record assigns the transaction its sequence number, stores it and indexes it for both accounts.
+func (h *History) Record(transaction *Transaction) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	transaction.Sequence = h.sequence + 1
+	if err := h.store.Setnx(transaction.GetID(), transaction); err != nil {
+		return err
+	}
+	h.indexTransaction(transaction)
+	return nil
+}
+
+func (h *History) Get(id string) (*Transaction, error) {
+	return h.store.Get(Key(id), memorydb.Opts{Safe: memorydb.ConcurrentNotSafe})
+}
+
+// List returns the transactions of an account, newest first.
+func (h *History) List(account uuid.UUID, query Query) (Page, error) {
+	before := uint64(0)
+	if query.Cursor != "" {
+		var err error
+		before, err = decodeCursor(query.Cursor)
+		if err != nil {
+			return Page{}, err
+		}
+	}
+
+	limit := query.Limit
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	page := Page{Transactions: []*Transaction{}}
+	transactions := h.index[account]
+	for i := len(transactions) - 1; i >= 0; i-- {
+		transaction := transactions[i]
+		if before != 0 && transaction.Sequence >= before {
+			continue
+		}
+		if !query.matches(account, transaction) {
+			continue
+		}
+
+		if len(page.Transactions) == limit {
+			page.NextCursor = encodeCursor(page.Transactions[limit-1].Sequence)
+			break
+		}
+		page.Transactions = append(page.Transactions, transaction)
+	}
+
+	return page, nil
+}
+
+func (q Query) matches(account uuid.UUID, transaction *Transaction) bool {
+	if !q.From.IsZero() && transaction.CreatedAt.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && !transaction.CreatedAt.Before(q.To) {
+		return false
+	}
+
+	switch q.Direction {
+	case DirectionIncoming:
+		return transaction.Receiver == account
+	case DirectionOutgoing:
+		return transaction.Sender == account
+	}
+	return true
+}
+
+func (h *History) indexTransaction(transaction *Transaction) {
+	if transaction.Sequence > h.sequence {
+		h.sequence = transaction.Sequence
+	}
+
+	// the external side of deposits and withdrawals is uuid.Nil, it isn't an account.
+	if transaction.Sender != uuid.Nil {
+		h.index[transaction.Sender] = append(h.index[transaction.Sender], transaction)
+	}
+	if transaction.Receiver != transaction.Sender && transaction.Receiver != uuid.Nil {
+		h.index[transaction.Receiver] = append(h.index[transaction.Receiver], transaction)
+	}
+}
+
+func encodeCursor(sequence uint64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatUint(sequence, 10)))
+}
+
+func decodeCursor(cursor string) (uint64, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+	sequence, err := strconv.ParseUint(string(decoded), 10, 64)
+	if err != nil || sequence == 0 {
+		return 0, ErrInvalidCursor
+	}
+	return sequence, nil
+}
+
+// Version returns the version of the latest recorded transaction, to watch the transactions recorded after it.
+func (h *History) Version() uint64 {
+	feed, ok := h.store.(Feed)
+	if !ok {
+		return 0
+	}
+	return feed.Version()
+}
+
+// Watch streams the transactions recorded after the given version, see memorydb.MemoryDB.Watch.
+func (h *History) Watch(watchCtx context.Context, opts memorydb.WatchOptions) (memorydb.Subscription[*Transaction], error) {
+	feed, ok := h.store.(Feed)
+	if !ok {
+		return nil, ErrNotWatchable
+	}
+	return feed.Watch(watchCtx, TransactionIdPrefix, opts)
+}
@@ -0,0 +1,62 @@
+package transaction_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/0xSherlokMo/banking-system-challenge/memorydb"
+	"github.com/0xSherlokMo/banking-system-challenge/money"
+	"github.com/0xSherlokMo/banking-system-challenge/transaction"
+	"github.com/google/uuid"
+)
+
+func TestListPagesThroughAccountHistory(t *testing.T) {
+	history := transaction.New(memorydb.Default[*transaction.Transaction]())
+	first, second, third := uuid.New(), uuid.New(), uuid.New()
+
+	var recorded []*transaction.Transaction
+	for i := 0; i < 5; i++ {
+		record := transaction.NewTransaction(first, second, money.MustParse("1"))
+		if i%2 == 1 {
+			record = transaction.NewTransaction(second, first, money.MustParse("1"))
+		}
+		history.Record(record)
+		recorded = append(recorded, record)
+	}
+	history.Record(transaction.NewTransaction(second, third, money.MustParse("1")))
+
+	page, err := history.List(first, transaction.Query{Limit: 2})
+	if err != nil || len(page.Transactions) != 2 || page.NextCursor == "" {
+		t.Fatalf("expected a full first page but got %+v %v", page, err)
+	}
+	if page.Transactions[0].ID != recorded[4].ID || page.Transactions[1].ID != recorded[3].ID {
+		t.Errorf("expected newest transactions first")
+	}
+
+	seen := len(page.Transactions)
+	for page.NextCursor != "" {
+		page, err = history.List(first, transaction.Query{Limit: 2, Cursor: page.NextCursor})
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		seen += len(page.Transactions)
+	}
+	if seen != 5 {
+		t.Errorf("expected to page through 5 transactions but got %d", seen)
+	}
+
+	page, _ = history.List(first, transaction.Query{Direction: transaction.DirectionIncoming})
+	if len(page.Transactions) != 2 {
+		t.Errorf("expected 2 incoming transactions but got %d", len(page.Transactions))
+	}
+
+	page, _ = history.List(first, transaction.Query{From: time.Now().Add(time.Hour)})
+	if len(page.Transactions) != 0 {
+		t.Errorf("expected no transactions in the future but got %d", len(page.Transactions))
+	}
+
+	_, err = history.List(first, transaction.Query{Cursor: "not-a-cursor"})
+	if err != transaction.ErrInvalidCursor {
+		t.Errorf("expected ErrInvalidCursor but got %v", err)
+	}
+}
@@ -0,0 +1,134 @@
+// Description: Transaction package models and errors.
+
+package transaction
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/0xSherlokMo/banking-system-challenge/fx"
+	"github.com/0xSherlokMo/banking-system-challenge/money"
+	"github.com/google/uuid"
+)
+
+const (
+	TransactionIdPrefix = "transaction-"
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+type Status string
+
+const (
+	StatusCompleted Status = "completed"
+	StatusFailed    Status = "failed"
+)
+
+// Kind is what moved the money, transactions recorded before kinds were added are transfers.
+type Kind string
+
+const (
+	KindTransfer   Kind = "transfer"
+	KindDeposit    Kind = "deposit"
+	KindWithdrawal Kind = "withdrawal"
+)
+
+type Transaction struct {
+	ID       uuid.UUID   `json:"id"`
+	Sequence uint64      `json:"sequence"`
+	Kind     Kind        `json:"kind,omitempty"`
+	Sender   uuid.UUID   `json:"sender"`
+	Receiver uuid.UUID   `json:"receiver"`
+	Amount   money.Money `json:"amount"`
+	// Currency is the currency of the amount, the sender's one for transfers.
+	Currency  money.Currency `json:"currency,omitempty"`
+	Status    Status         `json:"status"`
+	Reason    string         `json:"reason,omitempty"`
+	EntryID   *uuid.UUID     `json:"entry_id,omitempty"`
+	CreatedAt time.Time      `json:"created_at"`
+
+	// External describes the counterparty outside the bank of a deposit or withdrawal,
+	// the side of the transaction that's outside the bank is uuid.Nil.
+	External *External `json:"external,omitempty"`
+
+	// Conversion holds both legs and the rate of a transfer between accounts in different currencies,
+	// Amount and Currency are its source leg.
+	Conversion *fx.Conversion `json:"conversion,omitempty"`
+}
+
+// External is the counterparty of a deposit or withdrawal, as told by the client.
+type External struct {
+	Reference string `json:"reference"`
+	Channel   string `json:"channel"`
+	Memo      string `json:"memo,omitempty"`
+}
+
+func NewTransaction(sender uuid.UUID, receiver uuid.UUID, amount money.Money) *Transaction {
+	return &Transaction{
+		ID:        uuid.New(),
+		Kind:      KindTransfer,
+		Sender:    sender,
+		Receiver:  receiver,
+		Amount:    amount,
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+// NewDeposit records money coming into the account from the external counterparty.
+func NewDeposit(receiver uuid.UUID, amount money.Money, external External) *Transaction {
+	deposit := NewTransaction(uuid.Nil, receiver, amount)
+	deposit.Kind = KindDeposit
+	deposit.External = &external
+	return deposit
+}
+
+// NewWithdrawal records money leaving the account to the external counterparty.
+func NewWithdrawal(sender uuid.UUID, amount money.Money, external External) *Transaction {
+	withdrawal := NewTransaction(sender, uuid.Nil, amount)
+	withdrawal.Kind = KindWithdrawal
+	withdrawal.External = &external
+	return withdrawal
+}
+
+// UnmarshalJSON decodes the amount at the scale of its currency, the transactions recorded before currencies
+// were added are in DefaultCurrency.
+func (t *Transaction) UnmarshalJSON(data []byte) error {
+	type stored Transaction
+	if err := json.Unmarshal(data, (*stored)(t)); err != nil {
+		return err
+	}
+	currency := t.Currency
+	if currency == "" {
+		currency = money.DefaultCurrency
+	}
+	amount, err := currency.Amount(t.Amount)
+	if err != nil {
+		return fmt.Errorf("amount of %s: %w", currency, err)
+	}
+	t.Amount = amount
+	return nil
+}
+
+func Key(id string) string {
+	return fmt.Sprintf("%s-%s", TransactionIdPrefix, id)
+}
+
+func (t *Transaction) GetID() string {
+	return Key(t.ID.String())
+}
+
+// Complete marks the transaction as applied, linking it to the ledger entry that moved the money.
+func (t *Transaction) Complete(entryID uuid.UUID) {
+	t.Status = StatusCompleted
+	t.EntryID = &entryID
+}
+
+// Fail marks the transaction as rejected with the reason it was rejected for.
+func (t *Transaction) Fail(reason error) {
+	t.Status = StatusFailed
+	t.Reason = reason.Error()
+}